@@ -2,9 +2,20 @@ package config
 
 import (
 	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
@@ -15,34 +26,394 @@ import (
 // Config 网关配置结构体，定义所有配置参数
 type Config struct {
 	// Upstream 上游服务配置
-	Upstream struct {
-		URL string `yaml:"url"` // 上游Claude API地址
-		Key string `yaml:"key"` // 上游API密钥
-	} `yaml:"upstream"`
+	Upstream UpstreamConfig `yaml:"upstream"`
 
 	// Server 服务器配置
 	Server struct {
-		Port int `yaml:"port"` // 服务监听端口
+		Host string `yaml:"host"` // 服务监听地址，为空时监听所有网卡
+		Port int    `yaml:"port"` // 服务监听端口
+
+		UnixSocket string `yaml:"unix_socket"` // Unix域套接字路径，设置后监听该套接字而不是TCP端口
+
+		AllowedIPs []string `yaml:"allowed_ips"` // 允许访问的客户端IP或CIDR，为空时不限制
+		DeniedIPs  []string `yaml:"denied_ips"`  // 拒绝访问的客户端IP或CIDR，优先于allowed_ips
+		TrustProxy bool     `yaml:"trust_proxy"` // 是否使用X-Forwarded-For中的客户端IP（仅在反向代理之后开启）
+
+		MaxConcurrent       int           `yaml:"max_concurrent"`       // 同时处理的最大请求数，0表示不限制
+		ConcurrencyOverflow string        `yaml:"concurrency_overflow"` // 超出并发上限时的处理方式: reject/queue
+		QueueTimeout        time.Duration `yaml:"queue_timeout"`        // queue模式下排队等待的最长时间
+
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout"` // 关闭时等待进行中请求结束的最长时间
+
+		MaxBodyBytes int64 `yaml:"max_body_bytes"` // 读取请求体允许的最大字节数，超出即停止读取并返回413
+
+		// CORS 浏览器直接访问时的跨域配置
+		CORS struct {
+			Enabled        *bool         `yaml:"enabled"`         // 是否启用，默认启用
+			AllowedOrigins []string      `yaml:"allowed_origins"` // 允许的来源，*表示全部
+			AllowedMethods []string      `yaml:"allowed_methods"` // 预检请求允许的方法
+			AllowedHeaders []string      `yaml:"allowed_headers"` // 预检请求允许的请求头，*表示浏览器请求的全部请求头
+			MaxAge         time.Duration `yaml:"max_age"`         // 预检结果的缓存时间
+		} `yaml:"cors"`
+
+		// Timeouts HTTP服务的超时设置
+		Timeouts struct {
+			Read  time.Duration `yaml:"read"`  // 读取整个请求（含请求体）的超时时间
+			Write time.Duration `yaml:"write"` // 写出响应的超时时间，限制了流式响应的最长时长
+			Idle  time.Duration `yaml:"idle"`  // 长连接空闲等待下一个请求的超时时间
+		} `yaml:"timeouts"`
 	} `yaml:"server"`
 
 	// Auth 认证配置
 	Auth struct {
-		Key string `yaml:"key"` // 下游客户端验证密钥
+		Key     string    `yaml:"key"`      // 下游客户端验证密钥
+		KeyFile string    `yaml:"key_file"` // 从文件读取下游客户端验证密钥，与key二选一
+		Keys    []AuthKey `yaml:"keys"`     // 额外的下游密钥，可分别指定注入的user_id
+
+		RateLimit int `yaml:"rate_limit"` // 每个密钥每分钟允许的请求数，0表示不限制
+		Burst     int `yaml:"burst"`      // 每个密钥允许的突发请求数，默认等于rate_limit
 	} `yaml:"auth"`
 
 	// Gateway 网关特定配置
 	Gateway struct {
-		UserID string `yaml:"user_id"` // 固定用户ID，用于伪装成Claude Code请求
+		UserID           string `yaml:"user_id"`            // 固定用户ID，用于伪装成Claude Code请求
+		RegenerateUserID bool   `yaml:"regenerate_user_id"` // 启动时忽略已保存的user_id，重新生成
+		MaxBodyBytes     int64  `yaml:"max_body_bytes"`     // 转换前解析请求体允许的最大字节数，默认与server.max_body_bytes相同
+		MaxJSONDepth     int    `yaml:"max_json_depth"`     // 请求体JSON允许的最大嵌套深度
+
+		PromptDir         string `yaml:"prompt_dir"`          // 系统提示词目录，相对路径基于工作目录
+		PromptLoadWorkers int    `yaml:"prompt_load_workers"` // 并行读取系统提示词文件的工作协程数量
+		WatchPrompts      bool   `yaml:"watch_prompts"`       // 是否监听系统提示词目录，文件变更时自动重新加载
+
+		StreamPingInterval time.Duration `yaml:"stream_ping_interval"` // 流式响应中上游无数据多久后输出保活注释，0表示不输出
+
+		RewriteResponseModel bool              `yaml:"rewrite_response_model"` // 是否将响应（含流式message_start事件）中的model字段改写为下游请求的模型
+		ModelAliases         map[string]string `yaml:"model_aliases"`          // 模型别名到上游实际模型名称的映射，转换时改写请求体的model字段
+
+		InjectThreshold     *int     `yaml:"inject_threshold"`      // 请求体小于该字节数时注入官方提示词
+		SkipInjectionModels []string `yaml:"skip_injection_models"` // 不注入官方提示词的模型，仍插入Claude Code系统消息
+		SizeBasis           string   `yaml:"size_basis"`            // 与注入阈值比较的大小: full整个请求体/text文本内容
+		CacheBreakpoints    string   `yaml:"cache_breakpoints"`     // 注入块的缓存断点位置: all/model_prompt/user_system/none
+		MaxCacheBreakpoints int      `yaml:"max_cache_breakpoints"` // 整个请求中缓存断点的数量上限，system中超出的低优先级块断点被移除
+
+		MaxMergedSystemBlocks int    `yaml:"max_merged_system_blocks"` // 合并为一个块的system消息数量上限，0表示不限制
+		SystemMergeOverflow   string `yaml:"system_merge_overflow"`    // 超出上限的system消息处理方式: separate/truncate
+
+		FileTypes map[string]string `yaml:"file_types"` // 修复空text内容时文件扩展名到文件类型的映射，覆盖内置映射
+
+		StartupCheck      string `yaml:"startup_check"`       // 启动时检查上游连通性和密钥: off/warn/exit
+		StartupCheckModel string `yaml:"startup_check_model"` // 启动检查count_tokens请求使用的模型
 	} `yaml:"gateway"`
+
+	// LoadShedding 自适应限流配置
+	LoadShedding struct {
+		Enabled        bool          `yaml:"enabled"`         // 是否启用
+		Window         time.Duration `yaml:"window"`          // 统计错误率的滑动窗口
+		ErrorThreshold float64       `yaml:"error_threshold"` // 开始限流的错误率阈值
+		MinRequests    int           `yaml:"min_requests"`    // 窗口内最少请求数，不足时不限流
+		MaxShedRate    float64       `yaml:"max_shed_rate"`   // 最大拒绝比例
+	} `yaml:"load_shedding"`
+
+	// CircuitBreaker 上游熔断配置，连续失败达到阈值后在冷却期内直接拒绝请求
+	CircuitBreaker struct {
+		Enabled          bool          `yaml:"enabled"`           // 是否启用
+		FailureThreshold int           `yaml:"failure_threshold"` // 触发熔断的连续失败次数
+		Cooldown         time.Duration `yaml:"cooldown"`          // 熔断后的冷却时间，之后放行一个探测请求
+	} `yaml:"circuit_breaker"`
+
+	// Limits 按模型配置的参数范围，如 {"claude-opus-4-1-20250805": {"max_tokens": [1, 32000]}}
+	Limits map[string]map[string][]float64 `yaml:"limits"`
+
+	// RequestFields 请求体顶层字段配置
+	RequestFields struct {
+		Recognized []string               `yaml:"recognized"` // 已知的顶层字段，未知字段仍透传但会记录日志
+		Defaults   map[string]interface{} `yaml:"defaults"`   // 请求中缺失时注入的字段默认值
+	} `yaml:"request_fields"`
+
+	// Logging 日志配置
+	Logging struct {
+		Format string `yaml:"format"` // 控制台日志格式: text/json
+		Level  string `yaml:"level"`  // 控制台日志级别: debug/info/warn/error
+
+		SaveRequests     *bool `yaml:"save_requests"`      // 是否将请求日志写入磁盘，默认开启，关闭后仍输出控制台日志
+		SaveOnlyFailures bool  `yaml:"save_only_failures"` // 是否只保存失败请求的日志（errors目录）
+		MaxBodyLogBytes  int   `yaml:"max_body_log_bytes"` // 请求日志中每个请求体/响应体保留的最大字节数，0表示不限制
+
+		JSONLPath string `yaml:"jsonl_path"` // 设置后请求日志逐行追加到该JSONL文件，不再按请求写入单独文件
+
+		Async       bool `yaml:"async"`        // 是否在后台协程中异步写入请求日志
+		AsyncBuffer int  `yaml:"async_buffer"` // 异步日志队列长度，队列满时丢弃日志
+
+		AccessLog       string `yaml:"access_log"`        // 访问日志文件路径，为空时不写访问日志
+		AccessLogFormat string `yaml:"access_log_format"` // 访问日志格式: common/combined
+
+		RedactHeaders []string `yaml:"redact_headers"` // 请求日志中需要额外脱敏的请求头（Authorization和x-api-key始终脱敏）
+
+		RetentionDays int `yaml:"retention_days"` // 请求日志保留天数，0表示不按时间清理
+		MaxFiles      int `yaml:"max_files"`      // logs、errors和logs/inprogress目录各自最多保留的日志文件数，0表示不限制
+
+		InProgress           bool  `yaml:"in_progress"`            // 是否在请求进行中写入临时日志，便于观察长时间运行的请求
+		TracePropagation     bool  `yaml:"trace_propagation"`      // 是否将下游traceparent传递给上游（为上游生成新的span ID）
+		RequestIDPropagation *bool `yaml:"request_id_propagation"` // 是否将请求ID通过X-Request-Id头传递给上游，默认开启
+		DebugSSEComments     bool  `yaml:"debug_sse_comments"`     // 是否允许通过X-Gateway-Debug头在流式响应中输出转换决策注释
+
+		DefaultVerbosity string            `yaml:"default_verbosity"` // 请求日志详细程度: full/metadata/none
+		ModelVerbosity   map[string]string `yaml:"model_verbosity"`   // 按模型覆盖的请求日志详细程度
+	} `yaml:"logging"`
+
+	// Policy 部署策略配置，比API允许范围更严格的限制
+	Policy struct {
+		MaxTemperature    *float64 `yaml:"max_temperature"`     // temperature策略上限，未配置时不限制
+		BareRequestAction string   `yaml:"bare_request_action"` // 裸请求处理方式: off/warn/block
+	} `yaml:"policy"`
+
+	// Response 下游响应处理配置
+	Response struct {
+		ErrorRewrites   []RewriteRule `yaml:"error_rewrites"`   // 错误响应体改写规则，仅作用于错误状态码
+		FormatSelection bool          `yaml:"format_selection"` // 是否允许下游选择非流式响应的输出格式
+	} `yaml:"response"`
+
+	// Routes 按模型路由到指定上游，按配置顺序匹配，未匹配时使用upstream
+	Routes []ModelRoute `yaml:"routes"`
+
+	// Mimic 模拟Claude Code客户端的请求头配置
+	Mimic struct {
+		Headers            map[string]string `yaml:"headers"`             // 覆盖默认请求头，值为空字符串时删除该请求头
+		PassthroughHeaders []string          `yaml:"passthrough_headers"` // 从下游请求原样转发到上游的请求头，覆盖同名的模拟请求头
+
+		AllowedAnthropicVersions []string `yaml:"allowed_anthropic_versions"` // 允许下游通过anthropic-version头指定的API版本
+	} `yaml:"mimic"`
+}
+
+// UpstreamConfig 上游服务配置
+//
+// 既可以写成包含url、key等字段的映射，也可以直接写成{url, key}列表
+type UpstreamConfig struct {
+	URL     string `yaml:"url"`      // 上游Claude API地址
+	BaseURL string `yaml:"base_url"` // 上游基础地址，设置后拼接下游请求路径，与url二选一
+	Key     string `yaml:"key"`      // 上游API密钥
+	KeyFile string `yaml:"key_file"` // 从文件读取上游API密钥，与key二选一
+
+	Endpoints []UpstreamEndpoint `yaml:"endpoints"` // 多个上游地址，按轮询方式使用
+	Fallback  UpstreamEndpoint   `yaml:"fallback"`  // 备用上游，所选上游连接失败或返回5xx时使用同一请求体重试一次
+
+	// Auth 上游认证方式配置
+	Auth struct {
+		Scheme string `yaml:"scheme"` // 认证方式: bearer/hmac
+
+		// HMAC 签名认证配置
+		HMAC struct {
+			Secret          string `yaml:"secret"`           // 签名密钥
+			SignatureHeader string `yaml:"signature_header"` // 签名请求头名称
+			TimestampHeader string `yaml:"timestamp_header"` // 时间戳请求头名称
+		} `yaml:"hmac"`
+	} `yaml:"auth"`
+
+	RequestBudget  time.Duration `yaml:"request_budget"`  // 单个请求所有上游尝试的总耗时预算，0表示不限制
+	RequestTimeout time.Duration `yaml:"request_timeout"` // 单次上游请求（含读取响应体）的超时时间，0表示不限制
+
+	ProxyURL string `yaml:"proxy_url"` // 连接上游使用的代理: http://、https://、socks5://或socks5h://，为空时直连
+	HTTP2    bool   `yaml:"http2"`     // 是否允许与上游协商HTTP/2，默认只使用HTTP/1.1
+
+	ExactCaseHeaders []string `yaml:"exact_case_headers"` // 按原样大小写发送的请求头名称
+
+	MaxRetries        int           `yaml:"max_retries"`         // 上游请求最大重试次数
+	RetryBaseDelay    time.Duration `yaml:"retry_base_delay"`    // 重试退避的基础等待时间，每次重试翻倍
+	RetryMaxDelay     time.Duration `yaml:"retry_max_delay"`     // 单次重试等待时间的上限，上游Retry-After超过该值时不再重试
+	RetryBodyPatterns []string      `yaml:"retry_body_patterns"` // 响应体匹配这些正则时重试
+
+	// TLS 上游连接TLS配置
+	TLS struct {
+		MinVersion         string `yaml:"min_version"`          // 最低TLS版本: 1.0/1.1/1.2/1.3
+		InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // 是否跳过上游证书校验，仅用于测试自签名证书的上游
+		CAFile             string `yaml:"ca_file"`              // 额外信任的CA证书文件（PEM），为空时只使用系统证书
+	} `yaml:"tls"`
+
+	MinTLSVersion      string `yaml:"min_tls_version"`      // upstream.tls.min_version的别名
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"` // upstream.tls.insecure_skip_verify的别名
+
+	// Pool 上游连接池配置
+	Pool struct {
+		MaxIdle        int           `yaml:"max_idle"`          // 所有上游合计保留的最大空闲连接数
+		MaxIdlePerHost int           `yaml:"max_idle_per_host"` // 每个上游主机保留的最大空闲连接数
+		IdleTimeout    time.Duration `yaml:"idle_timeout"`      // 空闲连接的保留时间
+	} `yaml:"pool"`
+}
+
+// AuthKey 下游密钥及其对应的用户ID
+type AuthKey struct {
+	Key    string `yaml:"key"`     // 下游客户端验证密钥
+	UserID string `yaml:"user_id"` // 该密钥的请求注入的metadata.user_id，为空时使用gateway.user_id
+}
+
+// UpstreamEndpoint 单个上游地址
+type UpstreamEndpoint struct {
+	URL     string `yaml:"url"`      // 上游Claude API完整地址
+	BaseURL string `yaml:"base_url"` // 上游基础地址，请求时拼接下游请求路径
+	Key     string `yaml:"key"`      // 上游API密钥，为空时使用upstream.key
+}
+
+// ListenAddr 获取服务监听地址
+//
+// 返回值:
+//   - string: host:port形式的监听地址，未配置host时为:port
+func (c *Config) ListenAddr() string {
+	return net.JoinHostPort(c.Server.Host, strconv.Itoa(c.Server.Port))
 }
 
+// Configured 判断是否配置了上游地址
+//
+// 返回值:
+//   - bool: 是否配置了url或base_url
+func (e UpstreamEndpoint) Configured() bool {
+	return e.URL != "" || e.BaseURL != ""
+}
+
+// ModelRoute 模型路由规则
+type ModelRoute struct {
+	Model string `yaml:"model"` // 模型名称，以*结尾时按前缀匹配
+
+	UpstreamEndpoint `yaml:",inline"` // 路由到的上游，未填写key时使用upstream.key
+}
+
+// Matches 判断模型是否匹配此路由
+//
+// 参数:
+//   - model: 请求的模型名称
+//
+// 返回值:
+//   - bool: 是否匹配
+func (r ModelRoute) Matches(model string) bool {
+	if prefix, ok := strings.CutSuffix(r.Model, "*"); ok {
+		return strings.HasPrefix(model, prefix)
+	}
+	return model == r.Model
+}
+
+// UnmarshalYAML 支持将upstream写成{url, key}列表
+//
+// 参数:
+//   - unmarshal: YAML解码函数
+//
+// 返回值:
+//   - error: 可能的错误
+func (u *UpstreamConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var endpoints []UpstreamEndpoint
+	if err := unmarshal(&endpoints); err == nil {
+		u.Endpoints = endpoints
+		return nil
+	}
+
+	// 使用别名类型避免递归调用UnmarshalYAML
+	type plain UpstreamConfig
+	return unmarshal((*plain)(u))
+}
+
+// RewriteRule 正则替换规则
+type RewriteRule struct {
+	Pattern     string `yaml:"pattern"`     // 匹配的正则表达式
+	Replacement string `yaml:"replacement"` // 替换内容，支持$1等分组引用
+}
+
+// DefaultInjectThreshold 默认的官方提示词注入阈值（字节）
+const DefaultInjectThreshold = 20000
+
+// 默认配置值
+const (
+	defaultServerReadTimeout  = 30 * time.Second
+	defaultServerWriteTimeout = 600 * time.Second
+	defaultServerIdleTimeout  = 60 * time.Second
+
+	defaultCORSMaxAge = 10 * time.Minute
+
+	defaultConcurrencyOverflow = "reject"
+	defaultQueueTimeout        = 30 * time.Second
+
+	defaultShutdownTimeout = 30 * time.Second
+
+	defaultMaxBodyBytes = 10 << 20 // 10MB
+	defaultMaxJSONDepth = 128
+
+	defaultBareRequestAction = "warn"
+
+	defaultPromptDir         = "system_prompt"
+	defaultPromptLoadWorkers = 4
+
+	defaultTLSMinVersion = "1.2"
+
+	defaultRetryBaseDelay = 500 * time.Millisecond
+	defaultRetryMaxDelay  = 30 * time.Second
+
+	defaultPoolMaxIdle        = 100
+	defaultPoolMaxIdlePerHost = 10
+	defaultPoolIdleTimeout    = 90 * time.Second
+
+	defaultUpstreamAuthScheme  = "bearer"
+	defaultHMACSignatureHeader = "X-Signature"
+	defaultHMACTimestampHeader = "X-Timestamp"
+
+	defaultSystemMergeOverflow = "separate"
+
+	defaultStartupCheck      = "off"
+	defaultStartupCheckModel = "claude-sonnet-4-20250514"
+
+	defaultSizeBasis = "full"
+
+	defaultCacheBreakpoints    = "all"
+	defaultMaxCacheBreakpoints = 4
+
+	defaultLogVerbosity = "full"
+	defaultLogFormat    = "text"
+	defaultLogLevel     = "debug"
+
+	defaultAsyncLogBuffer = 1024
+
+	defaultAccessLogFormat = "combined"
+
+	defaultShedWindow         = 30 * time.Second
+	defaultShedErrorThreshold = 0.5
+	defaultShedMinRequests    = 20
+	defaultMaxShedRate        = 0.9
+
+	defaultBreakerFailureThreshold = 5
+	defaultBreakerCooldown         = 30 * time.Second
+)
+
+// 远程配置相关参数
+const (
+	remoteConfigTimeout   = 10 * time.Second
+	remoteConfigCachePath = "config.remote-cache.yaml"
+
+	// userIDFilePath 自动生成的user_id的保存位置
+	userIDFilePath = ".user_id"
+)
+
+// 配置加载失败的原因，调用方可通过errors.Is区分并给出对应的处理建议
+var (
+	// ErrConfigNotFound 配置文件不存在
+	ErrConfigNotFound = errors.New("配置文件不存在")
+	// ErrConfigMalformed 配置文件不是合法的YAML或字段类型不匹配
+	ErrConfigMalformed = errors.New("配置文件格式错误")
+	// ErrConfigInvalid 配置内容未通过验证
+	ErrConfigInvalid = errors.New("配置验证失败")
+)
+
 var (
-	instance *Config
-	once     sync.Once
+	instance   *Config
+	instanceMu sync.RWMutex
+	once       sync.Once
+	loadErr    error // 首次加载的错误，重复调用LoadConfig时同样返回
+
+	// generatedUserID 自动生成的user_id，重新加载配置时沿用以保持会话标识不变
+	generatedUserID string
+	// userIDMu 保护generatedUserID和.user_id文件的读写，配置加载可能与ResetConfig并发
+	userIDMu sync.Mutex
 )
 
 // LoadConfig 从指定文件路径加载配置
 //
+// 只在首次调用时读取文件，之后的调用忽略configPath，返回首次加载的实例和错误
+//
 // 参数:
 //   - configPath: 配置文件路径
 //
@@ -50,12 +421,77 @@ var (
 //   - *Config: 加载的配置实例
 //   - error: 可能的错误
 func LoadConfig(configPath string) (*Config, error) {
-	var err error
 	once.Do(func() {
-		instance = &Config{}
-		err = loadConfigFromFile(configPath, instance)
+		cfg := &Config{}
+		err := loadConfigFromFile(configPath, cfg)
+		instanceMu.Lock()
+		instance = cfg
+		loadErr = err
+		instanceMu.Unlock()
 	})
-	return instance, err
+
+	instanceMu.RLock()
+	defer instanceMu.RUnlock()
+	return instance, loadErr
+}
+
+// LoadConfigFresh 不经过单例直接从文件加载配置，并替换当前配置实例
+//
+// 与ReloadConfig不同，加载失败时同样替换当前实例，行为与首次调用LoadConfig一致，
+// 便于测试或需要重新初始化的场景按不同文件加载配置
+//
+// 参数:
+//   - configPath: 配置文件路径
+//
+// 返回值:
+//   - *Config: 加载的配置实例
+//   - error: 可能的错误
+func LoadConfigFresh(configPath string) (*Config, error) {
+	cfg := &Config{}
+	err := loadConfigFromFile(configPath, cfg)
+
+	instanceMu.Lock()
+	instance = cfg
+	loadErr = err
+	instanceMu.Unlock()
+	return cfg, err
+}
+
+// ResetConfig 清除当前配置实例，之后的LoadConfig调用会重新读取配置文件
+//
+// 同时清除自动生成的user_id；只应在没有并发调用LoadConfig时使用，主要用于测试
+func ResetConfig() {
+	instanceMu.Lock()
+	instance = nil
+	loadErr = nil
+	once = sync.Once{}
+	instanceMu.Unlock()
+
+	userIDMu.Lock()
+	generatedUserID = ""
+	userIDMu.Unlock()
+}
+
+// ReloadConfig 重新读取配置文件，验证通过后替换当前配置实例
+//
+// 验证失败时保留原有配置不变；已持有旧配置指针的调用方不受影响
+//
+// 参数:
+//   - configPath: 配置文件路径
+//
+// 返回值:
+//   - *Config: 新的配置实例
+//   - error: 可能的错误
+func ReloadConfig(configPath string) (*Config, error) {
+	cfg := &Config{}
+	if err := loadConfigFromFile(configPath, cfg); err != nil {
+		return nil, err
+	}
+
+	instanceMu.Lock()
+	instance = cfg
+	instanceMu.Unlock()
+	return cfg, nil
 }
 
 // GetConfig 获取当前配置实例
@@ -63,6 +499,8 @@ func LoadConfig(configPath string) (*Config, error) {
 // 返回值:
 //   - *Config: 当前的配置实例
 func GetConfig() *Config {
+	instanceMu.RLock()
+	defer instanceMu.RUnlock()
 	return instance
 }
 
@@ -92,25 +530,554 @@ func generateUserID() string {
 // 返回值:
 //   - error: 可能的错误
 func loadConfigFromFile(configPath string, cfg *Config) error {
-	// 读取配置文件
-	data, err := ioutil.ReadFile(configPath)
+	// 读取配置文件（支持远程URL）
+	data, fetched, err := readConfigData(configPath)
 	if err != nil {
-		return fmt.Errorf("读取配置文件失败: %v", err)
+		return err
 	}
 
 	// 解析YAML配置
 	if err := yaml.Unmarshal(data, cfg); err != nil {
-		return fmt.Errorf("解析配置文件失败: %v", err)
+		return fmt.Errorf("%w: %v", ErrConfigMalformed, err)
+	}
+
+	// 替换字符串配置项中的环境变量引用，密钥等敏感配置可以不写入配置文件
+	if err := expandEnvVars(cfg); err != nil {
+		return err
+	}
+
+	// 从文件读取密钥，需在默认值把upstream.key填充到各上游之前完成
+	if err := resolveKeyFiles(cfg); err != nil {
+		return fmt.Errorf("%w: %v", ErrConfigInvalid, err)
+	}
+
+	// 合并TLS配置的别名，需在填充默认的最低TLS版本之前完成
+	if err := resolveTLSAliases(cfg); err != nil {
+		return fmt.Errorf("%w: %v", ErrConfigInvalid, err)
 	}
 
+	// 填充默认值
+	applyDefaults(cfg)
+
 	// 验证配置
 	if err := validateConfig(cfg); err != nil {
-		return fmt.Errorf("配置验证失败: %v", err)
+		return fmt.Errorf("%w: %v", ErrConfigInvalid, err)
+	}
+
+	// 远程配置解析和验证都通过后才缓存，避免无效配置覆盖上次可用的缓存
+	if fetched {
+		if err := ioutil.WriteFile(remoteConfigCachePath, data, 0600); err != nil {
+			fmt.Printf("\033[33m[00000000][WARN]    %s 缓存远程配置失败: %v\033[0m\n",
+				time.Now().Format("2006-01-02 15:04:05"), err)
+		}
 	}
 
 	return nil
 }
 
+// resolveKeyFiles 读取配置为文件的密钥
+//
+// 参数:
+//   - cfg: 配置实例
+//
+// 返回值:
+//   - error: 同时配置了key和key_file或读取失败时的错误
+func resolveKeyFiles(cfg *Config) error {
+	if err := readKeyFile("upstream", &cfg.Upstream.Key, cfg.Upstream.KeyFile); err != nil {
+		return err
+	}
+	return readKeyFile("auth", &cfg.Auth.Key, cfg.Auth.KeyFile)
+}
+
+// resolveTLSAliases 将upstream.min_tls_version和upstream.insecure_skip_verify合并到upstream.tls
+//
+// 参数:
+//   - cfg: 配置实例
+//
+// 返回值:
+//   - error: 别名与upstream.tls中的配置冲突时的错误
+func resolveTLSAliases(cfg *Config) error {
+	upstream := &cfg.Upstream
+	if upstream.MinTLSVersion != "" {
+		if upstream.TLS.MinVersion != "" && upstream.TLS.MinVersion != upstream.MinTLSVersion {
+			return fmt.Errorf("upstream.min_tls_version与upstream.tls.min_version不一致，只需配置一个")
+		}
+		upstream.TLS.MinVersion = upstream.MinTLSVersion
+	}
+	if upstream.InsecureSkipVerify {
+		upstream.TLS.InsecureSkipVerify = true
+	}
+	return nil
+}
+
+// readKeyFile 从文件读取密钥，去掉首尾的空白和换行
+//
+// 适用于Docker/Kubernetes以文件形式挂载的secret，密钥无需写入配置文件
+//
+// 参数:
+//   - section: 配置节名称，用于错误信息
+//   - key: 密钥字段，读取成功时写入
+//   - path: 密钥文件路径，为空时不做处理
+//
+// 返回值:
+//   - error: 可能的错误
+func readKeyFile(section string, key *string, path string) error {
+	if path == "" {
+		return nil
+	}
+	if *key != "" {
+		return fmt.Errorf("%s.key和%s.key_file只能配置一个", section, section)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("读取%s.key_file失败: %v", section, err)
+	}
+	*key = strings.TrimSpace(string(data))
+	if *key == "" {
+		return fmt.Errorf("%s.key_file中的密钥为空: %s", section, path)
+	}
+	return nil
+}
+
+// envVarPattern 配置文件中的环境变量引用，只识别${NAME}形式，其他$字符原样保留
+var envVarPattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// expandEnvVars 将配置中字符串值里的${NAME}替换为对应环境变量的值
+//
+// 在YAML解析之后进行，注释中的${NAME}不会被替换，环境变量的值也不会被当作YAML解析
+//
+// 参数:
+//   - cfg: 解析后的配置
+//
+// 返回值:
+//   - error: 引用了未设置的环境变量时返回错误
+func expandEnvVars(cfg *Config) error {
+	var missing []string
+	expandEnvValue(reflect.ValueOf(cfg).Elem(), &missing)
+
+	if len(missing) > 0 {
+		return fmt.Errorf("配置文件引用了未设置的环境变量: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// expandEnvValue 递归替换值中所有字符串的环境变量引用
+//
+// 参数:
+//   - v: 可设置的值
+//   - missing: 收集未设置的环境变量名称
+func expandEnvValue(v reflect.Value, missing *[]string) {
+	switch v.Kind() {
+	case reflect.String:
+		if v.CanSet() {
+			v.SetString(expandEnvString(v.String(), missing))
+		}
+	case reflect.Ptr:
+		if !v.IsNil() {
+			expandEnvValue(v.Elem(), missing)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Field(i).CanSet() {
+				expandEnvValue(v.Field(i), missing)
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			expandEnvValue(v.Index(i), missing)
+		}
+	case reflect.Map:
+		// map的值不可寻址，复制后替换再写回
+		iter := v.MapRange()
+		for iter.Next() {
+			item := reflect.New(iter.Value().Type()).Elem()
+			item.Set(iter.Value())
+			expandEnvValue(item, missing)
+			v.SetMapIndex(iter.Key(), item)
+		}
+	case reflect.Interface:
+		if v.IsNil() {
+			return
+		}
+		item := reflect.New(v.Elem().Type()).Elem()
+		item.Set(v.Elem())
+		expandEnvValue(item, missing)
+		v.Set(item)
+	}
+}
+
+// expandEnvString 将字符串中的${NAME}替换为对应环境变量的值
+//
+// 参数:
+//   - value: 配置中的字符串
+//   - missing: 收集未设置的环境变量名称
+//
+// 返回值:
+//   - string: 替换后的字符串
+func expandEnvString(value string, missing *[]string) string {
+	return envVarPattern.ReplaceAllStringFunc(value, func(match string) string {
+		name := envVarPattern.FindStringSubmatch(match)[1]
+		env, ok := os.LookupEnv(name)
+		if !ok {
+			*missing = append(*missing, name)
+			return match
+		}
+		return env
+	})
+}
+
+// readConfigData 读取配置内容，配置路径为http(s)地址时从远程获取
+//
+// 远程获取失败时回退到本地缓存；获取成功的配置由调用方在验证通过后写入缓存
+//
+// 参数:
+//   - configPath: 配置文件路径或远程URL
+//
+// 返回值:
+//   - []byte: 配置内容
+//   - bool: 是否为刚从远程获取的配置
+//   - error: 可能的错误
+func readConfigData(configPath string) ([]byte, bool, error) {
+	if !strings.HasPrefix(configPath, "http://") && !strings.HasPrefix(configPath, "https://") {
+		data, err := ioutil.ReadFile(configPath)
+		if os.IsNotExist(err) {
+			return nil, false, fmt.Errorf("%w: %s", ErrConfigNotFound, configPath)
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("读取配置文件失败: %v", err)
+		}
+		return data, false, nil
+	}
+
+	data, err := fetchRemoteConfig(configPath)
+	if err == nil {
+		return data, true, nil
+	}
+
+	// 获取失败，回退到本地缓存
+	cached, cacheErr := ioutil.ReadFile(remoteConfigCachePath)
+	if cacheErr != nil {
+		return nil, false, fmt.Errorf("获取远程配置失败: %v，且无可用的本地缓存: %v", err, cacheErr)
+	}
+	// 使用fmt.Printf直接输出，避免循环依赖
+	fmt.Printf("\033[33m[00000000][WARN]    %s 获取远程配置失败: %v，使用本地缓存: %s\033[0m\n",
+		time.Now().Format("2006-01-02 15:04:05"), err, remoteConfigCachePath)
+	return cached, false, nil
+}
+
+// fetchRemoteConfig 从远程URL获取配置内容
+//
+// 参数:
+//   - configURL: 配置地址
+//
+// 返回值:
+//   - []byte: 配置内容
+//   - error: 可能的错误
+func fetchRemoteConfig(configURL string) ([]byte, error) {
+	client := &http.Client{Timeout: remoteConfigTimeout}
+	resp, err := client.Get(configURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("状态码错误: %d", resp.StatusCode)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// defaultRecognizedFields 默认已知的Messages API顶层字段
+var defaultRecognizedFields = []string{
+	"model", "messages", "system", "metadata", "max_tokens", "stream", "temperature",
+	"top_p", "top_k", "stop_sequences", "tools", "tool_choice", "thinking",
+	"container", "service_tier", "mcp_servers",
+}
+
+// normalizeYAMLValue 将YAML解析得到的map[interface{}]interface{}转换为可JSON序列化的结构
+//
+// 参数:
+//   - value: YAML解析得到的值
+//
+// 返回值:
+//   - interface{}: 转换后的值
+func normalizeYAMLValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			result[fmt.Sprint(key)] = normalizeYAMLValue(item)
+		}
+		return result
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeYAMLValue(item)
+		}
+		return v
+	default:
+		return value
+	}
+}
+
+// ParseTLSVersion 将配置中的TLS版本字符串转换为crypto/tls版本常量
+//
+// 参数:
+//   - version: 版本字符串，如"1.2"
+//
+// 返回值:
+//   - uint16: crypto/tls中对应的版本常量
+//   - error: 版本字符串无效时的错误
+func ParseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "1.0":
+		return tls.VersionTLS10, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	default:
+		return 0, fmt.Errorf("无效的TLS版本: %s，可选值为1.0、1.1、1.2、1.3", version)
+	}
+}
+
+// LoadCACertPool 加载系统证书并追加CA证书文件中的证书
+//
+// 参数:
+//   - path: PEM格式的CA证书文件路径
+//
+// 返回值:
+//   - *x509.CertPool: 证书池
+//   - error: 文件无法读取或不包含有效证书时的错误
+func LoadCACertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取upstream.tls.ca_file失败: %v", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("upstream.tls.ca_file中没有有效的PEM证书: %s", path)
+	}
+	return pool, nil
+}
+
+// ParseIPNet 将IP地址或CIDR字符串解析为网段，单个IP视为只包含自身的网段
+//
+// 参数:
+//   - value: IP地址或CIDR，如"10.0.0.0/8"、"127.0.0.1"
+//
+// 返回值:
+//   - *net.IPNet: 解析后的网段
+//   - error: 格式无效时的错误
+func ParseIPNet(value string) (*net.IPNet, error) {
+	if strings.Contains(value, "/") {
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("无效的CIDR: %s", value)
+		}
+		return ipNet, nil
+	}
+
+	ip := net.ParseIP(value)
+	if ip == nil {
+		return nil, fmt.Errorf("无效的IP地址: %s", value)
+	}
+	bits := 8 * net.IPv6len
+	if ip4 := ip.To4(); ip4 != nil {
+		ip, bits = ip4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+}
+
+// applyDefaults 为未配置的可选参数填充默认值
+//
+// 参数:
+//   - cfg: 要填充的配置结构体指针
+func applyDefaults(cfg *Config) {
+	if cfg.Server.Timeouts.Read == 0 {
+		cfg.Server.Timeouts.Read = defaultServerReadTimeout
+	}
+	if cfg.Server.Timeouts.Write == 0 {
+		cfg.Server.Timeouts.Write = defaultServerWriteTimeout
+	}
+	if cfg.Server.Timeouts.Idle == 0 {
+		cfg.Server.Timeouts.Idle = defaultServerIdleTimeout
+	}
+	if cfg.Server.CORS.Enabled == nil {
+		corsEnabled := true
+		cfg.Server.CORS.Enabled = &corsEnabled
+	}
+	if cfg.Server.CORS.AllowedOrigins == nil {
+		cfg.Server.CORS.AllowedOrigins = []string{"*"}
+	}
+	if cfg.Server.CORS.AllowedMethods == nil {
+		cfg.Server.CORS.AllowedMethods = []string{http.MethodPost, http.MethodOptions}
+	}
+	if cfg.Server.CORS.AllowedHeaders == nil {
+		cfg.Server.CORS.AllowedHeaders = []string{"*"}
+	}
+	if cfg.Server.CORS.MaxAge == 0 {
+		cfg.Server.CORS.MaxAge = defaultCORSMaxAge
+	}
+	if cfg.Server.ConcurrencyOverflow == "" {
+		cfg.Server.ConcurrencyOverflow = defaultConcurrencyOverflow
+	}
+	if cfg.Server.QueueTimeout == 0 {
+		cfg.Server.QueueTimeout = defaultQueueTimeout
+	}
+	if cfg.Server.ShutdownTimeout == 0 {
+		cfg.Server.ShutdownTimeout = defaultShutdownTimeout
+	}
+
+	// 单上游写法转换为上游列表，未单独配置密钥的上游使用upstream.key
+	if len(cfg.Upstream.Endpoints) == 0 && (cfg.Upstream.URL != "" || cfg.Upstream.BaseURL != "") {
+		cfg.Upstream.Endpoints = []UpstreamEndpoint{{URL: cfg.Upstream.URL, BaseURL: cfg.Upstream.BaseURL, Key: cfg.Upstream.Key}}
+	}
+	for i := range cfg.Upstream.Endpoints {
+		if cfg.Upstream.Endpoints[i].Key == "" {
+			cfg.Upstream.Endpoints[i].Key = cfg.Upstream.Key
+		}
+	}
+	if cfg.Upstream.Fallback.Key == "" {
+		cfg.Upstream.Fallback.Key = cfg.Upstream.Key
+	}
+	for i := range cfg.Routes {
+		if cfg.Routes[i].Key == "" {
+			cfg.Routes[i].Key = cfg.Upstream.Key
+		}
+	}
+	if cfg.Auth.RateLimit > 0 && cfg.Auth.Burst == 0 {
+		cfg.Auth.Burst = cfg.Auth.RateLimit
+	}
+	if cfg.RequestFields.Recognized == nil {
+		cfg.RequestFields.Recognized = defaultRecognizedFields
+	}
+	for key, value := range cfg.RequestFields.Defaults {
+		cfg.RequestFields.Defaults[key] = normalizeYAMLValue(value)
+	}
+	if cfg.Upstream.Auth.Scheme == "" {
+		cfg.Upstream.Auth.Scheme = defaultUpstreamAuthScheme
+	}
+	if cfg.Upstream.Auth.HMAC.SignatureHeader == "" {
+		cfg.Upstream.Auth.HMAC.SignatureHeader = defaultHMACSignatureHeader
+	}
+	if cfg.Upstream.Auth.HMAC.TimestampHeader == "" {
+		cfg.Upstream.Auth.HMAC.TimestampHeader = defaultHMACTimestampHeader
+	}
+	if cfg.Upstream.RetryBaseDelay == 0 {
+		cfg.Upstream.RetryBaseDelay = defaultRetryBaseDelay
+	}
+	if cfg.Upstream.RetryMaxDelay == 0 {
+		cfg.Upstream.RetryMaxDelay = defaultRetryMaxDelay
+	}
+	if cfg.Upstream.TLS.MinVersion == "" {
+		cfg.Upstream.TLS.MinVersion = defaultTLSMinVersion
+	}
+	if cfg.Upstream.Pool.MaxIdle == 0 {
+		cfg.Upstream.Pool.MaxIdle = defaultPoolMaxIdle
+	}
+	if cfg.Upstream.Pool.MaxIdlePerHost == 0 {
+		cfg.Upstream.Pool.MaxIdlePerHost = defaultPoolMaxIdlePerHost
+	}
+	if cfg.Upstream.Pool.IdleTimeout == 0 {
+		cfg.Upstream.Pool.IdleTimeout = defaultPoolIdleTimeout
+	}
+	if cfg.Server.MaxBodyBytes == 0 {
+		cfg.Server.MaxBodyBytes = defaultMaxBodyBytes
+	}
+	// 超过读取上限的请求体不会进入转换阶段，转换前的检查默认沿用同一上限
+	if cfg.Gateway.MaxBodyBytes == 0 {
+		cfg.Gateway.MaxBodyBytes = cfg.Server.MaxBodyBytes
+	}
+	if cfg.Gateway.MaxJSONDepth == 0 {
+		cfg.Gateway.MaxJSONDepth = defaultMaxJSONDepth
+	}
+	if cfg.Gateway.PromptDir == "" {
+		cfg.Gateway.PromptDir = defaultPromptDir
+	}
+	if cfg.Gateway.PromptLoadWorkers == 0 {
+		cfg.Gateway.PromptLoadWorkers = defaultPromptLoadWorkers
+	}
+	if cfg.Gateway.InjectThreshold == nil {
+		threshold := DefaultInjectThreshold
+		cfg.Gateway.InjectThreshold = &threshold
+	}
+	if cfg.Gateway.SizeBasis == "" {
+		cfg.Gateway.SizeBasis = defaultSizeBasis
+	}
+	if cfg.Gateway.CacheBreakpoints == "" {
+		cfg.Gateway.CacheBreakpoints = defaultCacheBreakpoints
+	}
+	if cfg.Gateway.MaxCacheBreakpoints == 0 {
+		cfg.Gateway.MaxCacheBreakpoints = defaultMaxCacheBreakpoints
+	}
+	if cfg.Gateway.SystemMergeOverflow == "" {
+		cfg.Gateway.SystemMergeOverflow = defaultSystemMergeOverflow
+	}
+	if cfg.Gateway.StartupCheck == "" {
+		cfg.Gateway.StartupCheck = defaultStartupCheck
+	}
+	if cfg.Gateway.StartupCheckModel == "" {
+		cfg.Gateway.StartupCheckModel = defaultStartupCheckModel
+	}
+	if cfg.Logging.RequestIDPropagation == nil {
+		requestIDPropagation := true
+		cfg.Logging.RequestIDPropagation = &requestIDPropagation
+	}
+	if cfg.Logging.Format == "" {
+		cfg.Logging.Format = defaultLogFormat
+	}
+	if cfg.Logging.Level == "" {
+		cfg.Logging.Level = defaultLogLevel
+	}
+	if cfg.Logging.AccessLogFormat == "" {
+		cfg.Logging.AccessLogFormat = defaultAccessLogFormat
+	}
+	if cfg.Logging.AsyncBuffer == 0 {
+		cfg.Logging.AsyncBuffer = defaultAsyncLogBuffer
+	}
+	if cfg.Logging.SaveRequests == nil {
+		saveRequests := true
+		cfg.Logging.SaveRequests = &saveRequests
+	}
+	if cfg.Logging.DefaultVerbosity == "" {
+		cfg.Logging.DefaultVerbosity = defaultLogVerbosity
+	}
+	if cfg.LoadShedding.Window == 0 {
+		cfg.LoadShedding.Window = defaultShedWindow
+	}
+	if cfg.LoadShedding.ErrorThreshold == 0 {
+		cfg.LoadShedding.ErrorThreshold = defaultShedErrorThreshold
+	}
+	if cfg.LoadShedding.MinRequests == 0 {
+		cfg.LoadShedding.MinRequests = defaultShedMinRequests
+	}
+	if cfg.LoadShedding.MaxShedRate == 0 {
+		cfg.LoadShedding.MaxShedRate = defaultMaxShedRate
+	}
+	if cfg.CircuitBreaker.FailureThreshold == 0 {
+		cfg.CircuitBreaker.FailureThreshold = defaultBreakerFailureThreshold
+	}
+	if cfg.CircuitBreaker.Cooldown == 0 {
+		cfg.CircuitBreaker.Cooldown = defaultBreakerCooldown
+	}
+	if cfg.Policy.BareRequestAction == "" {
+		cfg.Policy.BareRequestAction = defaultBareRequestAction
+	}
+}
+
+// isValidLogVerbosity 检查日志详细程度取值是否有效
+func isValidLogVerbosity(verbosity string) bool {
+	return verbosity == "full" || verbosity == "metadata" || verbosity == "none"
+}
+
 // validateConfig 验证提供的配置参数是否有效
 //
 // 参数:
@@ -119,24 +1086,298 @@ func loadConfigFromFile(configPath string, cfg *Config) error {
 // 返回值:
 //   - error: 验证失败时的错误
 func validateConfig(cfg *Config) error {
-	if cfg.Upstream.URL == "" {
+	if len(cfg.Upstream.Endpoints) == 0 {
 		return fmt.Errorf("上游URL不能为空")
 	}
-	if cfg.Upstream.Key == "" {
-		return fmt.Errorf("上游密钥不能为空")
+	for i, endpoint := range cfg.Upstream.Endpoints {
+		if endpoint.URL == "" && endpoint.BaseURL == "" {
+			return fmt.Errorf("第%d个上游URL不能为空", i+1)
+		}
+		if endpoint.URL != "" && endpoint.BaseURL != "" {
+			return fmt.Errorf("第%d个上游不能同时配置url和base_url", i+1)
+		}
+	}
+	if cfg.Upstream.Fallback.URL != "" && cfg.Upstream.Fallback.BaseURL != "" {
+		return fmt.Errorf("upstream.fallback不能同时配置url和base_url")
+	}
+	for i, route := range cfg.Routes {
+		if route.Model == "" {
+			return fmt.Errorf("第%d条模型路由的model不能为空", i+1)
+		}
+		if (route.URL == "") == (route.BaseURL == "") {
+			return fmt.Errorf("第%d条模型路由必须配置url或base_url之一", i+1)
+		}
+	}
+	switch cfg.Upstream.Auth.Scheme {
+	case "bearer":
+		for i, endpoint := range cfg.Upstream.Endpoints {
+			if endpoint.Key == "" {
+				return fmt.Errorf("第%d个上游密钥不能为空", i+1)
+			}
+		}
+		for i, route := range cfg.Routes {
+			if route.Key == "" {
+				return fmt.Errorf("第%d条模型路由的密钥不能为空", i+1)
+			}
+		}
+		if cfg.Upstream.Fallback.Configured() && cfg.Upstream.Fallback.Key == "" {
+			return fmt.Errorf("备用上游密钥不能为空")
+		}
+	case "hmac":
+		if cfg.Upstream.Auth.HMAC.Secret == "" {
+			return fmt.Errorf("upstream.auth.hmac.secret不能为空")
+		}
+	default:
+		return fmt.Errorf("upstream.auth.scheme只能为bearer或hmac")
+	}
+	if _, err := ParseTLSVersion(cfg.Upstream.TLS.MinVersion); err != nil {
+		return err
+	}
+	if cfg.Upstream.TLS.CAFile != "" {
+		if _, err := LoadCACertPool(cfg.Upstream.TLS.CAFile); err != nil {
+			return err
+		}
+	}
+	if cfg.Upstream.Pool.MaxIdle < 0 || cfg.Upstream.Pool.MaxIdlePerHost < 0 || cfg.Upstream.Pool.IdleTimeout < 0 {
+		return fmt.Errorf("upstream.pool的max_idle、max_idle_per_host和idle_timeout必须为正数")
+	}
+	if cfg.Upstream.RequestBudget < 0 {
+		return fmt.Errorf("upstream.request_budget不能为负数")
+	}
+	if cfg.Upstream.RequestTimeout < 0 {
+		return fmt.Errorf("upstream.request_timeout不能为负数")
+	}
+	for key := range cfg.Mimic.Headers {
+		if strings.EqualFold(key, "Authorization") {
+			return fmt.Errorf("mimic.headers不能配置Authorization，认证头由upstream.key生成")
+		}
+	}
+	for _, key := range cfg.Mimic.PassthroughHeaders {
+		if strings.EqualFold(key, "Authorization") || strings.EqualFold(key, "X-Api-Key") {
+			return fmt.Errorf("mimic.passthrough_headers不能包含%s，下游认证头携带的是网关密钥", key)
+		}
+	}
+	if cfg.Upstream.ProxyURL != "" {
+		proxyURL, err := url.Parse(cfg.Upstream.ProxyURL)
+		if err != nil || proxyURL.Host == "" {
+			return fmt.Errorf("upstream.proxy_url格式无效: %s", cfg.Upstream.ProxyURL)
+		}
+		switch proxyURL.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return fmt.Errorf("upstream.proxy_url只支持http、https、socks5或socks5h协议")
+		}
+	}
+	if cfg.Upstream.MaxRetries < 0 {
+		return fmt.Errorf("upstream.max_retries不能为负数")
+	}
+	if cfg.Upstream.RetryBaseDelay < 0 {
+		return fmt.Errorf("upstream.retry_base_delay不能为负数")
+	}
+	if cfg.Upstream.RetryMaxDelay < cfg.Upstream.RetryBaseDelay {
+		return fmt.Errorf("upstream.retry_max_delay不能小于retry_base_delay")
+	}
+	for i, pattern := range cfg.Upstream.RetryBodyPatterns {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("upstream.retry_body_patterns第%d条正则无效: %v", i+1, err)
+		}
+	}
+	for _, key := range cfg.Upstream.ExactCaseHeaders {
+		switch http.CanonicalHeaderKey(key) {
+		case "User-Agent", "Host", "Content-Length", "Transfer-Encoding", "Trailer":
+			// 这些请求头由Go HTTP客户端单独写出，无法保留大小写
+			return fmt.Errorf("upstream.exact_case_headers不支持请求头: %s", key)
+		}
 	}
 	if cfg.Server.Port <= 0 || cfg.Server.Port > 65535 {
 		return fmt.Errorf("服务端口必须在1-65535之间")
 	}
+	if host := cfg.Server.Host; strings.ContainsAny(host, "[]/ ") || (strings.Contains(host, ":") && net.ParseIP(host) == nil) {
+		return fmt.Errorf("server.host应为IP地址或主机名，不能包含端口: %s", host)
+	}
+	if t := cfg.Server.Timeouts; t.Read <= 0 || t.Write <= 0 || t.Idle <= 0 {
+		return fmt.Errorf("server.timeouts配置无效: read、write和idle必须为正数")
+	}
+	if cfg.Server.CORS.MaxAge < 0 {
+		return fmt.Errorf("server.cors.max_age不能为负数")
+	}
+	if cfg.Server.MaxConcurrent < 0 || cfg.Server.QueueTimeout < 0 {
+		return fmt.Errorf("server.max_concurrent和server.queue_timeout不能为负数")
+	}
+	if cfg.Server.ShutdownTimeout < 0 {
+		return fmt.Errorf("server.shutdown_timeout不能为负数")
+	}
+	if cfg.Server.ConcurrencyOverflow != "reject" && cfg.Server.ConcurrencyOverflow != "queue" {
+		return fmt.Errorf("server.concurrency_overflow只能为reject或queue")
+	}
+	for _, value := range append(append([]string{}, cfg.Server.AllowedIPs...), cfg.Server.DeniedIPs...) {
+		if _, err := ParseIPNet(value); err != nil {
+			return fmt.Errorf("server.allowed_ips/denied_ips配置错误: %v", err)
+		}
+	}
+	// Unix域套接字的对端地址不是IP，只能按反向代理传递的X-Forwarded-For过滤
+	if cfg.Server.UnixSocket != "" && !cfg.Server.TrustProxy && len(cfg.Server.AllowedIPs)+len(cfg.Server.DeniedIPs) > 0 {
+		return fmt.Errorf("监听unix_socket时按IP过滤需要开启server.trust_proxy，否则所有请求都会被拒绝")
+	}
 	if cfg.Auth.Key == "" {
 		return fmt.Errorf("验证密钥不能为空")
 	}
+	for i, authKey := range cfg.Auth.Keys {
+		if authKey.Key == "" {
+			return fmt.Errorf("auth.keys第%d个密钥不能为空", i+1)
+		}
+	}
+	if cfg.Auth.RateLimit < 0 || cfg.Auth.Burst < 0 {
+		return fmt.Errorf("auth.rate_limit和auth.burst不能为负数")
+	}
+	if cfg.Server.MaxBodyBytes < 0 || cfg.Gateway.MaxBodyBytes < 0 {
+		return fmt.Errorf("server.max_body_bytes和gateway.max_body_bytes不能为负数")
+	}
+	if cfg.Gateway.MaxJSONDepth < 0 {
+		return fmt.Errorf("max_json_depth不能为负数")
+	}
+	if cfg.Gateway.PromptLoadWorkers < 0 {
+		return fmt.Errorf("prompt_load_workers不能为负数")
+	}
+	if *cfg.Gateway.InjectThreshold < 0 {
+		return fmt.Errorf("inject_threshold不能为负数")
+	}
+	if cfg.Gateway.SizeBasis != "full" && cfg.Gateway.SizeBasis != "text" {
+		return fmt.Errorf("size_basis只能为full或text")
+	}
+	switch cfg.Gateway.CacheBreakpoints {
+	case "all", "model_prompt", "user_system", "none":
+	default:
+		return fmt.Errorf("cache_breakpoints只能为all、model_prompt、user_system或none")
+	}
+	if cfg.Gateway.MaxCacheBreakpoints < 0 {
+		return fmt.Errorf("max_cache_breakpoints不能为负数")
+	}
+	if cfg.Gateway.MaxMergedSystemBlocks < 0 {
+		return fmt.Errorf("max_merged_system_blocks不能为负数")
+	}
+	if cfg.Gateway.StreamPingInterval < 0 {
+		return fmt.Errorf("gateway.stream_ping_interval不能为负数")
+	}
+	if cfg.Gateway.SystemMergeOverflow != "separate" && cfg.Gateway.SystemMergeOverflow != "truncate" {
+		return fmt.Errorf("system_merge_overflow只能为separate或truncate")
+	}
+	switch cfg.Gateway.StartupCheck {
+	case "off", "warn", "exit":
+	default:
+		return fmt.Errorf("gateway.startup_check只能为off、warn或exit")
+	}
+	for ext, fileType := range cfg.Gateway.FileTypes {
+		if strings.Trim(ext, ".") == "" || fileType == "" {
+			return fmt.Errorf("gateway.file_types配置无效: 扩展名和文件类型都不能为空")
+		}
+	}
+	if cfg.Logging.AccessLogFormat != "common" && cfg.Logging.AccessLogFormat != "combined" {
+		return fmt.Errorf("logging.access_log_format只能为common或combined")
+	}
+	if cfg.Logging.AsyncBuffer < 0 {
+		return fmt.Errorf("logging.async_buffer不能为负数")
+	}
+	if cfg.Logging.MaxBodyLogBytes < 0 {
+		return fmt.Errorf("logging.max_body_log_bytes不能为负数")
+	}
+	if cfg.Logging.RetentionDays < 0 || cfg.Logging.MaxFiles < 0 {
+		return fmt.Errorf("logging.retention_days和logging.max_files不能为负数")
+	}
+	if cfg.Logging.Format != "text" && cfg.Logging.Format != "json" {
+		return fmt.Errorf("logging.format只能为text或json")
+	}
+	switch cfg.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		return fmt.Errorf("logging.level只能为debug、info、warn或error")
+	}
+	if !isValidLogVerbosity(cfg.Logging.DefaultVerbosity) {
+		return fmt.Errorf("logging.default_verbosity只能为full、metadata或none")
+	}
+	for alias, model := range cfg.Gateway.ModelAliases {
+		if alias == "" || model == "" {
+			return fmt.Errorf("gateway.model_aliases中的别名和模型名称不能为空")
+		}
+	}
+	for model, verbosity := range cfg.Logging.ModelVerbosity {
+		if !isValidLogVerbosity(verbosity) {
+			return fmt.Errorf("logging.model_verbosity中模型%s的值只能为full、metadata或none", model)
+		}
+	}
+	if ls := cfg.LoadShedding; ls.Window < 0 || ls.ErrorThreshold < 0 || ls.ErrorThreshold > 1 ||
+		ls.MinRequests < 0 || ls.MaxShedRate < 0 || ls.MaxShedRate > 1 {
+		return fmt.Errorf("load_shedding配置无效: 比例须在0-1之间，窗口和请求数不能为负数")
+	}
+	if cb := cfg.CircuitBreaker; cb.FailureThreshold < 0 || cb.Cooldown < 0 {
+		return fmt.Errorf("circuit_breaker配置无效: failure_threshold和cooldown不能为负数")
+	}
+	for model, params := range cfg.Limits {
+		for param, bounds := range params {
+			switch param {
+			case "temperature", "top_p", "max_tokens":
+			default:
+				return fmt.Errorf("limits中模型%s的参数%s不支持，可选temperature、top_p、max_tokens", model, param)
+			}
+			if len(bounds) != 2 {
+				return fmt.Errorf("limits中模型%s的参数%s必须为[最小值, 最大值]", model, param)
+			}
+		}
+	}
+	if t := cfg.Policy.MaxTemperature; t != nil && (*t < 0 || *t > 1) {
+		return fmt.Errorf("policy.max_temperature必须在0-1之间")
+	}
+	for i, rule := range cfg.Response.ErrorRewrites {
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("response.error_rewrites第%d条规则正则无效: %v", i+1, err)
+		}
+	}
+	switch cfg.Policy.BareRequestAction {
+	case "off", "warn", "block":
+	default:
+		return fmt.Errorf("policy.bare_request_action只能为off、warn或block")
+	}
+	resolveUserID(cfg)
+	return nil
+}
+
+// resolveUserID 为未配置user_id的配置填充user_id
+//
+// 依次沿用本进程之前自动生成的值、.user_id文件中保存的值，都没有时生成新的值并保存
+//
+// 参数:
+//   - cfg: 配置实例
+func resolveUserID(cfg *Config) {
+	userIDMu.Lock()
+	defer userIDMu.Unlock()
+
+	if cfg.Gateway.UserID == "" && generatedUserID != "" {
+		// 重新加载配置时沿用之前自动生成的UserID
+		cfg.Gateway.UserID = generatedUserID
+	}
+	if cfg.Gateway.UserID == "" && !cfg.Gateway.RegenerateUserID {
+		// 沿用上次启动时自动生成并保存的UserID，保持会话标识稳定
+		if data, err := ioutil.ReadFile(userIDFilePath); err == nil {
+			if userID := strings.TrimSpace(string(data)); userID != "" {
+				cfg.Gateway.UserID = userID
+				generatedUserID = userID
+				fmt.Printf("\033[34m[00000000][INFO]    %s 已从%s加载user_id: %s\033[0m\n",
+					time.Now().Format("2006-01-02 15:04:05"), userIDFilePath, userID)
+			}
+		}
+	}
 	if cfg.Gateway.UserID == "" {
 		// 自动生成UserID
 		cfg.Gateway.UserID = generateUserID()
+		generatedUserID = cfg.Gateway.UserID
 		// 使用fmt.Printf直接输出，避免循环依赖
-		fmt.Printf("\033[34m[0000][INFO]   %s 检测到user_id为空，已自动生成: %s\033[0m\n",
+		fmt.Printf("\033[34m[00000000][INFO]   %s 检测到user_id为空，已自动生成: %s\033[0m\n",
 			time.Now().Format("2006-01-02 15:04:05"), cfg.Gateway.UserID)
+
+		// 保存生成的UserID，下次启动时继续使用
+		if err := ioutil.WriteFile(userIDFilePath, []byte(cfg.Gateway.UserID+"\n"), 0600); err != nil {
+			fmt.Printf("\033[33m[00000000][WARN]    %s 保存user_id失败: %v\033[0m\n",
+				time.Now().Format("2006-01-02 15:04:05"), err)
+		}
 	}
-	return nil
 }
\ No newline at end of file
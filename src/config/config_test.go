@@ -0,0 +1,208 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigKeepsFirstInstance(t *testing.T) {
+	dir := useTempDir(t)
+	first := writeTestConfig(t, dir, "first.yaml", 8001)
+	second := writeTestConfig(t, dir, "second.yaml", 8002)
+
+	cfg, err := LoadConfig(first)
+	if err != nil {
+		t.Fatalf("LoadConfig失败: %v", err)
+	}
+	if cfg.Server.Port != 8001 {
+		t.Fatalf("端口为%d，期望8001", cfg.Server.Port)
+	}
+
+	again, err := LoadConfig(second)
+	if err != nil {
+		t.Fatalf("第二次LoadConfig失败: %v", err)
+	}
+	if again != cfg {
+		t.Fatalf("第二次LoadConfig应返回首次加载的实例")
+	}
+	if GetConfig() != cfg {
+		t.Fatalf("GetConfig应返回首次加载的实例")
+	}
+}
+
+func TestResetConfigAllowsReload(t *testing.T) {
+	dir := useTempDir(t)
+	first := writeTestConfig(t, dir, "first.yaml", 8001)
+	second := writeTestConfig(t, dir, "second.yaml", 8002)
+
+	if _, err := LoadConfig(first); err != nil {
+		t.Fatalf("LoadConfig失败: %v", err)
+	}
+
+	ResetConfig()
+	if GetConfig() != nil {
+		t.Fatalf("ResetConfig后GetConfig应返回nil")
+	}
+
+	cfg, err := LoadConfig(second)
+	if err != nil {
+		t.Fatalf("ResetConfig后LoadConfig失败: %v", err)
+	}
+	if cfg.Server.Port != 8002 {
+		t.Fatalf("端口为%d，期望8002", cfg.Server.Port)
+	}
+}
+
+func TestLoadConfigFresh(t *testing.T) {
+	dir := useTempDir(t)
+	first := writeTestConfig(t, dir, "first.yaml", 8001)
+	second := writeTestConfig(t, dir, "second.yaml", 8002)
+
+	tests := []struct {
+		name     string
+		path     string
+		wantPort int
+		wantErr  error
+	}{
+		{name: "首个配置文件", path: first, wantPort: 8001},
+		{name: "替换为另一个配置文件", path: second, wantPort: 8002},
+		{name: "配置文件不存在", path: filepath.Join(dir, "missing.yaml"), wantErr: ErrConfigNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := LoadConfigFresh(tt.path)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("错误为%v，期望%v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatalf("LoadConfigFresh失败: %v", err)
+			} else if cfg.Server.Port != tt.wantPort {
+				t.Fatalf("端口为%d，期望%d", cfg.Server.Port, tt.wantPort)
+			}
+
+			// 加载失败时同样替换当前实例
+			if GetConfig() != cfg {
+				t.Fatalf("GetConfig未返回LoadConfigFresh加载的实例")
+			}
+		})
+	}
+}
+
+func TestGeneratedUserIDSurvivesReloadUntilReset(t *testing.T) {
+	dir := useTempDir(t)
+	path := writeTestConfig(t, dir, "config.yaml", 8001)
+
+	cfg, err := LoadConfigFresh(path)
+	if err != nil {
+		t.Fatalf("LoadConfigFresh失败: %v", err)
+	}
+	userID := cfg.Gateway.UserID
+	if userID == "" {
+		t.Fatalf("未配置user_id时应自动生成")
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, userIDFilePath))
+	if err != nil {
+		t.Fatalf("自动生成的user_id未保存到临时目录: %v", err)
+	}
+	if strings.TrimSpace(string(data)) != userID {
+		t.Fatalf("保存的user_id为%q，期望%q", strings.TrimSpace(string(data)), userID)
+	}
+
+	reloaded, err := ReloadConfig(path)
+	if err != nil {
+		t.Fatalf("ReloadConfig失败: %v", err)
+	}
+	if reloaded.Gateway.UserID != userID {
+		t.Fatalf("重新加载后user_id为%q，期望沿用%q", reloaded.Gateway.UserID, userID)
+	}
+
+	// 重置后删除保存的文件，应重新生成
+	ResetConfig()
+	if err := os.Remove(filepath.Join(dir, userIDFilePath)); err != nil {
+		t.Fatalf("删除.user_id失败: %v", err)
+	}
+	fresh, err := LoadConfigFresh(path)
+	if err != nil {
+		t.Fatalf("ResetConfig后LoadConfigFresh失败: %v", err)
+	}
+	if fresh.Gateway.UserID == "" || fresh.Gateway.UserID == userID {
+		t.Fatalf("ResetConfig后应重新生成user_id，得到%q", fresh.Gateway.UserID)
+	}
+}
+
+func TestParseTLSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{version: "1.0", want: tls.VersionTLS10},
+		{version: "1.1", want: tls.VersionTLS11},
+		{version: "1.2", want: tls.VersionTLS12},
+		{version: "1.3", want: tls.VersionTLS13},
+		{version: "", wantErr: true},
+		{version: "1.4", wantErr: true},
+		{version: "TLS1.2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := ParseTLSVersion(tt.version)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望返回错误，得到%#x", got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Fatalf("得到%#x, %v，期望%#x", got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpstreamTLSMinVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		upstream string
+		want     string
+		wantErr  bool
+	}{
+		{name: "默认TLS 1.2", want: "1.2"},
+		{name: "tls.min_version", upstream: "  tls:\n    min_version: \"1.3\"\n", want: "1.3"},
+		{name: "min_tls_version别名", upstream: "  min_tls_version: \"1.3\"\n", want: "1.3"},
+		{name: "别名与tls.min_version一致", upstream: "  min_tls_version: \"1.1\"\n  tls:\n    min_version: \"1.1\"\n", want: "1.1"},
+		{name: "别名与tls.min_version冲突", upstream: "  min_tls_version: \"1.3\"\n  tls:\n    min_version: \"1.2\"\n", wantErr: true},
+		{name: "无效版本", upstream: "  tls:\n    min_version: \"1.4\"\n", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useTempDir(t)
+			path := writeConfigFile(t, t.TempDir(), "config.yaml", fmt.Sprintf(testConfigTemplate, 8080)+tt.upstream)
+
+			cfg, err := LoadConfigFresh(path)
+			if tt.wantErr {
+				if !errors.Is(err, ErrConfigInvalid) {
+					t.Fatalf("错误为%v，期望配置验证失败", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("LoadConfigFresh失败: %v", err)
+			}
+			if cfg.Upstream.TLS.MinVersion != tt.want {
+				t.Fatalf("最低TLS版本为%q，期望%q", cfg.Upstream.TLS.MinVersion, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testConfigTemplate 测试用的最小配置，%d处填写服务端口
+//
+// upstream放在最后，测试可直接追加upstream下的配置项
+const testConfigTemplate = `auth:
+  key: "gateway-test-key"
+server:
+  port: %d
+upstream:
+  url: "https://upstream.example.com/v1/messages?beta=true"
+  key: "sk-ant-test"
+`
+
+// useTempDir 切换到临时目录，避免测试生成的.user_id等文件写入仓库
+//
+// 同时重置配置实例，测试结束后恢复工作目录并再次重置
+func useTempDir(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("获取工作目录失败: %v", err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatalf("切换工作目录失败: %v", err)
+	}
+	ResetConfig()
+	t.Cleanup(func() {
+		ResetConfig()
+		if err := os.Chdir(wd); err != nil {
+			t.Errorf("恢复工作目录失败: %v", err)
+		}
+	})
+	return dir
+}
+
+// writeTestConfig 在dir下写入使用指定端口的配置文件，返回文件路径
+func writeTestConfig(t *testing.T, dir, name string, port int) string {
+	t.Helper()
+	return writeConfigFile(t, dir, name, fmt.Sprintf(testConfigTemplate, port))
+}
+
+// writeConfigFile 在dir下写入指定内容的配置文件，返回文件路径
+func writeConfigFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+	return path
+}
@@ -2,10 +2,15 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -17,26 +22,37 @@ import (
 // defaultConfigPath 默认配置文件路径
 const defaultConfigPath = "config.yaml"
 
+// version 程序版本，发布时通过 -ldflags "-X main.version=<版本号>" 写入
+var version = "dev"
+
 // main 程序入口点，初始化并启动Claude Mimic Gateway
 //
 // 负责配置加载、系统提示词加载、服务器创建和启动等核心初始化流程
 func main() {
-	utils.LogInfoLegacy("Claude Mimic Gateway 启动中...")
+	// 解析命令行参数
+	configPath := parseFlags(os.Args[1:])
 
-	// 获取配置文件路径
-	configPath := getConfigPath()
+	utils.LogInfoLegacy(fmt.Sprintf("Claude Mimic Gateway %s 启动中...", version))
 	utils.LogDebugLegacy("使用配置文件: " + configPath)
 
 	// 加载配置
 	cfg, err := config.LoadConfig(configPath)
 	if err != nil {
 		utils.LogErrorLegacy("加载配置失败: " + err.Error())
+		logConfigErrorHint(configPath, err)
 		os.Exit(1)
 	}
+	utils.ConfigureLogger(cfg)
 	utils.LogSuccessLegacy("配置加载成功")
+	utils.LogInfoLegacy(fmt.Sprintf("官方提示词注入阈值: %d bytes", *cfg.Gateway.InjectThreshold))
 
 	// 加载系统提示词
-	if count, err := utils.LoadSystemPromptsFromDefault(); err != nil {
+	promptDir := cfg.Gateway.PromptDir
+	if absDir, err := filepath.Abs(promptDir); err == nil {
+		promptDir = absDir
+	}
+	utils.LogInfoLegacy("系统提示词目录: " + promptDir)
+	if count, err := utils.LoadSystemPrompts(promptDir); err != nil {
 		utils.LogErrorLegacy("加载系统提示词失败: " + err.Error())
 		// 不退出程序，允许在没有系统提示词的情况下运行
 	} else {
@@ -52,90 +68,259 @@ func main() {
 		}
 	}
 
+	// 监听系统提示词目录变更
+	stopPromptWatch := func() {}
+	if cfg.Gateway.WatchPrompts {
+		if stop, err := utils.WatchSystemPrompts(promptDir); err != nil {
+			utils.LogErrorLegacy("开启系统提示词目录监听失败: " + err.Error())
+		} else {
+			stopPromptWatch = stop
+		}
+	}
+
+	// 启动日志清理和异步日志写入
+	stopLogRetention := utils.StartLogRetention(cfg)
+	stopAsyncLogging := utils.StartAsyncLogging(cfg)
+
 	// 创建代理处理器
-	proxyHandler := proxy.NewProxyHandler(cfg)
+	currentHandler.Store(proxy.NewProxyHandler(cfg))
 	utils.LogDebugLegacy("代理处理器已创建")
 
+	// 启动前检查上游地址和密钥
+	if cfg.Gateway.StartupCheck != "off" {
+		utils.LogInfoLegacy("正在检查上游连通性...")
+		if err := currentHandler.Load().CheckUpstreams(context.Background()); err != nil {
+			if cfg.Gateway.StartupCheck == "exit" {
+				utils.LogErrorLegacy("上游启动检查失败，程序退出")
+				stopLogRetention()
+				stopAsyncLogging()
+				stopPromptWatch()
+				os.Exit(1)
+			}
+			utils.LogWarnLegacy("上游启动检查失败，网关继续启动")
+		}
+	}
+
 	// 创建HTTP服务器
-	server := createHTTPServer(cfg, proxyHandler)
-	utils.LogInfoLegacy(fmt.Sprintf("HTTP服务器已创建，监听端口: %d", cfg.Server.Port))
+	server := createHTTPServer(cfg)
+	listener, err := listen(cfg)
+	if err != nil {
+		utils.LogErrorLegacy("服务器启动失败: " + err.Error())
+		os.Exit(1)
+	}
+	utils.LogInfoLegacy("HTTP服务器已创建，监听地址: " + listener.Addr().String())
 
 	// 启动服务器
 	go func() {
-		utils.LogSuccessLegacy(fmt.Sprintf("Claude Mimic Gateway 运行在端口 %d", cfg.Server.Port))
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			utils.LogErrorLegacy("服务器启动失败: " + err.Error())
+		utils.LogSuccessLegacy("Claude Mimic Gateway 运行在 " + listener.Addr().String())
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			utils.LogErrorLegacy("服务器运行失败: " + err.Error())
+			removeUnixSocket(cfg)
 			os.Exit(1)
 		}
 	}()
 
-	// 等待中断信号
-	waitForShutdown(server)
+	// 收到SIGHUP时重新加载配置
+	reload := func() {
+		newCfg, err := config.ReloadConfig(configPath)
+		if err != nil {
+			utils.LogErrorLegacy("重新加载配置失败，继续使用原配置: " + err.Error())
+			return
+		}
+		if newCfg.ListenAddr() != cfg.ListenAddr() || newCfg.Server.UnixSocket != cfg.Server.UnixSocket {
+			utils.LogInfoLegacy("server.host、server.port和server.unix_socket的修改需要重启后生效")
+		}
+		if newCfg.Gateway.PromptDir != cfg.Gateway.PromptDir || newCfg.Gateway.WatchPrompts != cfg.Gateway.WatchPrompts {
+			utils.LogInfoLegacy("gateway.prompt_dir和gateway.watch_prompts的修改需要重启后生效，当前仍使用目录: " + promptDir)
+		}
+
+		utils.ConfigureLogger(newCfg)
+		stopLogRetention()
+		stopLogRetention = utils.StartLogRetention(newCfg)
+		stopAsyncLogging()
+		stopAsyncLogging = utils.StartAsyncLogging(newCfg)
+
+		// 新请求使用新的处理器，进行中的请求继续使用原处理器直至完成
+		oldHandler := currentHandler.Swap(proxy.NewProxyHandler(newCfg))
+		oldHandler.CloseIdleConnections()
+
+		utils.LogSuccessLegacy("配置已重新加载")
+	}
+
+	// 等待中断信号，关闭超时时仍先完成下面的清理再退出
+	shutdownErr := waitForShutdown(server, reload)
+
+	// 删除Unix域套接字文件
+	removeUnixSocket(cfg)
+
+	// 停止日志清理，写完队列中剩余的异步日志
+	stopLogRetention()
+	stopAsyncLogging()
+
+	// 停止系统提示词目录监听
+	stopPromptWatch()
+
+	if shutdownErr != nil {
+		os.Exit(1)
+	}
+	utils.LogSuccessLegacy("Claude Mimic Gateway 已关闭")
 }
 
-// getConfigPath 获取配置文件路径
+// currentHandler 当前生效的代理处理器，重新加载配置时整体替换
+var currentHandler atomic.Pointer[proxy.ProxyHandler]
+
+// parseFlags 解析命令行参数，指定--version或--help时输出信息后退出
+//
+// 为兼容旧的启动方式，未指定--config时使用第一个位置参数作为配置文件路径
+//
+// 参数:
+//   - args: 命令行参数（不含程序名）
 //
 // 返回值:
 //   - string: 配置文件路径
-func getConfigPath() string {
-	if len(os.Args) > 1 {
-		return os.Args[1]
+func parseFlags(args []string) string {
+	flags := flag.NewFlagSet("claude-mimic-gateway", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "用法: claude-mimic-gateway [选项] [配置文件路径]\n\n选项:\n")
+		flags.PrintDefaults()
+	}
+
+	var configPath string
+	var showVersion bool
+	flags.StringVar(&configPath, "config", "", "配置文件路径或http(s)地址（默认 "+defaultConfigPath+"）")
+	flags.BoolVar(&showVersion, "version", false, "输出版本号后退出")
+	flags.BoolVar(&showVersion, "v", false, "--version的简写")
+	flags.Parse(args)
+
+	if showVersion {
+		fmt.Println("claude-mimic-gateway " + version)
+		os.Exit(0)
+	}
+
+	if configPath == "" {
+		configPath = flags.Arg(0)
+	}
+	if configPath == "" {
+		configPath = defaultConfigPath
 	}
-	return defaultConfigPath
+	return configPath
 }
 
 // createHTTPServer 创建HTTP服务器实例
 //
 // 参数:
 //   - cfg: 配置实例
-//   - proxyHandler: 代理处理器实例
 //
 // 返回值:
 //   - *http.Server: 配置好的HTTP服务器实例
-func createHTTPServer(cfg *config.Config, proxyHandler *proxy.ProxyHandler) *http.Server {
+func createHTTPServer(cfg *config.Config) *http.Server {
 	mux := http.NewServeMux()
 
-	setupRoutes(mux, proxyHandler)
+	setupRoutes(mux)
 
 	server := &http.Server{
-		Addr:         fmt.Sprintf(":%d", cfg.Server.Port),
+		Addr:         cfg.ListenAddr(),
 		Handler:      loggingMiddleware(mux),
-		ReadTimeout:  30 * time.Second,
-		WriteTimeout: 600 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		ReadTimeout:  cfg.Server.Timeouts.Read,
+		WriteTimeout: cfg.Server.Timeouts.Write,
+		IdleTimeout:  cfg.Server.Timeouts.Idle,
 	}
 
 	return server
 }
 
+// listen 创建服务监听器，配置了server.unix_socket时监听Unix域套接字，否则监听TCP地址
+//
+// 参数:
+//   - cfg: 配置实例
+//
+// 返回值:
+//   - net.Listener: 监听器
+//   - error: 可能的错误
+func listen(cfg *config.Config) (net.Listener, error) {
+	path := cfg.Server.UnixSocket
+	if path == "" {
+		return net.Listen("tcp", cfg.ListenAddr())
+	}
+
+	// 删除上次未正常退出时残留的套接字文件，同名的普通文件不做处理
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s 已存在且不是套接字文件", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("删除残留的套接字文件失败: %v", err)
+		}
+		utils.LogDebugLegacy("已删除残留的套接字文件: " + path)
+	}
+	return net.Listen("unix", path)
+}
+
+// removeUnixSocket 删除Unix域套接字文件，未配置server.unix_socket时不做处理
+//
+// 参数:
+//   - cfg: 配置实例
+func removeUnixSocket(cfg *config.Config) {
+	if cfg.Server.UnixSocket == "" {
+		return
+	}
+	if err := os.Remove(cfg.Server.UnixSocket); err != nil && !os.IsNotExist(err) {
+		utils.LogErrorLegacy("删除套接字文件失败: " + err.Error())
+	}
+}
+
 // setupRoutes 设置HTTP路由
 //
 // 参数:
 //   - mux: HTTP路由复用器
-//   - proxyHandler: 代理处理器实例
-func setupRoutes(mux *http.ServeMux, proxyHandler *proxy.ProxyHandler) {
+func setupRoutes(mux *http.ServeMux) {
+
+	mux.HandleFunc("/v1/messages", withCurrentHandler((*proxy.ProxyHandler).HandleRequest))
+
+	mux.HandleFunc("/v1/messages/count_tokens", withCurrentHandler((*proxy.ProxyHandler).HandleRequest))
+
+	mux.HandleFunc("/v1/complete", withCurrentHandler((*proxy.ProxyHandler).HandleRequest))
+
+	mux.HandleFunc("/health", withCurrentHandler((*proxy.ProxyHandler).HandleHealth))
 
-	mux.HandleFunc("/v1/messages", proxyHandler.HandleRequest)
+	mux.HandleFunc("/ready", withCurrentHandler((*proxy.ProxyHandler).HandleHealth))
 
-	mux.HandleFunc("/health", handleHealthCheck)
+	mux.HandleFunc("/stats", withCurrentHandler((*proxy.ProxyHandler).HandleStats))
+
+	mux.HandleFunc("/admin/prompts", withCurrentHandler((*proxy.ProxyHandler).HandleAdminPrompts))
+
+	mux.HandleFunc("/admin/prompts/reload", withCurrentHandler((*proxy.ProxyHandler).HandleAdminPromptReload))
 
 	utils.LogDebugLegacy("路由设置完成")
 }
 
-// handleHealthCheck 处理健康检查请求
+// withCurrentHandler 将代理处理器方法包装为每次请求时使用当前生效处理器的HTTP处理函数
 //
 // 参数:
-//   - w: HTTP响应写入器
-//   - r: HTTP请求对象
-func handleHealthCheck(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
-		return
+//   - method: 代理处理器的方法表达式
+//
+// 返回值:
+//   - http.HandlerFunc: HTTP处理函数
+func withCurrentHandler(method func(*proxy.ProxyHandler, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		method(currentHandler.Load(), w, r)
 	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(`{"status":"ok","service":"claude-mimic-gateway"}`))
+// logConfigErrorHint 根据配置加载失败的原因输出处理建议
+//
+// 参数:
+//   - configPath: 配置文件路径
+//   - err: 配置加载错误
+func logConfigErrorHint(configPath string, err error) {
+	switch {
+	case errors.Is(err, config.ErrConfigNotFound):
+		utils.LogInfoLegacy(fmt.Sprintf("请以示例配置为模板创建配置文件: cp config.example.yaml %s，然后填入上游地址和密钥", configPath))
+	case errors.Is(err, config.ErrConfigMalformed):
+		utils.LogInfoLegacy("请检查错误信息中提示的行号附近的YAML缩进、引号和字段类型")
+	case errors.Is(err, config.ErrConfigInvalid):
+		utils.LogInfoLegacy("配置文件格式正确，但错误信息中提到的配置项取值无效，请参考config.example.yaml中的说明修改")
+	}
 }
 
 // loggingMiddleware HTTP请求日志中间件
@@ -149,7 +334,7 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		// 包装ResponseWriter以捕获状态码
+		// 包装ResponseWriter以捕获状态码和响应字节数
 		wrappedWriter := &responseWriter{ResponseWriter: w, statusCode: 200}
 
 		// 调用下一个处理器
@@ -157,8 +342,17 @@ func loggingMiddleware(next http.Handler) http.Handler {
 
 		// 记录请求日志
 		duration := time.Since(start)
-		logMessage := fmt.Sprintf("%s %s - %d - %v",
-			r.Method, r.URL.Path, wrappedWriter.statusCode, duration)
+		if cfg := config.GetConfig(); cfg != nil && cfg.Logging.AccessLog != "" {
+			utils.WriteAccessLog(cfg.Logging.AccessLog, cfg.Logging.AccessLogFormat, utils.AccessLogEntry{
+				Request:  r,
+				Status:   wrappedWriter.statusCode,
+				Bytes:    wrappedWriter.bytesWritten,
+				Start:    start,
+				Duration: duration,
+			})
+		}
+		logMessage := fmt.Sprintf("%s %s - %d - %d bytes - %v",
+			r.Method, r.URL.Path, wrappedWriter.statusCode, wrappedWriter.bytesWritten, duration)
 
 		if wrappedWriter.statusCode >= 400 {
 			utils.LogErrorLegacy("请求处理失败: " + logMessage)
@@ -168,10 +362,11 @@ func loggingMiddleware(next http.Handler) http.Handler {
 	})
 }
 
-// responseWriter 响应写入器包装器，用于捕获HTTP状态码
+// responseWriter 响应写入器包装器，用于捕获HTTP状态码和响应字节数
 type responseWriter struct {
 	http.ResponseWriter
-	statusCode int
+	statusCode   int
+	bytesWritten int64
 }
 
 // WriteHeader 写入HTTP状态码
@@ -183,6 +378,20 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+// Write 写入响应体并累计字节数
+//
+// 参数:
+//   - data: 响应数据
+//
+// 返回值:
+//   - int: 写入的字节数
+//   - error: 可能的错误
+func (rw *responseWriter) Write(data []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(data)
+	rw.bytesWritten += int64(n)
+	return n, err
+}
+
 // Flush 实现http.Flusher接口，支持流式传输
 func (rw *responseWriter) Flush() {
 	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
@@ -190,28 +399,50 @@ func (rw *responseWriter) Flush() {
 	}
 }
 
-// waitForShutdown 等待关闭信号并优雅关闭服务器
+// waitForShutdown 等待关闭信号并优雅关闭服务器，期间收到SIGHUP时重新加载配置
 //
 // 参数:
 //   - server: HTTP服务器实例
-func waitForShutdown(server *http.Server) {
+//   - reload: 重新加载配置的函数
+//
+// 返回值:
+//   - error: 关闭超时等错误，调用方应在完成清理（如写完异步日志）后以非0状态退出
+func waitForShutdown(server *http.Server, reload func()) error {
 	// 创建信号通道
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	// 等待信号
+	// 等待信号，SIGHUP只重新加载配置
 	sig := <-quit
+	for sig == syscall.SIGHUP {
+		utils.LogInfoLegacy("收到SIGHUP信号，重新加载配置")
+		reload()
+		sig = <-quit
+	}
 	utils.LogInfoLegacy("收到关闭信号: " + sig.String())
 
-	// 设置关闭超时
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	// 设置关闭超时，使用重新加载后的最新配置
+	shutdownTimeout := config.GetConfig().Server.ShutdownTimeout
+	if requests, streaming := proxy.ActiveRequests(); requests > 0 {
+		utils.LogInfoLegacy(fmt.Sprintf("等待 %d 个进行中的请求结束（其中流式响应 %d 个），最长 %v", requests, streaming, shutdownTimeout))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
 	defer cancel()
 
+	// 停止接收新连接，同时等待进行中的流式响应结束，超时前通知其写出终止事件
+	shutdownErr := make(chan error, 1)
+	go func() {
+		shutdownErr <- server.Shutdown(ctx)
+	}()
+	proxy.DrainStreams(ctx)
+
 	// 优雅关闭服务器
-	if err := server.Shutdown(ctx); err != nil {
+	if err := <-shutdownErr; err != nil {
+		if requests, streaming := proxy.ActiveRequests(); requests > 0 {
+			utils.LogErrorLegacy(fmt.Sprintf("关闭超时，仍有 %d 个请求未处理完成（其中流式响应 %d 个），这些请求将被中断", requests, streaming))
+		}
 		utils.LogErrorLegacy("服务器关闭失败: " + err.Error())
-		os.Exit(1)
+		return err
 	}
-
-	utils.LogSuccessLegacy("Claude Mimic Gateway 已关闭")
+	return nil
 }
\ No newline at end of file
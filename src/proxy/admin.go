@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sort"
+
+	"claude-mimic-gateway/utils"
+)
+
+// promptInfo 已加载系统提示词的信息
+type promptInfo struct {
+	Model string `json:"model"`
+	Bytes int    `json:"bytes"`
+}
+
+// HandleAdminPrompts 输出已加载的系统提示词信息，需要网关密钥认证
+//
+// 参数:
+//   - w: HTTP响应写入器
+//   - r: HTTP请求对象
+func (p *ProxyHandler) HandleAdminPrompts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if authKey, authorized := p.validateAuth(r); !authorized || authKey != p.config.Auth.Key {
+		writeAPIError(w, http.StatusUnauthorized, "Invalid API key")
+		return
+	}
+
+	sizes := utils.GetSystemPromptSizes()
+	prompts := make([]promptInfo, 0, len(sizes))
+	for model, size := range sizes {
+		prompts = append(prompts, promptInfo{Model: model, Bytes: size})
+	}
+	sort.Slice(prompts, func(i, j int) bool {
+		return prompts[i].Model < prompts[j].Model
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"prompts": prompts,
+	})
+}
+
+// HandleAdminPromptReload 从磁盘重新加载单个模型的系统提示词，需要网关密钥认证
+//
+// 参数:
+//   - w: HTTP响应写入器
+//   - r: HTTP请求对象
+func (p *ProxyHandler) HandleAdminPromptReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if authKey, authorized := p.validateAuth(r); !authorized || authKey != p.config.Auth.Key {
+		writeAPIError(w, http.StatusUnauthorized, "Invalid API key")
+		return
+	}
+
+	model := r.URL.Query().Get("model")
+	if model == "" {
+		writeAPIError(w, http.StatusBadRequest, "Missing model parameter")
+		return
+	}
+
+	promptDir := p.config.Gateway.PromptDir
+	if absDir, err := filepath.Abs(promptDir); err == nil {
+		promptDir = absDir
+	}
+
+	size, err := utils.ReloadSystemPrompt(promptDir, model)
+	if errors.Is(err, utils.ErrPromptNotFound) {
+		writeAPIError(w, http.StatusNotFound, "Prompt file not found for model: "+model)
+		return
+	}
+	if err != nil {
+		utils.LogErrorLegacy("重新加载系统提示词失败: " + err.Error())
+		writeAPIError(w, http.StatusInternalServerError, "Failed to reload prompt")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(promptInfo{Model: model, Bytes: size})
+}
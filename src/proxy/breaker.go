@@ -0,0 +1,177 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"claude-mimic-gateway/utils"
+)
+
+// 熔断器状态
+const (
+	breakerClosed   = "closed"    // 正常放行
+	breakerOpen     = "open"      // 熔断中，直接拒绝
+	breakerHalfOpen = "half_open" // 冷却结束，放行一个探测请求
+)
+
+// circuitBreaker 上游熔断器
+//
+// 连续失败达到阈值后打开，冷却期内拒绝所有请求；冷却结束后进入半开状态，
+// 只放行一个探测请求，成功则关闭，失败则重新打开
+type circuitBreaker struct {
+	mu               sync.Mutex
+	failureThreshold int
+	cooldown         time.Duration
+	state            string
+	failures         int
+	openedAt         time.Time
+	probing          bool
+}
+
+// upstreamBreaker 全局的上游熔断器
+//
+// 与requestSlots一样跨配置重新加载保留，避免重新加载清除熔断状态后立即把请求打到故障上游
+var upstreamBreaker = &circuitBreaker{state: breakerClosed}
+
+// Configure 调整熔断参数，当前状态和失败计数保留
+//
+// 参数:
+//   - failureThreshold: 触发熔断的连续失败次数
+//   - cooldown: 熔断后的冷却时间
+func (cb *circuitBreaker) Configure(failureThreshold int, cooldown time.Duration) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.failureThreshold = failureThreshold
+	cb.cooldown = cooldown
+}
+
+// breakerPermit 一次请求从熔断器获得的放行许可，熔断器未启用时为nil
+//
+// 半开状态下只有持有探测许可的请求能结束探测，其他进行中请求的结果不会提前放行新的探测
+type breakerPermit struct {
+	cb    *circuitBreaker
+	probe bool // 是否为半开状态下的探测请求
+	done  bool // 是否已记录结果或放弃
+}
+
+// Allow 判断是否允许请求上游，熔断器为nil（未启用）时始终允许
+//
+// 返回值:
+//   - *breakerPermit: 放行许可，允许时必须调用其Record或Release
+//   - bool: 是否允许
+func (cb *circuitBreaker) Allow() (*breakerPermit, bool) {
+	if cb == nil {
+		return nil, true
+	}
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case breakerOpen:
+		if time.Since(cb.openedAt) < cb.cooldown {
+			return nil, false
+		}
+		cb.state = breakerHalfOpen
+		cb.probing = true
+		utils.LogInfoLegacy("熔断冷却结束，放行探测请求")
+		return &breakerPermit{cb: cb, probe: true}, true
+	case breakerHalfOpen:
+		if cb.probing {
+			return nil, false
+		}
+		cb.probing = true
+		return &breakerPermit{cb: cb, probe: true}, true
+	default:
+		return &breakerPermit{cb: cb}, true
+	}
+}
+
+// record 记录一次上游请求结果
+//
+// 参数:
+//   - failed: 是否失败
+//   - probe: 是否为探测请求的结果
+func (cb *circuitBreaker) record(failed, probe bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if probe {
+		cb.probing = false
+	} else if cb.state == breakerHalfOpen {
+		// 熔断前放行的请求不代表上游当前的状态，半开状态只由探测请求决定
+		return
+	}
+
+	if !failed {
+		if cb.state != breakerClosed {
+			utils.LogSuccessLegacy("上游已恢复，熔断器关闭")
+		}
+		cb.state = breakerClosed
+		cb.failures = 0
+		return
+	}
+
+	cb.failures++
+	if cb.state == breakerHalfOpen || cb.failures >= cb.failureThreshold {
+		if cb.state != breakerOpen {
+			utils.LogErrorLegacy(fmt.Sprintf("上游连续失败 %d 次，熔断 %v", cb.failures, cb.cooldown))
+		}
+		cb.state = breakerOpen
+		cb.openedAt = time.Now()
+	}
+}
+
+// release 结束探测但不记录结果
+func (cb *circuitBreaker) release() {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.probing = false
+}
+
+// Record 将本次上游请求结果计入熔断器，重复调用时只记录第一次
+//
+// 参数:
+//   - failed: 是否失败
+func (bp *breakerPermit) Record(failed bool) {
+	if bp == nil || bp.done {
+		return
+	}
+	bp.done = true
+	bp.cb.record(failed, bp.probe)
+}
+
+// Release 放弃本次请求的结果，不改变熔断状态
+//
+// 用于请求在得到上游结果前就结束的情况（如下游断开），避免半开状态下探测请求永远不结束
+func (bp *breakerPermit) Release() {
+	if bp == nil || bp.done {
+		return
+	}
+	bp.done = true
+	if bp.probe {
+		bp.cb.release()
+	}
+}
+
+// State 获取熔断器当前状态
+//
+// 返回值:
+//   - string: closed/open/half_open
+func (cb *circuitBreaker) State() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	return cb.state
+}
+
+// isUpstreamFailure 判断上游响应状态码是否表示上游故障
+//
+// 参数:
+//   - statusCode: 上游响应状态码
+//
+// 返回值:
+//   - bool: 5xx和429视为上游故障，其余4xx属于请求本身的问题
+func isUpstreamFailure(statusCode int) bool {
+	return statusCode >= 500 || statusCode == http.StatusTooManyRequests
+}
@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+)
+
+// breakerStep 熔断器测试中的一个操作
+type breakerStep struct {
+	op        string // allow/record/release/cooldown
+	permit    int    // record和release使用的许可序号，按allow成功的顺序从0开始
+	failed    bool   // record的结果
+	wantAllow bool   // allow是否应放行
+	wantProbe bool   // allow放行时是否为探测请求
+	wantState string // 操作后的状态
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []breakerStep
+	}{
+		{
+			name: "达到阈值后打开",
+			steps: []breakerStep{
+				{op: "allow", wantAllow: true, wantState: breakerClosed},
+				{op: "allow", wantAllow: true, wantState: breakerClosed},
+				{op: "allow", wantAllow: true, wantState: breakerClosed},
+				{op: "record", permit: 0, failed: true, wantState: breakerClosed},
+				{op: "record", permit: 1, failed: true, wantState: breakerClosed},
+				{op: "record", permit: 2, failed: true, wantState: breakerOpen},
+			},
+		},
+		{
+			name: "成功重置连续失败计数",
+			steps: []breakerStep{
+				{op: "allow", wantAllow: true, wantState: breakerClosed},
+				{op: "allow", wantAllow: true, wantState: breakerClosed},
+				{op: "allow", wantAllow: true, wantState: breakerClosed},
+				{op: "record", permit: 0, failed: true, wantState: breakerClosed},
+				{op: "record", permit: 1, failed: true, wantState: breakerClosed},
+				{op: "record", permit: 2, failed: false, wantState: breakerClosed},
+				{op: "allow", wantAllow: true, wantState: breakerClosed},
+				{op: "record", permit: 3, failed: true, wantState: breakerClosed},
+			},
+		},
+		{
+			name: "冷却期内拒绝",
+			steps: append(openBreakerSteps(),
+				breakerStep{op: "allow", wantAllow: false, wantState: breakerOpen},
+				breakerStep{op: "allow", wantAllow: false, wantState: breakerOpen},
+			),
+		},
+		{
+			name: "半开状态只放行一个探测请求",
+			steps: append(openBreakerSteps(),
+				breakerStep{op: "cooldown", wantState: breakerOpen},
+				breakerStep{op: "allow", wantAllow: true, wantProbe: true, wantState: breakerHalfOpen},
+				breakerStep{op: "allow", wantAllow: false, wantState: breakerHalfOpen},
+				breakerStep{op: "allow", wantAllow: false, wantState: breakerHalfOpen},
+			),
+		},
+		{
+			name: "探测成功后关闭",
+			steps: append(openBreakerSteps(),
+				breakerStep{op: "cooldown", wantState: breakerOpen},
+				breakerStep{op: "allow", wantAllow: true, wantProbe: true, wantState: breakerHalfOpen},
+				breakerStep{op: "record", permit: 3, failed: false, wantState: breakerClosed},
+				breakerStep{op: "allow", wantAllow: true, wantState: breakerClosed},
+			),
+		},
+		{
+			name: "探测失败后重新打开",
+			steps: append(openBreakerSteps(),
+				breakerStep{op: "cooldown", wantState: breakerOpen},
+				breakerStep{op: "allow", wantAllow: true, wantProbe: true, wantState: breakerHalfOpen},
+				breakerStep{op: "record", permit: 3, failed: true, wantState: breakerOpen},
+				breakerStep{op: "allow", wantAllow: false, wantState: breakerOpen},
+			),
+		},
+		{
+			name: "半开状态忽略非探测请求的结果",
+			steps: []breakerStep{
+				// 熔断前放行的请求在半开状态下才返回结果
+				{op: "allow", wantAllow: true, wantState: breakerClosed},
+				{op: "allow", wantAllow: true, wantState: breakerClosed},
+				{op: "allow", wantAllow: true, wantState: breakerClosed},
+				{op: "allow", wantAllow: true, wantState: breakerClosed},
+				{op: "allow", wantAllow: true, wantState: breakerClosed},
+				{op: "record", permit: 0, failed: true, wantState: breakerClosed},
+				{op: "record", permit: 1, failed: true, wantState: breakerClosed},
+				{op: "record", permit: 2, failed: true, wantState: breakerOpen},
+				{op: "cooldown", wantState: breakerOpen},
+				{op: "allow", wantAllow: true, wantProbe: true, wantState: breakerHalfOpen},
+				{op: "record", permit: 3, failed: false, wantState: breakerHalfOpen},
+				{op: "record", permit: 4, failed: true, wantState: breakerHalfOpen},
+				{op: "allow", wantAllow: false, wantState: breakerHalfOpen},
+				{op: "record", permit: 5, failed: false, wantState: breakerClosed},
+			},
+		},
+		{
+			name: "释放探测许可后放行下一个探测请求",
+			steps: append(openBreakerSteps(),
+				breakerStep{op: "cooldown", wantState: breakerOpen},
+				breakerStep{op: "allow", wantAllow: true, wantProbe: true, wantState: breakerHalfOpen},
+				breakerStep{op: "allow", wantAllow: false, wantState: breakerHalfOpen},
+				breakerStep{op: "release", permit: 3, wantState: breakerHalfOpen},
+				breakerStep{op: "allow", wantAllow: true, wantProbe: true, wantState: breakerHalfOpen},
+				breakerStep{op: "allow", wantAllow: false, wantState: breakerHalfOpen},
+			),
+		},
+		{
+			name: "许可只记录第一次结果",
+			steps: append(openBreakerSteps(),
+				breakerStep{op: "cooldown", wantState: breakerOpen},
+				breakerStep{op: "allow", wantAllow: true, wantProbe: true, wantState: breakerHalfOpen},
+				breakerStep{op: "record", permit: 3, failed: false, wantState: breakerClosed},
+				breakerStep{op: "record", permit: 3, failed: true, wantState: breakerClosed},
+				breakerStep{op: "release", permit: 3, wantState: breakerClosed},
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cb := &circuitBreaker{state: breakerClosed}
+			cb.Configure(3, time.Minute)
+
+			var permits []*breakerPermit
+			for i, step := range tt.steps {
+				switch step.op {
+				case "allow":
+					permit, allowed := cb.Allow()
+					if allowed != step.wantAllow {
+						t.Fatalf("第%d步: 放行为%t，期望%t", i, allowed, step.wantAllow)
+					}
+					if allowed {
+						if permit.probe != step.wantProbe {
+							t.Fatalf("第%d步: 探测请求为%t，期望%t", i, permit.probe, step.wantProbe)
+						}
+						permits = append(permits, permit)
+					}
+				case "record":
+					permits[step.permit].Record(step.failed)
+				case "release":
+					permits[step.permit].Release()
+				case "cooldown":
+					// 模拟冷却时间已过
+					cb.mu.Lock()
+					cb.openedAt = time.Now().Add(-cb.cooldown)
+					cb.mu.Unlock()
+				}
+				if state := cb.State(); state != step.wantState {
+					t.Fatalf("第%d步(%s): 状态为%s，期望%s", i, step.op, state, step.wantState)
+				}
+			}
+		})
+	}
+}
+
+// openBreakerSteps 放行3个请求并全部失败，使阈值为3的熔断器打开，占用许可0-2
+func openBreakerSteps() []breakerStep {
+	return []breakerStep{
+		{op: "allow", wantAllow: true, wantState: breakerClosed},
+		{op: "allow", wantAllow: true, wantState: breakerClosed},
+		{op: "allow", wantAllow: true, wantState: breakerClosed},
+		{op: "record", permit: 0, failed: true, wantState: breakerClosed},
+		{op: "record", permit: 1, failed: true, wantState: breakerClosed},
+		{op: "record", permit: 2, failed: true, wantState: breakerOpen},
+	}
+}
+
+func TestNilCircuitBreakerAllows(t *testing.T) {
+	var cb *circuitBreaker
+	permit, allowed := cb.Allow()
+	if !allowed || permit != nil {
+		t.Fatalf("未启用熔断器时应放行且许可为nil，得到%v, %t", permit, allowed)
+	}
+	// nil许可的Record和Release不做任何处理
+	permit.Record(true)
+	permit.Release()
+}
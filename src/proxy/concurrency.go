@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// concurrencyLimiter 限制同时处理的请求数量
+//
+// 超出上限的请求按配置直接拒绝或排队等待空闲名额，未配置上限时只统计进行中的请求数
+type concurrencyLimiter struct {
+	mu           sync.Mutex
+	max          int           // 最大并发请求数，0表示不限制
+	queue        bool          // 超出上限时是否排队等待
+	queueTimeout time.Duration // 排队等待的最长时间
+
+	inFlight int64 // 正在处理的请求数
+	queued   int64 // 正在排队的请求数
+
+	released chan struct{} // 归还名额或调整上限时关闭并替换，唤醒排队中的请求
+}
+
+// requestSlots 全局并发限制器
+//
+// 配置重新加载会替换ProxyHandler，进行中的请求仍占用旧实例获取的名额，
+// 因此与streams一样使用包级别的实例，重新加载时只调整上限
+var requestSlots = &concurrencyLimiter{released: make(chan struct{})}
+
+// Configure 调整并发限制参数，已占用的名额保持不变
+//
+// 上限调小时超出部分的请求继续处理，之后的请求要等进行中的请求数降到新上限以下
+//
+// 参数:
+//   - maxConcurrent: 最大并发请求数，0表示不限制
+//   - queue: 超出上限时是否排队等待
+//   - queueTimeout: 排队等待的最长时间
+func (l *concurrencyLimiter) Configure(maxConcurrent int, queue bool, queueTimeout time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.max = maxConcurrent
+	l.queue = queue
+	l.queueTimeout = queueTimeout
+	l.notifyLocked()
+}
+
+// Acquire 获取一个处理名额
+//
+// 参数:
+//   - ctx: 请求上下文，下游断开时停止排队
+//
+// 返回值:
+//   - bool: 是否获取成功；成功后必须调用Release
+func (l *concurrencyLimiter) Acquire(ctx context.Context) bool {
+	l.mu.Lock()
+	if l.tryAcquireLocked() {
+		l.mu.Unlock()
+		return true
+	}
+	if !l.queue {
+		l.mu.Unlock()
+		return false
+	}
+	timeout := l.queueTimeout
+	l.mu.Unlock()
+
+	return l.wait(ctx, timeout)
+}
+
+// wait 排队等待空闲名额
+//
+// 参数:
+//   - ctx: 请求上下文
+//   - timeout: 排队等待的最长时间
+//
+// 返回值:
+//   - bool: 是否在超时前获得名额
+func (l *concurrencyLimiter) wait(ctx context.Context, timeout time.Duration) bool {
+	l.mu.Lock()
+	l.queued++
+	l.mu.Unlock()
+	defer func() {
+		l.mu.Lock()
+		l.queued--
+		l.mu.Unlock()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		l.mu.Lock()
+		if l.tryAcquireLocked() {
+			l.mu.Unlock()
+			return true
+		}
+		released := l.released
+		l.mu.Unlock()
+
+		select {
+		case <-released:
+		case <-timer.C:
+			return false
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// tryAcquireLocked 有空闲名额时占用一个，调用方需持有锁
+//
+// 返回值:
+//   - bool: 是否占用成功
+func (l *concurrencyLimiter) tryAcquireLocked() bool {
+	if l.max > 0 && l.inFlight >= int64(l.max) {
+		return false
+	}
+	l.inFlight++
+	return true
+}
+
+// notifyLocked 唤醒排队中的请求重新检查名额，调用方需持有锁
+func (l *concurrencyLimiter) notifyLocked() {
+	close(l.released)
+	l.released = make(chan struct{})
+}
+
+// Release 归还处理名额
+func (l *concurrencyLimiter) Release() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.inFlight--
+	if l.queued > 0 {
+		l.notifyLocked()
+	}
+}
+
+// Stats 获取当前并发状态
+//
+// 返回值:
+//   - int64: 正在处理的请求数
+//   - int64: 正在排队的请求数
+func (l *concurrencyLimiter) Stats() (int64, int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.inFlight, l.queued
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newTestLimiter 创建独立于全局requestSlots的并发限制器
+func newTestLimiter(maxConcurrent int, queue bool, queueTimeout time.Duration) *concurrencyLimiter {
+	l := &concurrencyLimiter{released: make(chan struct{})}
+	l.Configure(maxConcurrent, queue, queueTimeout)
+	return l
+}
+
+// acquireAsync 在后台协程中获取名额，结果写入返回的通道
+func acquireAsync(ctx context.Context, l *concurrencyLimiter) <-chan bool {
+	result := make(chan bool, 1)
+	go func() { result <- l.Acquire(ctx) }()
+	return result
+}
+
+// waitQueued 等待排队的请求数达到want
+func waitQueued(t *testing.T, l *concurrencyLimiter, want int64) {
+	t.Helper()
+
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, queued := l.Stats(); queued == want {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	_, queued := l.Stats()
+	t.Fatalf("排队的请求数为%d，期望%d", queued, want)
+}
+
+func TestConcurrencyLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name         string
+		max          int
+		queue        bool
+		acquired     int // 先占用的名额数
+		want         bool
+		wantInFlight int64
+	}{
+		{name: "不限制", max: 0, acquired: 100, want: true, wantInFlight: 101},
+		{name: "未达到上限", max: 3, acquired: 2, want: true, wantInFlight: 3},
+		{name: "达到上限时拒绝", max: 3, acquired: 3, want: false, wantInFlight: 3},
+		{name: "排队等待超时", max: 1, queue: true, acquired: 1, want: false, wantInFlight: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := newTestLimiter(tt.max, tt.queue, 20*time.Millisecond)
+			for i := 0; i < tt.acquired; i++ {
+				if !l.Acquire(context.Background()) {
+					t.Fatalf("第%d次获取名额失败", i+1)
+				}
+			}
+
+			start := time.Now()
+			if got := l.Acquire(context.Background()); got != tt.want {
+				t.Fatalf("获取名额为%t，期望%t", got, tt.want)
+			}
+			if !tt.queue && time.Since(start) > 10*time.Millisecond {
+				t.Fatalf("拒绝模式不应等待，耗时%v", time.Since(start))
+			}
+			if tt.queue && !tt.want && time.Since(start) < 20*time.Millisecond {
+				t.Fatalf("排队模式应等待到超时，耗时%v", time.Since(start))
+			}
+
+			inFlight, queued := l.Stats()
+			if inFlight != tt.wantInFlight || queued != 0 {
+				t.Fatalf("进行中%d个、排队%d个，期望%d个和0个", inFlight, queued, tt.wantInFlight)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimiterQueueAcquiresOnRelease(t *testing.T) {
+	l := newTestLimiter(1, true, time.Second)
+	if !l.Acquire(context.Background()) {
+		t.Fatalf("获取名额失败")
+	}
+
+	first := acquireAsync(context.Background(), l)
+	second := acquireAsync(context.Background(), l)
+	waitQueued(t, l, 2)
+
+	// 每次归还只让一个排队的请求获得名额
+	l.Release()
+	var got []bool
+	select {
+	case ok := <-first:
+		got = append(got, ok)
+	case ok := <-second:
+		got = append(got, ok)
+	case <-time.After(time.Second):
+		t.Fatalf("归还名额后排队的请求未获得名额")
+	}
+	if !got[0] {
+		t.Fatalf("排队的请求应获得名额")
+	}
+	waitQueued(t, l, 1)
+
+	l.Release()
+	select {
+	case ok := <-first:
+		got = append(got, ok)
+	case ok := <-second:
+		got = append(got, ok)
+	case <-time.After(time.Second):
+		t.Fatalf("再次归还名额后剩余的请求未获得名额")
+	}
+	if !got[1] {
+		t.Fatalf("排队的请求应获得名额")
+	}
+
+	if inFlight, queued := l.Stats(); inFlight != 1 || queued != 0 {
+		t.Fatalf("进行中%d个、排队%d个，期望1个和0个", inFlight, queued)
+	}
+}
+
+func TestConcurrencyLimiterQueueCancelled(t *testing.T) {
+	l := newTestLimiter(1, true, time.Minute)
+	if !l.Acquire(context.Background()) {
+		t.Fatalf("获取名额失败")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	result := acquireAsync(ctx, l)
+	waitQueued(t, l, 1)
+	cancel()
+
+	select {
+	case ok := <-result:
+		if ok {
+			t.Fatalf("下游断开后不应获得名额")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("下游断开后排队的请求未结束")
+	}
+	if inFlight, queued := l.Stats(); inFlight != 1 || queued != 0 {
+		t.Fatalf("进行中%d个、排队%d个，期望1个和0个", inFlight, queued)
+	}
+}
+
+func TestConcurrencyLimiterConfigure(t *testing.T) {
+	t.Run("调低上限后等进行中的请求降到新上限以下", func(t *testing.T) {
+		l := newTestLimiter(3, false, 0)
+		for i := 0; i < 3; i++ {
+			l.Acquire(context.Background())
+		}
+
+		l.Configure(1, false, 0)
+		if inFlight, _ := l.Stats(); inFlight != 3 {
+			t.Fatalf("调整上限不应影响已占用的名额，进行中%d个", inFlight)
+		}
+		l.Release()
+		if l.Acquire(context.Background()) {
+			t.Fatalf("进行中2个、上限1时不应获得名额")
+		}
+		l.Release()
+		if l.Acquire(context.Background()) {
+			t.Fatalf("进行中1个、上限1时不应获得名额")
+		}
+		l.Release()
+		if !l.Acquire(context.Background()) {
+			t.Fatalf("进行中请求降到新上限以下后应获得名额")
+		}
+	})
+
+	t.Run("调高上限唤醒排队的请求", func(t *testing.T) {
+		l := newTestLimiter(1, true, time.Second)
+		l.Acquire(context.Background())
+
+		result := acquireAsync(context.Background(), l)
+		waitQueued(t, l, 1)
+		l.Configure(2, true, time.Second)
+
+		select {
+		case ok := <-result:
+			if !ok {
+				t.Fatalf("调高上限后排队的请求应获得名额")
+			}
+		case <-time.After(500 * time.Millisecond):
+			t.Fatalf("调高上限后排队的请求未被唤醒")
+		}
+	})
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// applyCORS 为浏览器直接访问的请求设置CORS响应头
+//
+// 参数:
+//   - w: HTTP响应写入器
+//   - r: HTTP请求对象
+//
+// 返回值:
+//   - bool: 是否为已处理完毕的预检请求
+func (p *ProxyHandler) applyCORS(w http.ResponseWriter, r *http.Request) bool {
+	cors := p.config.Server.CORS
+	if !*cors.Enabled {
+		return false
+	}
+
+	origin := r.Header.Get("Origin")
+	if origin == "" || !corsOriginAllowed(cors.AllowedOrigins, origin) {
+		return false
+	}
+
+	header := w.Header()
+	if containsString(cors.AllowedOrigins, "*") {
+		header.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		header.Set("Access-Control-Allow-Origin", origin)
+		header.Add("Vary", "Origin")
+	}
+
+	if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+
+	// 预检请求: 浏览器只需要响应头，不转发到上游
+	header.Set("Access-Control-Allow-Methods", strings.Join(cors.AllowedMethods, ", "))
+	if containsString(cors.AllowedHeaders, "*") {
+		// 通配符不包含Authorization，直接回显浏览器请求的请求头
+		if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			header.Set("Access-Control-Allow-Headers", requested)
+		}
+	} else {
+		header.Set("Access-Control-Allow-Headers", strings.Join(cors.AllowedHeaders, ", "))
+	}
+	if cors.MaxAge > 0 {
+		header.Set("Access-Control-Max-Age", strconv.Itoa(int(cors.MaxAge.Seconds())))
+	}
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// corsOriginAllowed 判断请求来源是否在允许列表中
+//
+// 参数:
+//   - allowed: 允许的来源列表，*表示全部
+//   - origin: 请求的Origin头
+//
+// 返回值:
+//   - bool: 是否允许
+func corsOriginAllowed(allowed []string, origin string) bool {
+	for _, value := range allowed {
+		if value == "*" || strings.EqualFold(value, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString 判断列表中是否包含指定字符串
+//
+// 参数:
+//   - values: 字符串列表
+//   - target: 目标字符串
+//
+// 返回值:
+//   - bool: 是否包含
+func containsString(values []string, target string) bool {
+	for _, value := range values {
+		if value == target {
+			return true
+		}
+	}
+	return false
+}
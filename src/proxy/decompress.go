@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// decodeResponseBody 按Content-Encoding解压上游响应体
+//
+// 传输层已禁用自动解压，但部分上游仍会返回压缩的响应体，
+// 解压后再记录日志和改写，下游收到的也是未压缩的内容
+//
+// 参数:
+//   - body: 上游响应体
+//   - contentEncoding: 上游响应的Content-Encoding头
+//
+// 返回值:
+//   - []byte: 解压后的响应体，未压缩时原样返回
+//   - bool: 是否进行了解压
+//   - error: 不支持的编码或解压失败时的错误
+func decodeResponseBody(body []byte, contentEncoding string) ([]byte, bool, error) {
+	encoding := strings.ToLower(strings.TrimSpace(contentEncoding))
+
+	var reader io.Reader
+	switch encoding {
+	case "", "identity":
+		return body, false, nil
+	case "gzip", "x-gzip":
+		gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return body, false, err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	case "deflate":
+		// HTTP的deflate应为zlib格式，但也有服务端直接发送原始deflate数据
+		zlibReader, err := zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			reader = flate.NewReader(bytes.NewReader(body))
+		} else {
+			defer zlibReader.Close()
+			reader = zlibReader
+		}
+	default:
+		return body, false, fmt.Errorf("不支持的Content-Encoding: %s", contentEncoding)
+	}
+
+	decoded, err := io.ReadAll(reader)
+	if err != nil {
+		return body, false, err
+	}
+	return decoded, true, nil
+}
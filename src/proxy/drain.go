@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"claude-mimic-gateway/utils"
+)
+
+// streamStopGrace 通知流式响应结束后，等待其写出终止事件的时间
+const streamStopGrace = 2 * time.Second
+
+// shutdownEvent 网关关闭时写给下游的SSE终止事件，格式与上游的error事件一致
+const shutdownEvent = "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Gateway is shutting down, please retry\"}}\n\n"
+
+// streamTracker 跟踪进行中的流式响应，关闭时等待其结束
+//
+// 配置重新加载会替换ProxyHandler，进行中的流式响应仍由旧的实例处理，
+// 因此使用包级别的实例统一跟踪
+type streamTracker struct {
+	wg     sync.WaitGroup
+	active int64
+
+	stopCtx context.Context // 关闭超时前取消，通知所有流式响应结束
+	stop    context.CancelFunc
+}
+
+var streams = newStreamTracker()
+
+// activeRequests 正在处理的代理请求数，与streams一样跨配置重新加载统计
+var activeRequests int64
+
+// ActiveRequests 获取正在处理的代理请求数
+//
+// 返回值:
+//   - int64: 正在处理的请求数
+//   - int64: 其中正在传输的流式响应数
+func ActiveRequests() (int64, int64) {
+	return atomic.LoadInt64(&activeRequests), atomic.LoadInt64(&streams.active)
+}
+
+// newStreamTracker 创建流式响应跟踪器
+//
+// 返回值:
+//   - *streamTracker: 跟踪器实例
+func newStreamTracker() *streamTracker {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &streamTracker{stopCtx: ctx, stop: cancel}
+}
+
+// track 登记一个流式响应
+//
+// 参数:
+//   - ctx: 请求上下文
+//
+// 返回值:
+//   - context.Context: 网关关闭时会被取消的上下文，用于上游请求和流式读取
+//   - func(): 流式响应结束时调用
+func (t *streamTracker) track(ctx context.Context) (context.Context, func()) {
+	t.wg.Add(1)
+	atomic.AddInt64(&t.active, 1)
+
+	ctx, cancel := context.WithCancel(ctx)
+	stopAfter := context.AfterFunc(t.stopCtx, cancel)
+
+	return ctx, func() {
+		stopAfter()
+		cancel()
+		atomic.AddInt64(&t.active, -1)
+		t.wg.Done()
+	}
+}
+
+// stopping 判断是否已通知流式响应结束
+//
+// 返回值:
+//   - bool: 网关是否正在强制结束流式响应
+func (t *streamTracker) stopping() bool {
+	return t.stopCtx.Err() != nil
+}
+
+// DrainStreams 等待进行中的流式响应结束
+//
+// 在ctx到期前streamStopGrace时，通知仍在传输的流式响应写出终止事件并结束，
+// 保证下游收到完整的SSE事件而不是被中途断开的连接
+//
+// 参数:
+//   - ctx: 关闭超时上下文
+func DrainStreams(ctx context.Context) {
+	active := atomic.LoadInt64(&streams.active)
+	if active == 0 {
+		return
+	}
+	utils.LogInfoLegacy(fmt.Sprintf("等待 %d 个进行中的流式响应结束", active))
+
+	done := make(chan struct{})
+	go func() {
+		streams.wg.Wait()
+		close(done)
+	}()
+
+	drainCtx := ctx
+	if deadline, ok := ctx.Deadline(); ok {
+		var cancel context.CancelFunc
+		drainCtx, cancel = context.WithDeadline(ctx, deadline.Add(-streamStopGrace))
+		defer cancel()
+	}
+
+	select {
+	case <-done:
+		utils.LogSuccessLegacy("所有流式响应已结束")
+		return
+	case <-drainCtx.Done():
+	}
+
+	utils.LogInfoLegacy(fmt.Sprintf("等待超时，结束剩余 %d 个流式响应", atomic.LoadInt64(&streams.active)))
+	streams.stop()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
+
+// writeShutdownEvent 向下游写出网关关闭的终止事件
+//
+// 上游数据块不按事件对齐，已转发的数据停在事件中间时先补一个空行结束该事件，
+// 避免终止事件被拼接进未完成的事件
+//
+// 参数:
+//   - w: HTTP响应写入器
+//   - flusher: 响应刷新器
+//   - boundary: 已转发数据的事件边界记录
+func writeShutdownEvent(w http.ResponseWriter, flusher http.Flusher, boundary *sseBoundaryTracker) {
+	if !boundary.AtBoundary() {
+		w.Write([]byte("\n\n"))
+	}
+	w.Write([]byte(shutdownEvent))
+	flusher.Flush()
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// apiErrorTypes HTTP状态码对应的Anthropic错误类型
+var apiErrorTypes = map[int]string{
+	http.StatusBadRequest:            "invalid_request_error",
+	http.StatusUnauthorized:          "authentication_error",
+	http.StatusForbidden:             "permission_error",
+	http.StatusNotFound:              "not_found_error",
+	http.StatusRequestEntityTooLarge: "request_too_large",
+	http.StatusUnsupportedMediaType:  "invalid_request_error",
+	http.StatusTooManyRequests:       "rate_limit_error",
+	http.StatusServiceUnavailable:    "overloaded_error",
+	529:                              "overloaded_error",
+}
+
+// apiErrorResponse Anthropic风格的错误响应体
+type apiErrorResponse struct {
+	Type  string `json:"type"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// writeAPIError 以Anthropic错误响应格式写出网关自身产生的错误
+//
+// 错误类型由状态码决定，未单独列出的状态码使用api_error
+//
+// 参数:
+//   - w: HTTP响应写入器
+//   - statusCode: HTTP状态码
+//   - message: 错误描述
+func writeAPIError(w http.ResponseWriter, statusCode int, message string) {
+	errorType, ok := apiErrorTypes[statusCode]
+	if !ok {
+		errorType = "api_error"
+	}
+
+	response := apiErrorResponse{Type: "error"}
+	response.Error.Type = errorType
+	response.Error.Message = message
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(&response)
+}
+
+// maxErrorSnippetBytes 上游错误响应体不是JSON时，写入日志错误信息的最大字节数
+const maxErrorSnippetBytes = 200
+
+// upstreamErrorSummary 从上游非200响应中提取错误信息，用于请求日志
+//
+// 响应体为Anthropic错误格式时提取error.type和error.message，
+// 否则附上响应体开头的一段文本
+//
+// 参数:
+//   - statusCode: 上游响应状态码
+//   - body: 上游响应体
+//
+// 返回值:
+//   - string: 错误描述
+func upstreamErrorSummary(statusCode int, body []byte) string {
+	summary := fmt.Sprintf("上游响应状态码错误: %d", statusCode)
+
+	var response apiErrorResponse
+	if err := json.Unmarshal(body, &response); err == nil && response.Error.Type != "" {
+		return fmt.Sprintf("%s，%s: %s", summary, response.Error.Type, response.Error.Message)
+	}
+
+	snippet := bytes.TrimSpace(body)
+	if len(snippet) == 0 {
+		return summary
+	}
+	if len(snippet) > maxErrorSnippetBytes {
+		snippet = snippet[:maxErrorSnippetBytes]
+	}
+	return fmt.Sprintf("%s，响应体: %s", summary, strings.ToValidUTF8(string(snippet), ""))
+}
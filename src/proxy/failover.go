@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+
+	"claude-mimic-gateway/utils"
+)
+
+// failoverUpstreamName 备用上游在日志中的名称
+const failoverUpstreamName = "备用上游"
+
+// shouldFailover 判断所选上游的请求结果是否需要切换到备用上游
+//
+// 参数:
+//   - ctx: 上游请求上下文
+//   - resp: 所选上游的响应（已完成重试），请求失败时为nil
+//   - err: 所选上游的请求错误
+//
+// 返回值:
+//   - bool: 是否切换到备用上游
+func (p *ProxyHandler) shouldFailover(ctx context.Context, resp *http.Response, err error) bool {
+	if !p.config.Upstream.Fallback.Configured() {
+		return false
+	}
+	if err != nil {
+		// 下游断开或总耗时预算耗尽时不再切换
+		return ctx.Err() == nil
+	}
+	return resp.StatusCode >= 500
+}
+
+// doFailover 使用同一请求体向备用上游发起请求
+//
+// 备用上游请求无法构造时返回所选上游原有的结果
+//
+// 参数:
+//   - ctx: 上游请求上下文
+//   - r: 下游请求
+//   - resp: 所选上游的响应，请求失败时为nil
+//   - err: 所选上游的请求错误
+//   - body: 转换后的请求体
+//   - trace: 下游分布式追踪上下文，可为nil
+//   - taskID: 任务ID
+//   - logData: 请求日志数据，记录实际使用的上游
+//
+// 返回值:
+//   - *http.Response: 最终的上游响应
+//   - error: 最终的上游请求错误
+func (p *ProxyHandler) doFailover(ctx context.Context, r *http.Request, resp *http.Response, err error, body []byte, trace *traceContext, taskID string, logData *utils.RequestLogData) (*http.Response, error) {
+	if err != nil {
+		utils.LogWarn(taskID, "上游请求失败，切换到备用上游: "+err.Error())
+	} else {
+		utils.LogWarn(taskID, "上游返回 "+resp.Status+"，切换到备用上游")
+	}
+
+	endpoint := p.config.Upstream.Fallback
+	var buildErr error
+	endpoint.URL, buildErr = resolveUpstreamURL(endpoint, r.URL.Path)
+	if buildErr != nil {
+		utils.LogError(taskID, "构造备用上游地址失败: "+buildErr.Error())
+		return resp, err
+	}
+	fallbackReq, buildErr := p.createUpstreamRequest(ctx, r, endpoint, body, trace, logData.RequestID)
+	if buildErr != nil {
+		utils.LogError(taskID, "创建备用上游请求失败: "+buildErr.Error())
+		return resp, err
+	}
+
+	if resp != nil {
+		resp.Body.Close()
+	}
+
+	// 日志中的上游请求改为实际发往备用上游的请求
+	logData.Upstream = failoverUpstreamName
+	logData.Failover = true
+	if logData.UpstreamRequest != nil {
+		logData.UpstreamRequest.URL = fallbackReq.URL.String()
+		logData.UpstreamRequest.Headers = make(map[string]string)
+		utils.FlattenHeaders(fallbackReq.Header, logData.UpstreamRequest.Headers)
+	}
+
+	utils.LogInfo(taskID, "向备用上游发起请求: "+fallbackReq.URL.String())
+	return p.doUpstreamWithRetry(fallbackReq, taskID)
+}
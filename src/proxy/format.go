@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// 非流式响应的下游输出格式
+const (
+	responseFormatRaw    = "raw"    // 原样返回上游响应体
+	responseFormatSimple = "simple" // 仅返回拼接后的文本和用量
+)
+
+// simpleResponse 简化的响应格式
+type simpleResponse struct {
+	ID         string          `json:"id,omitempty"`
+	Model      string          `json:"model,omitempty"`
+	Text       string          `json:"text"`
+	StopReason string          `json:"stop_reason,omitempty"`
+	Usage      json.RawMessage `json:"usage,omitempty"`
+}
+
+// resolveResponseFormat 从请求头或查询参数中获取下游要求的响应格式
+//
+// 参数:
+//   - r: HTTP请求对象
+//
+// 返回值:
+//   - string: 响应格式，未开启或未指定时为raw
+func (p *ProxyHandler) resolveResponseFormat(r *http.Request) string {
+	if !p.config.Response.FormatSelection {
+		return responseFormatRaw
+	}
+
+	format := r.Header.Get("X-Gateway-Response-Format")
+	if format == "" {
+		format = r.URL.Query().Get("response_format")
+	}
+
+	if strings.EqualFold(format, responseFormatSimple) {
+		return responseFormatSimple
+	}
+	return responseFormatRaw
+}
+
+// simplifyResponseBody 将Anthropic消息响应转换为简化格式
+//
+// 参数:
+//   - body: 上游响应体
+//
+// 返回值:
+//   - []byte: 简化后的响应体
+//   - error: 解析失败时的错误
+func simplifyResponseBody(body []byte) ([]byte, error) {
+	var message struct {
+		ID         string          `json:"id"`
+		Model      string          `json:"model"`
+		StopReason string          `json:"stop_reason"`
+		Usage      json.RawMessage `json:"usage"`
+		Content    []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if err := json.Unmarshal(body, &message); err != nil {
+		return nil, err
+	}
+
+	// 拼接所有text内容块
+	var text strings.Builder
+	for _, block := range message.Content {
+		if block.Type == "text" {
+			text.WriteString(block.Text)
+		}
+	}
+
+	return json.Marshal(&simpleResponse{
+		ID:         message.ID,
+		Model:      message.Model,
+		Text:       text.String(),
+		StopReason: message.StopReason,
+		Usage:      message.Usage,
+	})
+}
@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"claude-mimic-gateway/config"
+)
+
+func TestResolveResponseFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		enabled bool
+		header  string
+		query   string
+		want    string
+	}{
+		{name: "未开启时忽略请求头", enabled: false, header: "simple", want: responseFormatRaw},
+		{name: "未指定", enabled: true, want: responseFormatRaw},
+		{name: "请求头指定simple", enabled: true, header: "simple", want: responseFormatSimple},
+		{name: "大小写不敏感", enabled: true, header: "SIMPLE", want: responseFormatSimple},
+		{name: "查询参数指定simple", enabled: true, query: "simple", want: responseFormatSimple},
+		{name: "请求头优先于查询参数", enabled: true, header: "raw", query: "simple", want: responseFormatRaw},
+		{name: "未知格式按raw处理", enabled: true, header: "markdown", want: responseFormatRaw},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.Config{}
+			cfg.Response.FormatSelection = tt.enabled
+			handler := &ProxyHandler{config: cfg}
+
+			target := "/v1/messages"
+			if tt.query != "" {
+				target += "?response_format=" + tt.query
+			}
+			req := httptest.NewRequest(http.MethodPost, target, nil)
+			if tt.header != "" {
+				req.Header.Set("X-Gateway-Response-Format", tt.header)
+			}
+
+			if got := handler.resolveResponseFormat(req); got != tt.want {
+				t.Fatalf("响应格式为%q，期望%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSimplifyResponseBody(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    map[string]interface{}
+		wantErr bool
+	}{
+		{
+			name: "拼接text内容块",
+			body: `{"id":"msg_1","type":"message","model":"claude-sonnet-4-5","stop_reason":"end_turn",` +
+				`"content":[{"type":"text","text":"Hello, "},{"type":"tool_use","id":"t1","name":"f","input":{}},{"type":"text","text":"world"}],` +
+				`"usage":{"input_tokens":3,"output_tokens":5}}`,
+			want: map[string]interface{}{
+				"id":          "msg_1",
+				"model":       "claude-sonnet-4-5",
+				"text":        "Hello, world",
+				"stop_reason": "end_turn",
+				"usage":       map[string]interface{}{"input_tokens": 3.0, "output_tokens": 5.0},
+			},
+		},
+		{
+			name: "没有内容块",
+			body: `{"id":"msg_2","content":[]}`,
+			want: map[string]interface{}{"id": "msg_2", "text": ""},
+		},
+		{
+			name:    "无效JSON",
+			body:    `{"id":`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			simplified, err := simplifyResponseBody([]byte(tt.body))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("期望返回错误，得到%s", simplified)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("simplifyResponseBody失败: %v", err)
+			}
+
+			var got map[string]interface{}
+			if err := json.Unmarshal(simplified, &got); err != nil {
+				t.Fatalf("解析简化响应失败: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("简化响应为%v，期望%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNonStreamResponseFormats(t *testing.T) {
+	const upstreamBody = `{"id":"msg_1","type":"message","model":"claude-sonnet-4-5","stop_reason":"end_turn",` +
+		`"content":[{"type":"text","text":"Hi there"}],"usage":{"input_tokens":3,"output_tokens":2}}`
+	const errorBody = `{"type":"error","error":{"type":"invalid_request_error","message":"bad"}}`
+
+	tests := []struct {
+		name       string
+		enabled    bool
+		format     string
+		status     int
+		wantSimple bool
+	}{
+		{name: "默认返回原始响应", enabled: true, status: http.StatusOK},
+		{name: "简化格式", enabled: true, format: "simple", status: http.StatusOK, wantSimple: true},
+		{name: "未开启格式选择", enabled: false, format: "simple", status: http.StatusOK},
+		{name: "错误响应不简化", enabled: true, format: "simple", status: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := upstreamBody
+			if tt.status != http.StatusOK {
+				body = errorBody
+			}
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(tt.status)
+				io.WriteString(w, body)
+			}))
+			defer upstream.Close()
+
+			handler := newTestGateway(t, upstream, func(cfg *config.Config) {
+				cfg.Response.FormatSelection = tt.enabled
+			})
+			header := http.Header{}
+			if tt.format != "" {
+				header.Set("X-Gateway-Response-Format", tt.format)
+			}
+			resp := sendTestRequest(handler, `{"model":"claude-sonnet-4-5","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`, header)
+
+			if resp.Code != tt.status {
+				t.Fatalf("状态码为%d，期望%d: %s", resp.Code, tt.status, resp.Body.String())
+			}
+			if !tt.wantSimple {
+				if resp.Body.String() != body {
+					t.Fatalf("响应体为%s，期望原样返回%s", resp.Body.String(), body)
+				}
+				return
+			}
+
+			var got simpleResponse
+			if err := json.Unmarshal(resp.Body.Bytes(), &got); err != nil {
+				t.Fatalf("解析简化响应失败: %v", err)
+			}
+			if got.Text != "Hi there" || got.ID != "msg_1" || got.StopReason != "end_turn" {
+				t.Fatalf("简化响应不正确: %+v", got)
+			}
+		})
+	}
+}
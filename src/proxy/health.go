@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// upstreamProbeTimeout 深度健康检查探测单个上游的超时时间
+const upstreamProbeTimeout = 5 * time.Second
+
+// upstreamHealth 单个上游的连通性检查结果
+type upstreamHealth struct {
+	URL       string `json:"url"`
+	Reachable bool   `json:"reachable"`
+	Status    int    `json:"status,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// HandleHealth 处理健康检查请求
+//
+// 默认只返回网关自身状态；带deep=1参数或访问/ready时会探测所有上游的连通性，
+// 全部上游不可达时返回503，便于负载均衡器摘除无法提供服务的实例
+//
+// 参数:
+//   - w: HTTP响应写入器
+//   - r: HTTP请求对象
+func (p *ProxyHandler) HandleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	deep := r.URL.Path == "/ready" || r.URL.Query().Get("deep") == "1"
+	if !deep {
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"status":"ok","service":"claude-mimic-gateway"}`))
+		return
+	}
+
+	upstreams := p.probeUpstreams(r.Context())
+
+	status, statusCode := "unavailable", http.StatusServiceUnavailable
+	for _, upstream := range upstreams {
+		if upstream.Reachable {
+			status, statusCode = "ok", http.StatusOK
+			break
+		}
+	}
+
+	inFlight, queued := p.concurrency.Stats()
+
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    status,
+		"service":   "claude-mimic-gateway",
+		"upstreams": upstreams,
+		"in_flight": inFlight,
+		"queued":    queued,
+	})
+}
+
+// probeUpstreams 并发探测所有上游的连通性
+//
+// 只要上游返回任意HTTP响应即视为可达，不发送认证信息，也不消耗上游配额
+//
+// 参数:
+//   - ctx: 健康检查请求的上下文
+//
+// 返回值:
+//   - []upstreamHealth: 与配置顺序一致的检查结果
+func (p *ProxyHandler) probeUpstreams(ctx context.Context) []upstreamHealth {
+	endpoints := p.config.Upstream.Endpoints
+	results := make([]upstreamHealth, len(endpoints))
+
+	var wg sync.WaitGroup
+	for i, endpoint := range endpoints {
+		probeURL := endpoint.URL
+		if endpoint.BaseURL != "" {
+			probeURL = endpoint.BaseURL
+		}
+
+		wg.Add(1)
+		go func(i int, url string) {
+			defer wg.Done()
+			results[i] = p.probeUpstream(ctx, url)
+		}(i, probeURL)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// probeUpstream 向单个上游发送HEAD请求检查连通性
+//
+// 参数:
+//   - ctx: 上下文
+//   - url: 上游地址
+//
+// 返回值:
+//   - upstreamHealth: 检查结果
+func (p *ProxyHandler) probeUpstream(ctx context.Context, url string) upstreamHealth {
+	result := upstreamHealth{URL: url}
+
+	ctx, cancel := context.WithTimeout(ctx, upstreamProbeTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	result.LatencyMS = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	resp.Body.Close()
+
+	result.Reachable = true
+	result.Status = resp.StatusCode
+	return result
+}
@@ -0,0 +1,112 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+
+	"claude-mimic-gateway/config"
+)
+
+// ipFilter 按客户端IP过滤下游请求
+type ipFilter struct {
+	allowed    []*net.IPNet
+	denied     []*net.IPNet
+	trustProxy bool
+}
+
+// newIPFilter 根据配置创建IP过滤器，未配置任何规则时返回nil
+//
+// 参数:
+//   - cfg: 配置实例，IP规则已在配置验证阶段检查
+//
+// 返回值:
+//   - *ipFilter: IP过滤器
+func newIPFilter(cfg *config.Config) *ipFilter {
+	if len(cfg.Server.AllowedIPs) == 0 && len(cfg.Server.DeniedIPs) == 0 {
+		return nil
+	}
+
+	filter := &ipFilter{trustProxy: cfg.Server.TrustProxy}
+	for _, value := range cfg.Server.AllowedIPs {
+		ipNet, _ := config.ParseIPNet(value)
+		filter.allowed = append(filter.allowed, ipNet)
+	}
+	for _, value := range cfg.Server.DeniedIPs {
+		ipNet, _ := config.ParseIPNet(value)
+		filter.denied = append(filter.denied, ipNet)
+	}
+	return filter
+}
+
+// clientIP 获取下游客户端IP
+//
+// 开启trust_proxy时使用X-Forwarded-For中最右侧的地址，即紧邻网关的反向代理记录的对端地址；
+// 左侧的地址由客户端自行填写，不可信，不能用于访问控制
+//
+// 参数:
+//   - r: HTTP请求对象
+//
+// 返回值:
+//   - string: 客户端IP，无法解析时为原始地址
+func (f *ipFilter) clientIP(r *http.Request) string {
+	if f.trustProxy {
+		// 请求头可能出现多次，反向代理追加的地址在最后一个请求头的末尾
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			forwarded := values[len(values)-1]
+			if index := strings.LastIndexByte(forwarded, ','); index >= 0 {
+				forwarded = forwarded[index+1:]
+			}
+			if last := strings.TrimSpace(forwarded); last != "" {
+				return last
+			}
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Allow 判断客户端IP是否允许访问
+//
+// 参数:
+//   - r: HTTP请求对象
+//
+// 返回值:
+//   - bool: 是否允许
+//   - string: 用于日志的客户端IP
+func (f *ipFilter) Allow(r *http.Request) (bool, string) {
+	clientIP := f.clientIP(r)
+	ip := net.ParseIP(clientIP)
+	if ip == nil {
+		return false, clientIP
+	}
+
+	if containsIP(f.denied, ip) {
+		return false, clientIP
+	}
+	if len(f.allowed) > 0 && !containsIP(f.allowed, ip) {
+		return false, clientIP
+	}
+	return true, clientIP
+}
+
+// containsIP 判断IP是否属于任一网段
+//
+// 参数:
+//   - networks: 网段列表
+//   - ip: 要检查的IP
+//
+// 返回值:
+//   - bool: 是否匹配
+func containsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package proxy
+
+import (
+	"bytes"
+	"io"
+	"time"
+)
+
+// streamPingComment 保活用的SSE注释，客户端会忽略以冒号开头的行
+const streamPingComment = ": ping\n\n"
+
+// streamChunk 从上游读取到的一块数据
+type streamChunk struct {
+	data []byte
+	err  error
+}
+
+// readStreamChunks 在独立协程中持续读取上游响应体
+//
+// 读取与转发分离后，转发循环可以在等待上游数据的同时输出保活注释
+//
+// 参数:
+//   - body: 上游响应体
+//   - bufferSize: 每次读取的缓冲区大小
+//   - done: 转发结束时关闭，通知读取协程退出
+//
+// 返回值:
+//   - <-chan streamChunk: 读取结果，读取出错（包括io.EOF）后不再发送
+func readStreamChunks(body io.Reader, bufferSize int, done <-chan struct{}) <-chan streamChunk {
+	chunks := make(chan streamChunk)
+	go func() {
+		for {
+			buffer := make([]byte, bufferSize)
+			n, err := body.Read(buffer)
+			select {
+			case chunks <- streamChunk{data: buffer[:n], err: err}:
+			case <-done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return chunks
+}
+
+// sseBoundaryTracker 记录已转发数据是否停在SSE事件边界上
+//
+// 只有在完整事件之后插入注释才不会破坏事件流
+type sseBoundaryTracker struct {
+	tail []byte
+}
+
+// Write 记录已转发的数据块
+//
+// 参数:
+//   - chunk: 已转发的数据块
+func (t *sseBoundaryTracker) Write(chunk []byte) {
+	t.tail = append(t.tail, chunk...)
+	if len(t.tail) > 4 {
+		t.tail = append(t.tail[:0], t.tail[len(t.tail)-4:]...)
+	}
+}
+
+// AtBoundary 判断当前是否处于事件边界
+//
+// 返回值:
+//   - bool: 尚未转发数据或上一个事件已完整结束时为true
+func (t *sseBoundaryTracker) AtBoundary() bool {
+	return len(t.tail) == 0 || bytes.HasSuffix(t.tail, []byte("\n\n")) || bytes.HasSuffix(t.tail, []byte("\r\n\r\n"))
+}
+
+// resetTimer 安全地重置计时器，丢弃可能已触发但未读取的事件
+//
+// 参数:
+//   - timer: 计时器
+//   - d: 新的时长
+func resetTimer(timer *time.Timer, d time.Duration) {
+	if !timer.Stop() {
+		select {
+		case <-timer.C:
+		default:
+		}
+	}
+	timer.Reset(d)
+}
@@ -0,0 +1,151 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// replaceModelField 将JSON对象顶层的model字段替换为指定模型
+//
+// 只解析顶层键，其余字段的原始JSON保持不变
+//
+// 参数:
+//   - object: JSON对象
+//   - model: 替换后的模型名称
+//
+// 返回值:
+//   - []byte: 替换后的JSON对象
+//   - bool: 是否做了替换，不是JSON对象、没有model字段或已相同时为false
+func replaceModelField(object []byte, model string) ([]byte, bool) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(object, &fields); err != nil {
+		return nil, false
+	}
+
+	current, exists := fields["model"]
+	if !exists {
+		return nil, false
+	}
+	var currentModel string
+	if err := json.Unmarshal(current, &currentModel); err == nil && currentModel == model {
+		return nil, false
+	}
+
+	encoded, err := json.Marshal(model)
+	if err != nil {
+		return nil, false
+	}
+	fields["model"] = encoded
+
+	rewritten, err := json.Marshal(fields)
+	if err != nil {
+		return nil, false
+	}
+	return rewritten, true
+}
+
+// sseModelRewriter 改写流式响应message_start事件中的model字段
+//
+// message_start是流中的第一个事件，只检查第一行data，之后的数据原样透传；
+// 第一行data完整到达前的数据暂存，不会在跨越读取缓冲区边界的行中间输出
+type sseModelRewriter struct {
+	model   string
+	pending []byte
+	done    bool
+}
+
+// newSSEModelRewriter 创建流式响应的model字段改写器
+//
+// 参数:
+//   - model: 下游原始请求的模型名称
+//
+// 返回值:
+//   - *sseModelRewriter: 改写器
+func newSSEModelRewriter(model string) *sseModelRewriter {
+	return &sseModelRewriter{model: model}
+}
+
+// Write 处理一块流式响应数据
+//
+// 参数:
+//   - chunk: 从上游读取的数据块
+//
+// 返回值:
+//   - []byte: 可以转发给下游的数据，第一行data尚未完整时可能为空
+func (r *sseModelRewriter) Write(chunk []byte) []byte {
+	if r.done {
+		return chunk
+	}
+
+	r.pending = append(r.pending, chunk...)
+	var out []byte
+	for !r.done {
+		index := bytes.IndexByte(r.pending, '\n')
+		if index < 0 {
+			break
+		}
+		line := r.pending[:index+1]
+		if data, ok := bytes.CutPrefix(line, []byte("data:")); ok {
+			out = append(out, r.rewriteData(data, line)...)
+			r.done = true
+		} else {
+			out = append(out, line...)
+		}
+		r.pending = r.pending[index+1:]
+	}
+
+	if r.done {
+		out = append(out, r.pending...)
+		r.pending = nil
+	}
+	return out
+}
+
+// Flush 返回尚未输出的暂存数据，流结束时调用
+//
+// 返回值:
+//   - []byte: 暂存的数据
+func (r *sseModelRewriter) Flush() []byte {
+	pending := r.pending
+	r.pending = nil
+	r.done = true
+	return pending
+}
+
+// rewriteData 改写message_start事件的data行
+//
+// 参数:
+//   - data: 去掉"data:"前缀的行内容，包含换行符
+//   - line: 原始的整行
+//
+// 返回值:
+//   - []byte: 改写后的整行，不是message_start事件或解析失败时返回原始行
+func (r *sseModelRewriter) rewriteData(data, line []byte) []byte {
+	payload := bytes.TrimSpace(data)
+	lineEnding := data[len(bytes.TrimRight(data, "\r\n")):]
+
+	var event map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &event); err != nil {
+		return line
+	}
+	var eventType string
+	if err := json.Unmarshal(event["type"], &eventType); err != nil || eventType != "message_start" {
+		return line
+	}
+
+	message, ok := replaceModelField(event["message"], r.model)
+	if !ok {
+		return line
+	}
+	event["message"] = message
+
+	rewritten, err := json.Marshal(event)
+	if err != nil {
+		return line
+	}
+
+	result := make([]byte, 0, len(rewritten)+len(lineEnding)+6)
+	result = append(result, "data: "...)
+	result = append(result, rewritten...)
+	return append(result, lineEnding...)
+}
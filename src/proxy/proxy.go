@@ -3,14 +3,22 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net"
 	"net/http"
+	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 	"unicode/utf8"
 
@@ -20,8 +28,31 @@ import (
 
 // ProxyHandler 代理处理器结构体
 type ProxyHandler struct {
-	config *config.Config
-	client *http.Client
+	config        *config.Config
+	client        *http.Client
+	errorRewrites []*errorRewrite
+
+	retryBodyPatterns []*regexp.Regexp
+
+	shedder *loadShedder
+
+	breaker *circuitBreaker // 上游熔断器，未启用时为nil
+
+	mimicHeaders map[string]string // 合并配置后的Claude Code请求头
+
+	ipFilter *ipFilter // 下游客户端IP过滤，未配置时为nil
+
+	rateLimiter *rateLimiter // 按下游密钥的请求频率限制，未配置时为nil
+
+	concurrency *concurrencyLimiter // 并发请求限制，同时统计进行中的请求数
+
+	upstreamCounter uint64 // 轮询选择上游的计数器
+}
+
+// errorRewrite 编译后的错误响应体改写规则
+type errorRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
 }
 
 // NewProxyHandler 创建新的代理处理器实例
@@ -56,16 +87,28 @@ func NewProxyHandler(cfg *config.Config) *ProxyHandler {
 		return conn, nil
 	}
 
-	// 创建HTTP/1.1传输层，禁用HTTP/2
+	// 最低TLS版本与CA证书文件已在配置验证阶段检查
+	minTLSVersion, _ := config.ParseTLSVersion(cfg.Upstream.TLS.MinVersion)
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.Upstream.TLS.InsecureSkipVerify,
+		MinVersion:         minTLSVersion,
+	}
+	if cfg.Upstream.TLS.CAFile != "" {
+		tlsConfig.RootCAs, _ = config.LoadCACertPool(cfg.Upstream.TLS.CAFile)
+		utils.LogInfoLegacy("上游连接额外信任CA证书: " + cfg.Upstream.TLS.CAFile)
+	}
+	if cfg.Upstream.TLS.InsecureSkipVerify {
+		utils.LogWarnLegacy("!!! 已开启upstream.tls.insecure_skip_verify，不再校验上游证书，连接可能被中间人窃听或篡改，切勿在生产环境使用 !!!")
+	}
+
+	// 创建传输层，默认强制使用HTTP/1.1
 	transport := &http.Transport{
 		DialContext: dialContext,
-		TLSClientConfig: &tls.Config{
-			InsecureSkipVerify: false,
-		},
+		TLSClientConfig: tlsConfig,
 		// 连接池设置，提升性能
-		MaxIdleConns:        100,
-		MaxIdleConnsPerHost: 10,
-		IdleConnTimeout:     90 * time.Second,
+		MaxIdleConns:        cfg.Upstream.Pool.MaxIdle,
+		MaxIdleConnsPerHost: cfg.Upstream.Pool.MaxIdlePerHost,
+		IdleConnTimeout:     cfg.Upstream.Pool.IdleTimeout,
 		TLSHandshakeTimeout:   15 * time.Second,
 		ResponseHeaderTimeout: 90 * time.Second,
 		// 禁用压缩，避免影响流式传输
@@ -74,8 +117,65 @@ func NewProxyHandler(cfg *config.Config) *ProxyHandler {
 		ForceAttemptHTTP2: false,
 		TLSNextProto:      make(map[string]func(authority string, c *tls.Conn) http.RoundTripper),
 	}
+	protocol := "HTTP/1.1"
+	if cfg.Upstream.HTTP2 {
+		// 允许通过ALPN协商HTTP/2，上游不支持时回退HTTP/1.1，拨号函数仍禁用Nagle算法
+		transport.ForceAttemptHTTP2 = true
+		transport.TLSNextProto = nil
+		protocol = "HTTP/2（协商失败时回退HTTP/1.1）"
+	}
 
-	utils.LogDebugLegacy("已配置HTTP/1.1传输层，禁用Nagle算法")
+	// 配置上游代理，代理地址已在配置验证阶段检查
+	if cfg.Upstream.ProxyURL != "" {
+		proxyURL, _ := url.Parse(cfg.Upstream.ProxyURL)
+		if proxyURL.Scheme == "socks5" || proxyURL.Scheme == "socks5h" {
+			// 通过SOCKS5隧道连接，连接代理服务器时仍使用禁用Nagle算法的拨号函数
+			transport.DialContext = newSOCKS5Dialer(proxyURL, dialContext).DialContext
+		} else {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+		utils.LogInfoLegacy("上游连接使用代理: " + proxyURL.Redacted())
+	}
+
+	utils.LogDebugLegacy("已配置" + protocol + "传输层，禁用Nagle算法")
+	utils.LogInfoLegacy(fmt.Sprintf("上游连接池: 最大空闲连接 %d，每主机最大空闲连接 %d，空闲超时 %v",
+		cfg.Upstream.Pool.MaxIdle, cfg.Upstream.Pool.MaxIdlePerHost, cfg.Upstream.Pool.IdleTimeout))
+
+	// 编译错误响应体改写规则（已在配置验证阶段检查合法性）
+	var errorRewrites []*errorRewrite
+	for _, rule := range cfg.Response.ErrorRewrites {
+		errorRewrites = append(errorRewrites, &errorRewrite{
+			pattern:     regexp.MustCompile(rule.Pattern),
+			replacement: rule.Replacement,
+		})
+	}
+
+	// 编译响应体重试触发条件
+	var retryBodyPatterns []*regexp.Regexp
+	for _, pattern := range cfg.Upstream.RetryBodyPatterns {
+		retryBodyPatterns = append(retryBodyPatterns, regexp.MustCompile(pattern))
+	}
+
+	// 创建自适应限流器
+	var shedder *loadShedder
+	if ls := cfg.LoadShedding; ls.Enabled {
+		shedder = newLoadShedder(ls.Window, ls.ErrorThreshold, ls.MinRequests, ls.MaxShedRate)
+	}
+
+	// 上游熔断器、按密钥的请求频率限制器和并发限制器跨配置重新加载共用，只更新参数
+	var breaker *circuitBreaker
+	if cb := cfg.CircuitBreaker; cb.Enabled {
+		upstreamBreaker.Configure(cb.FailureThreshold, cb.Cooldown)
+		breaker = upstreamBreaker
+	}
+
+	var limiter *rateLimiter
+	if cfg.Auth.RateLimit > 0 {
+		keyRateLimits.Configure(cfg.Auth.RateLimit, cfg.Auth.Burst)
+		limiter = keyRateLimits
+	}
+
+	requestSlots.Configure(cfg.Server.MaxConcurrent, cfg.Server.ConcurrencyOverflow == "queue", cfg.Server.QueueTimeout)
 
 	return &ProxyHandler{
 		config: cfg,
@@ -83,15 +183,44 @@ func NewProxyHandler(cfg *config.Config) *ProxyHandler {
 			Transport: transport,
 			Timeout:   600 * time.Second, // 与X-Stainless-Timeout保持一致
 		},
+		errorRewrites: errorRewrites,
+
+		retryBodyPatterns: retryBodyPatterns,
+
+		shedder: shedder,
+
+		breaker: breaker,
+
+		mimicHeaders: mergeMimicHeaders(cfg.Mimic.Headers),
+
+		ipFilter: newIPFilter(cfg),
+
+		concurrency: requestSlots,
+
+		rateLimiter: limiter,
 	}
 }
 
+// CloseIdleConnections 关闭到上游的空闲连接，处理器被替换后调用以释放连接池
+func (p *ProxyHandler) CloseIdleConnections() {
+	p.client.CloseIdleConnections()
+}
+
 // HandleRequest 处理代理请求的主要方法
 //
 // 参数:
 //   - w: HTTP响应写入器
 //   - r: HTTP请求对象
 func (p *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
+	// 设置CORS响应头，浏览器的预检请求到此结束
+	if p.applyCORS(w, r) {
+		return
+	}
+
+	// 统计进行中的请求，关闭超时时报告被中断的请求数
+	atomic.AddInt64(&activeRequests, 1)
+	defer atomic.AddInt64(&activeRequests, -1)
+
 	// 生成任务ID
 	taskID := utils.GenerateTaskID()
 	utils.LogInfo(taskID, "收到下游请求: " + r.Method + " " + r.URL.Path)
@@ -108,98 +237,278 @@ func (p *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 记录下游请求头
-	for key, values := range r.Header {
-		logData.DownstreamRequest.Headers[key] = strings.Join(values, ", ")
+	utils.FlattenHeaders(r.Header, logData.DownstreamRequest.Headers)
+
+	// 沿用下游的请求ID，没有时使用任务ID，并在响应中返回
+	logData.RequestID = resolveRequestID(r, taskID)
+	w.Header().Set(requestIDHeader, logData.RequestID)
+	if logData.RequestID != taskID {
+		utils.LogDebug(taskID, "下游请求ID: " + logData.RequestID)
+	}
+
+	// 解析分布式追踪上下文
+	trace := parseTraceparent(r)
+	if trace != nil {
+		logData.TraceID = trace.TraceID
+		utils.BindTraceID(taskID, trace.TraceID)
+		defer utils.UnbindTraceID(taskID)
+	}
+
+	// 检查客户端IP
+	if p.ipFilter != nil {
+		if allowed, clientIP := p.ipFilter.Allow(r); !allowed {
+			utils.LogError(taskID, "客户端IP不允许访问: "+clientIP)
+			logData.Success = false
+			logData.Error = "客户端IP不允许访问: " + clientIP
+			utils.SaveRequestLog(logData)
+			writeAPIError(w, http.StatusForbidden, "Client IP is not allowed")
+			return
+		}
 	}
 
 	// 验证密钥
-	if !p.validateAuth(r) {
+	authKey, authorized := p.validateAuth(r)
+	if !authorized {
 		utils.LogError(taskID, "密钥验证失败")
 		logData.Success = false
 		logData.Error = "密钥验证失败"
 		utils.SaveRequestLog(logData)
-		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		writeAPIError(w, http.StatusUnauthorized, "Invalid API key")
 		return
 	}
 	utils.LogDebug(taskID, "密钥验证成功")
 
-	// 读取原始请求体
+	// 按密钥限制请求频率
+	if p.rateLimiter != nil {
+		if allowed, wait := p.rateLimiter.Allow(authKey); !allowed {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			utils.LogError(taskID, fmt.Sprintf("请求频率超过限制，%d秒后可重试", retryAfter))
+			logData.Success = false
+			logData.Error = "请求频率超过限制"
+			utils.SaveRequestLog(logData)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			writeAPIError(w, http.StatusTooManyRequests, "Rate limit exceeded")
+			return
+		}
+	}
+
+	// 限制并发请求数，超出上限时按配置拒绝或排队
+	if !p.concurrency.Acquire(r.Context()) {
+		utils.LogError(taskID, "并发请求数超过上限，已拒绝请求")
+		logData.Success = false
+		logData.Error = "并发请求数超过上限"
+		utils.SaveRequestLog(logData)
+		w.Header().Set("Retry-After", "1")
+		writeAPIError(w, http.StatusServiceUnavailable, "Too many concurrent requests, please retry later")
+		return
+	}
+	defer p.concurrency.Release()
+
+	// 上游错误率过高时按比例拒绝请求，给上游恢复时间
+	if p.shedder != nil && p.shedder.ShouldShed() {
+		utils.LogError(taskID, "上游错误率过高，已拒绝请求")
+		logData.Success = false
+		logData.Error = "上游错误率过高，已拒绝请求"
+		utils.SaveRequestLog(logData)
+		w.Header().Set("Retry-After", "1")
+		writeAPIError(w, http.StatusServiceUnavailable, "Upstream is overloaded, please retry later")
+		return
+	}
+
+	// 只接受JSON请求体，避免表单等格式在转换阶段产生难以理解的错误
+	if contentType := r.Header.Get("Content-Type"); !isJSONContentType(contentType) {
+		utils.LogError(taskID, "不支持的Content-Type: " + contentType)
+		logData.Success = false
+		logData.Error = "不支持的Content-Type: " + contentType
+		utils.SaveRequestLog(logData)
+		writeAPIError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+		return
+	}
+
+	// 读取原始请求体，超过上限时立即停止读取，避免超大请求体占满内存
+	r.Body = http.MaxBytesReader(w, r.Body, p.config.Server.MaxBodyBytes)
 	body, err := io.ReadAll(r.Body)
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		utils.LogError(taskID, fmt.Sprintf("请求体超过上限 %d bytes，已拒绝请求", maxBytesErr.Limit))
+		logData.Success = false
+		logData.Error = fmt.Sprintf("请求体超过上限 %d bytes", maxBytesErr.Limit)
+		utils.SaveRequestLog(logData)
+		writeAPIError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("Request body exceeds the maximum size of %d bytes", maxBytesErr.Limit))
+		return
+	}
 	if err != nil {
 		utils.LogError(taskID, "读取请求体失败: " + err.Error())
 		logData.Success = false
 		logData.Error = "读取请求体失败: " + err.Error()
 		utils.SaveRequestLog(logData)
-		http.Error(w, "Bad Request", http.StatusBadRequest)
+		writeAPIError(w, http.StatusBadRequest, "Failed to read request body")
 		return
 	}
 	defer r.Body.Close()
 
 	// 记录下游请求体
-	logData.DownstreamRequest.Body = string(body)
-
-	// 解析请求体中的stream参数
-	isStream := p.parseStreamParameter(body)
-	utils.LogDebug(taskID, fmt.Sprintf("检测到stream参数: %t", isStream))
+	// 大请求体（如base64图片）的多份日志字段共用同一个字符串，避免重复复制
+	bodyString := string(body)
+	logData.DownstreamRequest.Body = bodyString
+	utils.SaveInProgressLog(logData)
 
 	// 转换请求体
-	transformedBody, err := utils.TransformRequestBody(body)
+	// count_tokens请求使用同样的转换流程，但去掉该接口不接受的字段
+	// 旧版/v1/complete请求只做基本校验后透传
+	transform := utils.TransformRequestBody
+	if isCountTokensPath(r.URL.Path) {
+		transform = utils.TransformCountTokensBody
+	} else if isCompletePath(r.URL.Path) {
+		transform = utils.TransformCompleteBody
+	}
+	transformResult, err := transform(body, acceptsEventStream(r.Header), p.authUserID(authKey))
 	if err != nil {
 		utils.LogError(taskID, "转换请求体失败: " + err.Error())
 		logData.Success = false
 		logData.Error = "转换请求体失败: " + err.Error()
 		utils.SaveRequestLog(logData)
 
-		// 检查是否为格式异常错误，返回对应状态码
-		if err.Error() == "格式异常" {
-			http.Error(w, "格式异常", http.StatusUnauthorized)
+		// 按错误类型返回对应状态码
+		if errors.Is(err, utils.ErrRequestBodyTooLarge) {
+			writeAPIError(w, http.StatusRequestEntityTooLarge, "Request body is too large")
+		} else if errors.Is(err, utils.ErrBareRequest) {
+			writeAPIError(w, http.StatusForbidden, "Request has no system prompt and was blocked by gateway policy")
+		} else if errors.Is(err, utils.ErrInvalidRequest) {
+			writeAPIError(w, http.StatusBadRequest, err.Error())
 		} else {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			writeAPIError(w, http.StatusInternalServerError, "Failed to transform request body")
 		}
 		return
 	}
 	utils.LogDebug(taskID, "请求体转换成功")
+	if transformResult.RequestedModel != transformResult.Model {
+		utils.LogInfo(taskID, fmt.Sprintf("模型别名 %s 已映射为 %s", transformResult.RequestedModel, transformResult.Model))
+	}
+	transformedBody := transformResult.Body
+	logData.Model = transformResult.Model
+
+	// 流式模式由转换阶段统一确定，避免重复解析请求体
+	isStream := transformResult.Stream
+	utils.LogDebug(taskID, fmt.Sprintf("检测到stream参数: %t", isStream))
+
+	// 调试模式下将转换决策以SSE注释形式输出到流式响应
+	var debugComments []string
+	if p.config.Logging.DebugSSEComments && strings.EqualFold(r.Header.Get("X-Gateway-Debug"), "true") {
+		debugComments = transformResult.Decisions
+	}
+
+	// 整个请求的总耗时预算，所有上游尝试共享同一个上下文
+	// 以下游请求的上下文为基础，下游断开连接时上游请求和流式读取随之中止
+	ctx := r.Context()
+	if isStream {
+		// 登记流式响应，网关关闭时等待其结束或通知其写出终止事件
+		var done func()
+		ctx, done = streams.track(ctx)
+		defer done()
+	}
+	// 预算只约束得到上游响应头之前的重试和等待，收到响应后停止计时，不限制响应体的传输
+	stopBudget := func() {}
+	if budget := p.config.Upstream.RequestBudget; budget > 0 {
+		var cancel context.CancelFunc
+		ctx, stopBudget, cancel = withRequestBudget(ctx, budget)
+		defer cancel()
+	}
+
+	// 上游熔断期间直接拒绝，不再向上游发起请求
+	permit, allowed := p.breaker.Allow()
+	if !allowed {
+		utils.LogError(taskID, "上游熔断中，已拒绝请求")
+		logData.Success = false
+		logData.Error = "上游熔断中，已拒绝请求"
+		utils.SaveRequestLog(logData)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(p.config.CircuitBreaker.Cooldown.Seconds()))))
+		writeAPIError(w, http.StatusServiceUnavailable, "Upstream is unavailable, please retry later")
+		return
+	}
+
+	// 按模型路由选择上游，未匹配时轮询默认上游
+	upstreamName, endpoint := p.selectUpstream(transformResult.Model)
+	endpoint.URL, err = resolveUpstreamURL(endpoint, r.URL.Path)
+	if err != nil {
+		permit.Release()
+		utils.LogError(taskID, "构造上游地址失败: "+err.Error())
+		logData.Success = false
+		logData.Error = "构造上游地址失败: " + err.Error()
+		utils.SaveRequestLog(logData)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to resolve upstream URL")
+		return
+	}
+	utils.LogInfo(taskID, fmt.Sprintf("使用%s: %s", upstreamName, endpoint.URL))
 
 	// 创建上游请求
-	upstreamReq, err := p.createUpstreamRequest(r, transformedBody)
+	upstreamReq, err := p.createUpstreamRequest(ctx, r, endpoint, transformedBody, trace, logData.RequestID)
 	if err != nil {
+		permit.Release()
 		utils.LogError(taskID, "创建上游请求失败: " + err.Error())
 		logData.Success = false
 		logData.Error = "创建上游请求失败: " + err.Error()
 		utils.SaveRequestLog(logData)
-		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		writeAPIError(w, http.StatusInternalServerError, "Failed to build upstream request")
 		return
 	}
 
 	// 记录上游请求信息
+	transformedString := string(transformedBody)
 	logData.UpstreamRequest = &utils.RequestDetails{
 		Method:          upstreamReq.Method,
 		URL:             upstreamReq.URL.String(),
 		Headers:         make(map[string]string),
-		Body:            string(transformedBody), // 保持向后兼容
-		OriginalBody:    string(body),           // 转换前的原始请求体
-		TransformedBody: string(transformedBody), // 转换后的请求体
+		Body:            transformedString, // 保持向后兼容
+		OriginalBody:    bodyString,        // 转换前的原始请求体
+		TransformedBody: transformedString, // 转换后的请求体
 	}
 
 	// 记录上游请求头
-	for key, values := range upstreamReq.Header {
-		logData.UpstreamRequest.Headers[key] = strings.Join(values, ", ")
-	}
+	utils.FlattenHeaders(upstreamReq.Header, logData.UpstreamRequest.Headers)
 
 	// 发起上游请求
 	utils.LogInfo(taskID, "向上游发起请求: " + upstreamReq.URL.String())
-	upstreamResp, err := p.client.Do(upstreamReq)
+	logData.UpstreamStart = time.Now()
+	upstreamResp, err := p.doUpstreamWithRetry(upstreamReq, taskID)
+	logData.Upstream = upstreamName
+
+	// 所选上游不可用时使用同一请求体切换到备用上游
+	if p.shouldFailover(ctx, upstreamResp, err) {
+		upstreamResp, err = p.doFailover(ctx, r, upstreamResp, err, transformedBody, trace, taskID, logData)
+	}
+	if err == nil {
+		stopBudget()
+	}
+	logData.UpstreamLatencyMS = time.Since(logData.UpstreamStart).Milliseconds()
+	if p.shedder != nil {
+		p.shedder.Record(err != nil || isUpstreamFailure(upstreamResp.StatusCode))
+	}
 	if err != nil {
 		utils.LogError(taskID, "上游请求失败: " + err.Error())
 		logData.Success = false
 		logData.Error = "上游请求失败: " + err.Error()
 		utils.SaveRequestLog(logData)
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		if r.Context().Err() != nil {
+			// 下游已断开连接，无需再写出响应，也不计入熔断统计
+			permit.Release()
+			utils.LogInfo(taskID, "下游客户端已断开连接，已取消上游请求")
+		} else if isStream && streams.stopping() {
+			permit.Release()
+			writeAPIError(w, http.StatusServiceUnavailable, "Gateway is shutting down, please retry")
+		} else if errors.Is(context.Cause(ctx), context.DeadlineExceeded) || errors.Is(err, context.DeadlineExceeded) {
+			// 总耗时预算或单次请求超时已耗尽
+			permit.Record(true)
+			writeAPIError(w, http.StatusGatewayTimeout, "Upstream request timed out")
+		} else {
+			permit.Record(true)
+			writeAPIError(w, http.StatusBadGateway, "Upstream request failed")
+		}
 		return
 	}
 	defer upstreamResp.Body.Close()
 
-	utils.LogInfo(taskID, "收到上游响应，状态码: " + upstreamResp.Status)
+	utils.LogInfo(taskID, fmt.Sprintf("收到上游响应，状态码: %s，耗时: %dms", upstreamResp.Status, logData.UpstreamLatencyMS))
 
 	// 初始化上游响应信息
 	logData.UpstreamResponse = &utils.ResponseDetails{
@@ -208,152 +517,531 @@ func (p *ProxyHandler) HandleRequest(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// 记录上游响应头
-	for key, values := range upstreamResp.Header {
-		logData.UpstreamResponse.Headers[key] = strings.Join(values, ", ")
+	utils.FlattenHeaders(upstreamResp.Header, logData.UpstreamResponse.Headers)
+	utils.SaveInProgressLog(logData)
+
+	// 按配置将响应中的model字段改写回下游请求的模型
+	var responseModel string
+	if p.config.Gateway.RewriteResponseModel {
+		responseModel = transformResult.RequestedModel
 	}
 
 	// 根据stream参数选择不同的处理方式
 	if isStream {
 		// 流式处理：边转发边记录
 		utils.LogDebug(taskID, "使用流式处理模式")
-		p.handleStreamResponse(w, upstreamResp, logData, taskID)
+		p.handleStreamResponse(w, upstreamResp, logData, taskID, debugComments, responseModel, permit)
 	} else {
 		// 非流式处理：读取完整响应体
 		utils.LogDebug(taskID, "使用非流式处理模式")
-		p.handleNonStreamResponse(w, upstreamResp, logData, taskID)
+		p.handleNonStreamResponse(w, upstreamResp, logData, taskID, p.resolveResponseFormat(r), responseModel, permit)
 	}
 }
 
+// HandleStats 输出网关运行统计信息
+//
+// 参数:
+//   - w: HTTP响应写入器
+//   - r: HTTP请求对象
+func (p *ProxyHandler) HandleStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	stats := map[string]interface{}{
+		"load_shedding_enabled":   p.shedder != nil,
+		"circuit_breaker_enabled": p.breaker != nil,
+	}
+	if p.breaker != nil {
+		stats["circuit_breaker_state"] = p.breaker.State()
+	}
+	inFlight, queued := p.concurrency.Stats()
+	stats["in_flight"] = inFlight
+	stats["queued"] = queued
+	stats["max_concurrent"] = p.config.Server.MaxConcurrent
+	if p.shedder != nil {
+		shedRate, errorRate := p.shedder.Rates()
+		stats["shed_rate"] = shedRate
+		stats["upstream_error_rate"] = errorRate
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
 // validateAuth 验证请求密钥，支持多种认证头格式
 //
 // 参数:
 //   - r: HTTP请求对象
 //
 // 返回值:
+//   - string: 验证通过的密钥
 //   - bool: 验证结果
-func (p *ProxyHandler) validateAuth(r *http.Request) bool {
+func (p *ProxyHandler) validateAuth(r *http.Request) (string, bool) {
 	// 检查 Authorization 头
 	authHeader := r.Header.Get("Authorization")
 	if authHeader != "" {
 		// 支持Bearer token格式
 		if strings.HasPrefix(authHeader, "Bearer ") {
 			token := strings.TrimPrefix(authHeader, "Bearer ")
-			return token == p.config.Auth.Key
+			return token, p.isAuthKey(token)
 		}
 		// 直接比较Authorization头
-		if authHeader == p.config.Auth.Key {
-			return true
+		if p.isAuthKey(authHeader) {
+			return authHeader, true
 		}
 	}
 
 	// 检查 x-api-key 头
 	apiKeyHeader := r.Header.Get("x-api-key")
 	if apiKeyHeader != "" {
-		return apiKeyHeader == p.config.Auth.Key
+		return apiKeyHeader, p.isAuthKey(apiKeyHeader)
 	}
 
 	// 检查 X-API-Key 头（大小写兼容）
 	apiKeyHeaderCap := r.Header.Get("X-API-Key")
 	if apiKeyHeaderCap != "" {
-		return apiKeyHeaderCap == p.config.Auth.Key
+		return apiKeyHeaderCap, p.isAuthKey(apiKeyHeaderCap)
+	}
+
+	return "", false
+}
+
+// requestIDHeader 请求ID所在的请求头和响应头
+const requestIDHeader = "X-Request-Id"
+
+// maxRequestIDLength 下游请求ID允许的最大长度
+const maxRequestIDLength = 128
+
+// resolveRequestID 获取本次请求的请求ID
+//
+// 下游通过X-Request-Id指定且只包含可见ASCII字符时沿用，否则使用任务ID
+//
+// 参数:
+//   - r: HTTP请求对象
+//   - taskID: 任务ID
+//
+// 返回值:
+//   - string: 请求ID
+func resolveRequestID(r *http.Request, taskID string) string {
+	requestID := strings.TrimSpace(r.Header.Get(requestIDHeader))
+	if requestID == "" || len(requestID) > maxRequestIDLength {
+		return taskID
+	}
+	for i := 0; i < len(requestID); i++ {
+		if requestID[i] < 0x21 || requestID[i] > 0x7e {
+			return taskID
+		}
+	}
+	return requestID
+}
+
+// isAuthKey 判断密钥是否为auth.key或auth.keys中配置的密钥
+//
+// 参数:
+//   - key: 下游请求携带的密钥
+//
+// 返回值:
+//   - bool: 是否为有效密钥
+func (p *ProxyHandler) isAuthKey(key string) bool {
+	if key == p.config.Auth.Key {
+		return true
+	}
+	for _, authKey := range p.config.Auth.Keys {
+		if key == authKey.Key {
+			return true
+		}
+	}
+	return false
+}
+
+// authUserID 获取下游密钥对应的user_id
+//
+// 参数:
+//   - key: 验证通过的下游密钥
+//
+// 返回值:
+//   - string: auth.keys中为该密钥配置的user_id，未配置时为空，使用gateway.user_id
+func (p *ProxyHandler) authUserID(key string) string {
+	for _, authKey := range p.config.Auth.Keys {
+		if key == authKey.Key {
+			return authKey.UserID
+		}
+	}
+	return ""
+}
+
+// isCountTokensPath 判断是否为count_tokens接口
+//
+// 参数:
+//   - path: 请求路径
+//
+// 返回值:
+//   - bool: 是否为count_tokens请求
+func isCountTokensPath(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(path, "/"), "/count_tokens")
+}
+
+// countTokensURL 根据messages接口的上游地址构造count_tokens接口地址，保留查询参数
+//
+// 参数:
+//   - messagesURL: 配置的messages接口完整地址
+//
+// 返回值:
+//   - string: count_tokens接口地址
+//   - error: 地址解析失败时的错误
+func countTokensURL(messagesURL string) (string, error) {
+	u, err := url.Parse(messagesURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/count_tokens"
+	return u.String(), nil
+}
+
+// resolveUpstreamURL 确定本次请求实际访问的上游地址
+//
+// base_url模式下将下游请求路径拼接到基础地址后；url模式下直接使用配置的完整地址，
+// count_tokens请求在其路径后追加/count_tokens，complete请求将末尾的/messages替换为/complete
+//
+// 参数:
+//   - endpoint: 所选上游
+//   - requestPath: 下游请求路径
+//
+// 返回值:
+//   - string: 上游地址
+//   - error: 地址解析失败时的错误
+func resolveUpstreamURL(endpoint config.UpstreamEndpoint, requestPath string) (string, error) {
+	if endpoint.BaseURL != "" {
+		u, err := url.Parse(endpoint.BaseURL)
+		if err != nil {
+			return "", err
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + strings.TrimPrefix(requestPath, "/")
+		u.RawPath = ""
+		return u.String(), nil
+	}
+
+	if isCountTokensPath(requestPath) {
+		return countTokensURL(endpoint.URL)
+	}
+	if isCompletePath(requestPath) {
+		return completeURL(endpoint.URL)
 	}
+	return endpoint.URL, nil
+}
+
+// isCompletePath 判断请求路径是否为旧版Text Completions接口
+//
+// 参数:
+//   - path: 请求路径
+//
+// 返回值:
+//   - bool: 是否为/v1/complete请求
+func isCompletePath(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(path, "/"), "/complete")
+}
 
+// acceptsEventStream 判断下游请求头Accept是否声明接受text/event-stream
+//
+// 参数:
+//   - header: 下游请求头
+//
+// 返回值:
+//   - bool: Accept中是否包含text/event-stream
+func acceptsEventStream(header http.Header) bool {
+	for _, value := range header.Values("Accept") {
+		for _, mediaRange := range strings.Split(value, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream") {
+				return true
+			}
+		}
+	}
 	return false
 }
 
+// isJSONContentType 判断请求的Content-Type是否为JSON
+//
+// 参数:
+//   - contentType: 下游请求的Content-Type头，可带charset等参数
+//
+// 返回值:
+//   - bool: 媒体类型为application/json时返回true，缺失时返回false
+func isJSONContentType(contentType string) bool {
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	return strings.EqualFold(strings.TrimSpace(mediaType), "application/json")
+}
+
+// completeURL 根据messages接口的上游地址构造complete接口地址，保留查询参数
+//
+// 参数:
+//   - messagesURL: 配置的messages接口完整地址
+//
+// 返回值:
+//   - string: complete接口地址
+//   - error: 地址解析失败时的错误
+func completeURL(messagesURL string) (string, error) {
+	u, err := url.Parse(messagesURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path = strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), "/messages") + "/complete"
+	return u.String(), nil
+}
+
+// nextUpstream 按轮询方式选择本次请求使用的上游
+//
+// 返回值:
+//   - int: 上游序号（从0开始）
+//   - config.UpstreamEndpoint: 所选上游
+func (p *ProxyHandler) nextUpstream() (int, config.UpstreamEndpoint) {
+	endpoints := p.config.Upstream.Endpoints
+	index := int((atomic.AddUint64(&p.upstreamCounter, 1) - 1) % uint64(len(endpoints)))
+	return index, endpoints[index]
+}
+
+// selectUpstream 根据请求的模型选择上游
+//
+// 按配置顺序匹配模型路由，没有匹配的路由时轮询默认上游
+//
+// 参数:
+//   - model: 请求的模型名称
+//
+// 返回值:
+//   - string: 上游描述，用于日志
+//   - config.UpstreamEndpoint: 选中的上游
+func (p *ProxyHandler) selectUpstream(model string) (string, config.UpstreamEndpoint) {
+	for _, route := range p.config.Routes {
+		if route.Matches(model) {
+			return fmt.Sprintf("模型路由 %s 的上游", route.Model), route.UpstreamEndpoint
+		}
+	}
+
+	index, endpoint := p.nextUpstream()
+	return fmt.Sprintf("第 %d 个上游", index+1), endpoint
+}
+
 // createUpstreamRequest 创建上游请求
 //
 // 参数:
+//   - ctx: 上游请求上下文，携带总耗时预算
 //   - originalReq: 原始HTTP请求
+//   - endpoint: 本次使用的上游
 //   - body: 转换后的请求体
+//   - trace: 下游分布式追踪上下文，可为nil
+//   - requestID: 本次请求的请求ID
 //
 // 返回值:
 //   - *http.Request: 创建的上游请求
 //   - error: 可能的错误
-func (p *ProxyHandler) createUpstreamRequest(originalReq *http.Request, body []byte) (*http.Request, error) {
-	// 直接使用配置文件中的完整上游URL，不进行路径拼接
-	upstreamURL := p.config.Upstream.URL
-
-	// 创建新请求，使用完整的上游URL
-	req, err := http.NewRequest(originalReq.Method, upstreamURL, bytes.NewReader(body))
+func (p *ProxyHandler) createUpstreamRequest(ctx context.Context, originalReq *http.Request, endpoint config.UpstreamEndpoint, body []byte, trace *traceContext, requestID string) (*http.Request, error) {
+	// 创建新请求，endpoint.URL已由resolveUpstreamURL解析为本次请求的完整地址
+	req, err := http.NewRequestWithContext(ctx, originalReq.Method, endpoint.URL, bytes.NewReader(body))
 	if err != nil {
 		return nil, err
 	}
 
 	// 设置Claude Code标准请求头
-	p.setClaudeCodeHeaders(req)
+	p.setClaudeCodeHeaders(req, originalReq.Header, body, endpoint.Key)
+
+	// 传递分布式追踪上下文，上游这一跳使用新的span ID
+	if trace != nil && p.config.Logging.TracePropagation {
+		req.Header.Set("traceparent", trace.childTraceparent())
+		if state := originalReq.Header.Get("tracestate"); state != "" {
+			req.Header.Set("tracestate", state)
+		}
+	}
+
+	// 传递请求ID，便于在上游日志中关联
+	if *p.config.Logging.RequestIDPropagation {
+		req.Header.Set(requestIDHeader, requestID)
+	}
 
 	return req, nil
 }
 
+// defaultMimicHeaders 默认的Claude Code标准请求头，可通过mimic.headers覆盖
+var defaultMimicHeaders = map[string]string{
+	"Accept":                                    "application/json",
+	"X-Stainless-Retry-Count":                  "0",
+	"X-Stainless-Timeout":                      "600",
+	"X-Stainless-Lang":                         "js",
+	"X-Stainless-Package-Version":              "0.60.0",
+	"X-Stainless-OS":                           "Windows",
+	"X-Stainless-Arch":                         "x64",
+	"X-Stainless-Runtime":                      "node",
+	"X-Stainless-Runtime-Version":              "v22.13.0",
+	"anthropic-dangerous-direct-browser-access": "true",
+	"anthropic-version":                        "2023-06-01",
+	"x-app":                                    "cli",
+	"User-Agent":                               "claude-cli/1.0.108 (external, cli)",
+	"content-type":                             "application/json",
+	"anthropic-beta":                           "claude-code-20250219,interleaved-thinking-2025-05-14,fine-grained-tool-streaming-2025-05-14",
+	"x-stainless-helper-method":                "stream",
+	"accept-language":                          "*",
+	"sec-fetch-mode":                           "cors",
+}
+
+// mergeMimicHeaders 将配置的请求头覆盖到默认请求头上
+//
+// 键名按HTTP规范化形式合并，配置值为空字符串时删除对应的默认请求头
+//
+// 参数:
+//   - overrides: mimic.headers中配置的请求头
+//
+// 返回值:
+//   - map[string]string: 合并后的请求头
+func mergeMimicHeaders(overrides map[string]string) map[string]string {
+	merged := make(map[string]string, len(defaultMimicHeaders)+len(overrides))
+	for key, value := range defaultMimicHeaders {
+		merged[http.CanonicalHeaderKey(key)] = value
+	}
+	for key, value := range overrides {
+		if value == "" {
+			delete(merged, http.CanonicalHeaderKey(key))
+			continue
+		}
+		merged[http.CanonicalHeaderKey(key)] = value
+	}
+	return merged
+}
+
 // setClaudeCodeHeaders 设置Claude Code标准请求头
 //
 // 参数:
 //   - req: HTTP请求对象
-func (p *ProxyHandler) setClaudeCodeHeaders(req *http.Request) {
-	// 设置标准的Claude Code请求头
-	headers := map[string]string{
-		"Accept":                                    "application/json",
-		"X-Stainless-Retry-Count":                  "0",
-		"X-Stainless-Timeout":                      "600",
-		"X-Stainless-Lang":                         "js",
-		"X-Stainless-Package-Version":              "0.60.0",
-		"X-Stainless-OS":                           "Windows",
-		"X-Stainless-Arch":                         "x64",
-		"X-Stainless-Runtime":                      "node",
-		"X-Stainless-Runtime-Version":              "v22.13.0",
-		"anthropic-dangerous-direct-browser-access": "true",
-		"anthropic-version":                        "2023-06-01",
-		"x-app":                                    "cli",
-		"User-Agent":                               "claude-cli/1.0.108 (external, cli)",
-		"content-type":                             "application/json",
-		"anthropic-beta":                           "claude-code-20250219,interleaved-thinking-2025-05-14,fine-grained-tool-streaming-2025-05-14",
-		"x-stainless-helper-method":                "stream",
-		"accept-language":                          "*",
-		"sec-fetch-mode":                           "cors",
-		"Authorization":                            "Bearer " + p.config.Upstream.Key,
+//   - downstreamHeader: 下游原始请求头，用于透传配置的请求头
+//   - body: 转换后的请求体，用于计算签名
+//   - upstreamKey: 所选上游的API密钥
+func (p *ProxyHandler) setClaudeCodeHeaders(req *http.Request, downstreamHeader http.Header, body []byte, upstreamKey string) {
+	// 复制合并后的Claude Code请求头，认证头由上游密钥计算，不受配置影响
+	headers := make(map[string]string, len(p.mimicHeaders)+2)
+	for key, value := range p.mimicHeaders {
+		headers[key] = value
 	}
 
+	// 设置上游认证头
+	p.setUpstreamAuthHeaders(headers, body, upstreamKey)
+
 	for key, value := range headers {
 		req.Header.Set(key, value)
 	}
 
+	// 下游指定了允许的API版本时使用下游的版本
+	if version := downstreamHeader.Get("anthropic-version"); version != "" && version != req.Header.Get("anthropic-version") {
+		if p.anthropicVersionAllowed(version) {
+			req.Header.Set("anthropic-version", version)
+			utils.LogDebugLegacy("使用下游指定的anthropic-version: " + version)
+		} else {
+			utils.LogDebugLegacy("下游指定的anthropic-version不在允许列表中，使用默认版本: " + version)
+		}
+	}
+
+	// 合并下游请求的beta特性，保留模拟所需的默认beta
+	if values := downstreamHeader.Values("anthropic-beta"); len(values) > 0 {
+		merged := mergeBetaHeader(req.Header.Get("anthropic-beta"), values)
+		req.Header.Set("anthropic-beta", merged)
+		utils.LogDebugLegacy("已合并下游指定的anthropic-beta: " + merged)
+	}
+
+	// 按配置透传下游请求头，覆盖同名的模拟请求头
+	for _, key := range p.config.Mimic.PassthroughHeaders {
+		if values := downstreamHeader.Values(key); len(values) > 0 {
+			req.Header[http.CanonicalHeaderKey(key)] = append([]string(nil), values...)
+		}
+	}
+
+	// 按配置使用精确大小写写入请求头，绕过Go的规范化以匹配真实CLI的请求格式
+	applyExactCaseHeaders(req.Header, p.config.Upstream.ExactCaseHeaders)
+
 	utils.LogDebugLegacy("已设置Claude Code标准请求头")
 }
 
-// parseStreamParameter 解析请求体中的stream参数
+// mergeBetaHeader 合并默认与下游的anthropic-beta特性列表
+//
+// 默认特性在前，下游新增的特性按出现顺序追加，重复项只保留一次
 //
 // 参数:
-//   - body: 请求体字节数组
+//   - defaultValue: 模拟请求头中的anthropic-beta值，可为空
+//   - downstreamValues: 下游请求的anthropic-beta头（可能有多个）
 //
 // 返回值:
-//   - bool: 是否为流式请求
-func (p *ProxyHandler) parseStreamParameter(body []byte) bool {
-	// 解析JSON请求体
-	var requestData map[string]interface{}
-	if err := json.Unmarshal(body, &requestData); err != nil {
-		// 如果解析失败，默认为非流式
-		return false
-	}
-
-	// 检查stream字段
-	if streamValue, exists := requestData["stream"]; exists {
-		// 尝试转换为布尔类型
-		if streamBool, ok := streamValue.(bool); ok {
-			return streamBool
-		}
-		// 尝试从字符串转换
-		if streamStr, ok := streamValue.(string); ok {
-			if streamBool, err := strconv.ParseBool(streamStr); err == nil {
-				return streamBool
+//   - string: 逗号连接的特性列表
+func mergeBetaHeader(defaultValue string, downstreamValues []string) string {
+	seen := make(map[string]bool)
+	var betas []string
+	for _, value := range append([]string{defaultValue}, downstreamValues...) {
+		for _, beta := range strings.Split(value, ",") {
+			beta = strings.TrimSpace(beta)
+			if beta == "" || seen[beta] {
+				continue
 			}
+			seen[beta] = true
+			betas = append(betas, beta)
 		}
 	}
+	return strings.Join(betas, ",")
+}
 
-	// 默认为非流式
+// anthropicVersionAllowed 判断下游指定的API版本是否在允许列表中
+//
+// 参数:
+//   - version: 下游请求的anthropic-version头
+//
+// 返回值:
+//   - bool: 是否允许
+func (p *ProxyHandler) anthropicVersionAllowed(version string) bool {
+	for _, allowed := range p.config.Mimic.AllowedAnthropicVersions {
+		if version == allowed {
+			return true
+		}
+	}
 	return false
 }
 
+// setUpstreamAuthHeaders 按配置的认证方式设置上游认证头
+//
+// 参数:
+//   - headers: 待写入的请求头映射
+//   - body: 转换后的请求体
+//   - upstreamKey: 所选上游的API密钥
+func (p *ProxyHandler) setUpstreamAuthHeaders(headers map[string]string, body []byte, upstreamKey string) {
+	auth := p.config.Upstream.Auth
+	switch auth.Scheme {
+	case "hmac":
+		// 签名内容为时间戳与请求体的拼接
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(auth.HMAC.Secret))
+		mac.Write([]byte(timestamp))
+		mac.Write(body)
+		headers[auth.HMAC.TimestampHeader] = timestamp
+		headers[auth.HMAC.SignatureHeader] = hex.EncodeToString(mac.Sum(nil))
+	default:
+		headers["Authorization"] = "Bearer " + upstreamKey
+	}
+}
+
+// applyExactCaseHeaders 将指定请求头改为精确大小写的键
+//
+// Go的HTTP/1.1客户端会按map中的键原样写出请求头，直接操作map即可保留大小写
+//
+// 参数:
+//   - header: 请求头
+//   - exactKeys: 需要保留精确大小写的请求头名称列表
+func applyExactCaseHeaders(header http.Header, exactKeys []string) {
+	for _, key := range exactKeys {
+		canonicalKey := http.CanonicalHeaderKey(key)
+		values, exists := header[canonicalKey]
+		if !exists || canonicalKey == key {
+			continue
+		}
+		delete(header, canonicalKey)
+		header[key] = values
+	}
+}
+
 // handleStreamResponse 处理流式响应：边转发边记录
 //
 // 参数:
@@ -361,11 +1049,19 @@ func (p *ProxyHandler) parseStreamParameter(body []byte) bool {
 //   - upstreamResp: 上游响应
 //   - logData: 日志数据
 //   - taskID: 任务ID
-func (p *ProxyHandler) handleStreamResponse(w http.ResponseWriter, upstreamResp *http.Response, logData *utils.RequestLogData, taskID string) {
-	// 设置流式响应头
-	for key, values := range upstreamResp.Header {
-		w.Header().Set(key, strings.Join(values, ", "))
+//   - debugComments: 调试模式下在流开头输出的SSE注释，为空时不输出
+//   - responseModel: 改写message_start事件中model字段的目标模型，为空时不改写
+//   - permit: 熔断器放行许可，熔断器未启用时为nil
+func (p *ProxyHandler) handleStreamResponse(w http.ResponseWriter, upstreamResp *http.Response, logData *utils.RequestLogData, taskID string, debugComments []string, responseModel string, permit *breakerPermit) {
+	// 错误响应需要完整读取后改写，交给非流式处理
+	if upstreamResp.StatusCode >= 400 && len(p.errorRewrites) > 0 {
+		utils.LogDebug(taskID, "上游返回错误状态码，按非流式处理以改写错误响应体")
+		p.handleNonStreamResponse(w, upstreamResp, logData, taskID, responseFormatRaw, responseModel, permit)
+		return
 	}
+
+	// 设置流式响应头
+	copyResponseHeaders(w.Header(), upstreamResp.Header)
 	w.Header().Set("Cache-Control", "no-cache")
 	w.Header().Set("Connection", "keep-alive")
 	w.WriteHeader(upstreamResp.StatusCode)
@@ -373,6 +1069,9 @@ func (p *ProxyHandler) handleStreamResponse(w http.ResponseWriter, upstreamResp
 	// 创建缓冲区用于记录响应体
 	var responseBuffer bytes.Buffer
 
+	// 边转发边解析token用量
+	var usageParser sseUsageParser
+
 	// 获取flusher
 	flusher, canFlush := w.(http.Flusher)
 	if !canFlush {
@@ -380,54 +1079,134 @@ func (p *ProxyHandler) handleStreamResponse(w http.ResponseWriter, upstreamResp
 		logData.Success = false
 		logData.Error = "HTTP连接不支持流式传输"
 		utils.SaveRequestLog(logData)
+		permit.Release()
 		return
 	}
 
+	// 输出调试注释，SSE客户端会忽略以冒号开头的行
+	if len(debugComments) > 0 && upstreamResp.StatusCode == http.StatusOK {
+		writeSSEComments(w, debugComments)
+		flusher.Flush()
+	}
+
 	// 流式转发并记录响应体
 	const bufferSize = 4096
-	buffer := make([]byte, bufferSize)
 	totalBytesRead := 0
 
+	done := make(chan struct{})
+	defer close(done)
+	chunks := readStreamChunks(upstreamResp.Body, bufferSize, done)
+
+	// 上游长时间没有数据时输出保活注释，避免中间的代理或负载均衡器断开空闲连接
+	var pingTimer *time.Timer
+	var pingC <-chan time.Time
+	pingInterval := p.config.Gateway.StreamPingInterval
+	if pingInterval > 0 && upstreamResp.StatusCode == http.StatusOK {
+		pingTimer = time.NewTimer(pingInterval)
+		defer pingTimer.Stop()
+		pingC = pingTimer.C
+	}
+	var boundary sseBoundaryTracker
+
+	var modelRewriter *sseModelRewriter
+	if responseModel != "" && upstreamResp.StatusCode == http.StatusOK {
+		modelRewriter = newSSEModelRewriter(responseModel)
+	}
+
 	for {
-		n, err := upstreamResp.Body.Read(buffer)
+		var chunk streamChunk
+		select {
+		case <-pingC:
+			// 只在完整事件之后插入注释，不破坏正在传输的事件
+			if boundary.AtBoundary() {
+				w.Write([]byte(streamPingComment))
+				flusher.Flush()
+			}
+			pingTimer.Reset(pingInterval)
+			continue
+		case chunk = <-chunks:
+		}
+
+		n, err := len(chunk.data), chunk.err
 		if n > 0 {
+			if totalBytesRead == 0 {
+				logData.UpstreamFirstByteMS = time.Since(logData.UpstreamStart).Milliseconds()
+			}
 			totalBytesRead += n
-			chunk := buffer[:n]
 
-			// 同时写入响应和缓冲区
-			if _, writeErr := w.Write(chunk); writeErr != nil {
+			// 同时写入响应和缓冲区，日志记录上游的原始数据
+			data := chunk.data
+			if modelRewriter != nil {
+				data = modelRewriter.Write(chunk.data)
+			}
+			if _, writeErr := w.Write(data); writeErr != nil {
 				utils.LogError(taskID, "写入响应失败: " + writeErr.Error())
 				break
 			}
-			responseBuffer.Write(chunk)
+			writeLogBuffer(&responseBuffer, chunk.data, p.config.Logging.MaxBodyLogBytes)
+			usageParser.Write(chunk.data)
+			boundary.Write(data)
 
 			// 立即刷新
 			flusher.Flush()
+
+			// 有真实数据时推迟下一次保活注释
+			if pingTimer != nil {
+				resetTimer(pingTimer, pingInterval)
+			}
 		}
 
 		if err == io.EOF {
 			break
 		}
+		if err != nil && streams.stopping() && errors.Is(err, context.Canceled) {
+			// 网关正在关闭，写出终止事件让下游得到完整的SSE流
+			utils.LogInfo(taskID, "网关正在关闭，已结束流式响应")
+			if modelRewriter != nil {
+				pending := modelRewriter.Flush()
+				w.Write(pending)
+				boundary.Write(pending)
+			}
+			writeShutdownEvent(w, flusher, &boundary)
+			logData.Success = false
+			logData.Error = "网关关闭，流式响应被中止"
+			logData.Usage = usageParser.Usage()
+			utils.SaveRequestLog(logData)
+			permit.Release()
+			return
+		}
 		if err != nil {
 			utils.LogError(taskID, "读取上游响应体失败: " + err.Error())
 			logData.Success = false
 			logData.Error = "读取上游响应体失败: " + err.Error()
+			logData.Usage = usageParser.Usage()
 			utils.SaveRequestLog(logData)
+			if errors.Is(err, context.Canceled) {
+				// 下游断开导致的读取中止不代表上游故障
+				permit.Release()
+			} else {
+				permit.Record(true)
+			}
 			return
 		}
 	}
 
-	// 最后刷新一次
+	// 输出改写器暂存的剩余数据，最后刷新一次
+	if modelRewriter != nil {
+		w.Write(modelRewriter.Flush())
+	}
 	flusher.Flush()
 
 	// 记录响应体
 	logData.UpstreamResponse.Body = p.fixEncoding(responseBuffer.Bytes())
+	logData.Usage = usageParser.Usage()
 
 	// 判断请求是否成功
 	logData.Success = upstreamResp.StatusCode == 200
 	if !logData.Success {
-		logData.Error = fmt.Sprintf("上游响应状态码错误: %d", upstreamResp.StatusCode)
+		logData.Error = upstreamErrorSummary(upstreamResp.StatusCode, responseBuffer.Bytes())
 	}
+	permit.Record(!logData.Success && isUpstreamFailure(upstreamResp.StatusCode))
 
 	// 保存日志
 	utils.SaveRequestLog(logData)
@@ -448,7 +1227,10 @@ func (p *ProxyHandler) handleStreamResponse(w http.ResponseWriter, upstreamResp
 //   - upstreamResp: 上游响应
 //   - logData: 日志数据
 //   - taskID: 任务ID
-func (p *ProxyHandler) handleNonStreamResponse(w http.ResponseWriter, upstreamResp *http.Response, logData *utils.RequestLogData, taskID string) {
+//   - responseFormat: 下游要求的响应格式
+//   - responseModel: 改写响应体中model字段的目标模型，为空时不改写
+//   - permit: 熔断器放行许可，熔断器未启用时为nil
+func (p *ProxyHandler) handleNonStreamResponse(w http.ResponseWriter, upstreamResp *http.Response, logData *utils.RequestLogData, taskID string, responseFormat string, responseModel string, permit *breakerPermit) {
 	// 读取完整响应体
 	responseBody, err := io.ReadAll(upstreamResp.Body)
 	if err != nil {
@@ -456,26 +1238,66 @@ func (p *ProxyHandler) handleNonStreamResponse(w http.ResponseWriter, upstreamRe
 		logData.Success = false
 		logData.Error = "读取上游响应体失败: " + err.Error()
 		utils.SaveRequestLog(logData)
-		http.Error(w, "Bad Gateway", http.StatusBadGateway)
+		permit.Record(true)
+		writeAPIError(w, http.StatusBadGateway, "Failed to read upstream response body")
 		return
 	}
 
+	// 上游忽略了禁用压缩的要求时，先解压再记录和转发
+	decompressed := false
+	if contentEncoding := upstreamResp.Header.Get("Content-Encoding"); contentEncoding != "" {
+		if decoded, ok, err := decodeResponseBody(responseBody, contentEncoding); err != nil {
+			utils.LogError(taskID, "解压上游响应体失败，原样转发: " + err.Error())
+		} else if ok {
+			utils.LogDebug(taskID, fmt.Sprintf("已解压 %s 编码的上游响应体: %d -> %d bytes", contentEncoding, len(responseBody), len(decoded)))
+			responseBody = decoded
+			decompressed = true
+		}
+	}
+
 	// 记录响应体（修复编码问题）
 	logData.UpstreamResponse.Body = p.fixEncoding(responseBody)
+	logData.Usage = parseResponseUsage(responseBody)
 
 	// 判断请求是否成功
 	logData.Success = upstreamResp.StatusCode == 200
 	if !logData.Success {
-		logData.Error = fmt.Sprintf("上游响应状态码错误: %d", upstreamResp.StatusCode)
+		logData.Error = upstreamErrorSummary(upstreamResp.StatusCode, responseBody)
 	}
+	permit.Record(!logData.Success && isUpstreamFailure(upstreamResp.StatusCode))
 
 	// 保存日志
 	utils.SaveRequestLog(logData)
 
-	// 设置响应头
-	for key, values := range upstreamResp.Header {
-		w.Header().Set(key, strings.Join(values, ", "))
+	// 改写错误响应体，避免泄露上游实现细节
+	if upstreamResp.StatusCode >= 400 {
+		responseBody = p.rewriteErrorBody(responseBody, taskID)
+	}
+
+	// 将model字段改写回下游请求的模型
+	if responseModel != "" && upstreamResp.StatusCode == http.StatusOK {
+		if rewritten, ok := replaceModelField(responseBody, responseModel); ok {
+			responseBody = rewritten
+			utils.LogDebug(taskID, "已将响应中的model字段改写为: " + responseModel)
+		}
+	}
+
+	// 按下游要求转换为简化格式
+	if responseFormat == responseFormatSimple && upstreamResp.StatusCode == http.StatusOK {
+		if simplified, err := simplifyResponseBody(responseBody); err != nil {
+			utils.LogError(taskID, "转换简化响应格式失败，返回原始响应: " + err.Error())
+		} else {
+			responseBody = simplified
+			utils.LogDebug(taskID, "已转换为简化响应格式")
+		}
 	}
+
+	// 设置响应头，响应体可能已被改写，需要更新长度
+	copyResponseHeaders(w.Header(), upstreamResp.Header)
+	if decompressed {
+		w.Header().Del("Content-Encoding")
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(responseBody)))
 	w.WriteHeader(upstreamResp.StatusCode)
 
 	// 输出响应体
@@ -493,6 +1315,118 @@ func (p *ProxyHandler) handleNonStreamResponse(w http.ResponseWriter, upstreamRe
 	}
 }
 
+// writeSSEComments 以SSE注释格式写入多行文本
+//
+// 参数:
+//   - w: 输出目标
+//   - comments: 注释内容
+func writeSSEComments(w io.Writer, comments []string) {
+	var buf bytes.Buffer
+	for _, comment := range comments {
+		// 注释内容不能包含换行，否则会破坏SSE事件结构
+		comment = strings.NewReplacer("\r", " ", "\n", " ").Replace(comment)
+		buf.WriteString(": gateway " + comment + "\n")
+	}
+	// 空行结束注释块，不会产生任何事件
+	buf.WriteString("\n")
+	w.Write(buf.Bytes())
+}
+
+// writeLogBuffer 向记录日志用的响应体缓冲区追加数据，超出日志上限后不再累积
+//
+// 多保留一个字节，写入日志时即可识别为已截断
+//
+// 参数:
+//   - buf: 响应体缓冲区
+//   - data: 新读取的数据
+//   - limit: 日志中响应体的最大字节数，0表示不限制
+func writeLogBuffer(buf *bytes.Buffer, data []byte, limit int) {
+	if limit > 0 {
+		remaining := limit + 1 - buf.Len()
+		if remaining <= 0 {
+			return
+		}
+		if len(data) > remaining {
+			data = data[:remaining]
+		}
+	}
+	buf.Write(data)
+}
+
+// hopByHopHeaders 只对单跳连接有效、不能转发给下游的响应头（RFC 7230 6.1）
+//
+// Content-Length和Transfer-Encoding描述的是上游连接的分帧方式，
+// 网关转发时由Go按实际写出的响应体重新分帧
+var hopByHopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+	"Content-Length",
+}
+
+// copyResponseHeaders 复制上游响应头，保留多值头（如Set-Cookie）的每一个值
+//
+// 逐跳头、Connection头中列出的头以及分帧相关的头不会被复制
+//
+// 参数:
+//   - dst: 下游响应头
+//   - src: 上游响应头
+func copyResponseHeaders(dst, src http.Header) {
+	skip := make(map[string]bool, len(hopByHopHeaders))
+	for _, key := range hopByHopHeaders {
+		skip[key] = true
+	}
+	// 请求ID由网关设置，不使用上游的同名响应头
+	skip[requestIDHeader] = true
+	for _, value := range src.Values("Connection") {
+		for _, key := range strings.Split(value, ",") {
+			if key = strings.TrimSpace(key); key != "" {
+				skip[http.CanonicalHeaderKey(key)] = true
+			}
+		}
+	}
+
+	for key, values := range src {
+		if skip[http.CanonicalHeaderKey(key)] {
+			continue
+		}
+		dst.Del(key)
+		for _, value := range values {
+			dst.Add(key, value)
+		}
+	}
+}
+
+// rewriteErrorBody 按配置规则改写错误响应体
+//
+// 参数:
+//   - body: 原始错误响应体
+//   - taskID: 任务ID
+//
+// 返回值:
+//   - []byte: 改写后的响应体
+func (p *ProxyHandler) rewriteErrorBody(body []byte, taskID string) []byte {
+	if len(p.errorRewrites) == 0 {
+		return body
+	}
+
+	rewritten := body
+	for _, rule := range p.errorRewrites {
+		rewritten = rule.pattern.ReplaceAll(rewritten, []byte(rule.replacement))
+	}
+
+	if !bytes.Equal(rewritten, body) {
+		utils.LogDebug(taskID, "已按规则改写上游错误响应体")
+	}
+	return rewritten
+}
+
 // fixEncoding 修复中文编码问题
 //
 // 参数:
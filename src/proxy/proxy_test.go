@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestUpstreamMinTLSVersionApplied(t *testing.T) {
+	// 上游最高只支持TLS 1.2
+	upstream := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	upstream.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	upstream.StartTLS()
+	defer upstream.Close()
+
+	tests := []struct {
+		name       string
+		minVersion string
+		want       uint16
+		wantErr    bool
+	}{
+		{name: "TLS 1.2可以连接", minVersion: "1.2", want: tls.VersionTLS12},
+		{name: "要求TLS 1.3时握手失败", minVersion: "1.3", want: tls.VersionTLS13, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadTestConfig(t, "")
+			cfg.Upstream.TLS.MinVersion = tt.minVersion
+			cfg.Upstream.TLS.InsecureSkipVerify = true // httptest使用自签名证书
+			handler := NewProxyHandler(cfg)
+
+			transport, ok := handler.client.Transport.(*http.Transport)
+			if !ok {
+				t.Fatalf("上游客户端的传输层类型为%T", handler.client.Transport)
+			}
+			if got := transport.TLSClientConfig.MinVersion; got != tt.want {
+				t.Fatalf("最低TLS版本为%#x，期望%#x", got, tt.want)
+			}
+
+			resp, err := handler.client.Get(upstream.URL)
+			if tt.wantErr {
+				if err == nil {
+					resp.Body.Close()
+					t.Fatalf("上游只支持TLS 1.2时应握手失败")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("请求上游失败: %v", err)
+			}
+			resp.Body.Close()
+			if resp.TLS == nil || resp.TLS.Version != tls.VersionTLS12 {
+				t.Fatalf("协商的TLS版本不正确: %+v", resp.TLS)
+			}
+		})
+	}
+}
+
+func TestCopyResponseHeaders(t *testing.T) {
+	tests := []struct {
+		name string
+		dst  http.Header
+		src  http.Header
+		want http.Header
+	}{
+		{
+			name: "多个Set-Cookie逐个保留",
+			dst:  http.Header{},
+			src:  http.Header{"Set-Cookie": {"a=1; Path=/", "b=2; Expires=Wed, 21 Oct 2026 07:28:00 GMT"}},
+			want: http.Header{"Set-Cookie": {"a=1; Path=/", "b=2; Expires=Wed, 21 Oct 2026 07:28:00 GMT"}},
+		},
+		{
+			name: "替换下游已有的同名头",
+			dst:  http.Header{"Vary": {"Origin"}, "X-Request-Id": {"req-1"}},
+			src:  http.Header{"Vary": {"Accept-Encoding", "Authorization"}},
+			want: http.Header{"Vary": {"Accept-Encoding", "Authorization"}, "X-Request-Id": {"req-1"}},
+		},
+		{
+			name: "单值头",
+			dst:  http.Header{},
+			src:  http.Header{"Content-Type": {"application/json"}},
+			want: http.Header{"Content-Type": {"application/json"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			copyResponseHeaders(tt.dst, tt.src)
+			if !reflect.DeepEqual(tt.dst, tt.want) {
+				t.Fatalf("响应头为%v，期望%v", tt.dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestMultiValueUpstreamHeadersReachDownstream(t *testing.T) {
+	cookies := []string{"session=abc; Path=/; HttpOnly", "theme=dark; Expires=Wed, 21 Oct 2026 07:28:00 GMT"}
+
+	for _, stream := range []bool{false, true} {
+		t.Run(fmt.Sprintf("stream=%t", stream), func(t *testing.T) {
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for _, cookie := range cookies {
+					w.Header().Add("Set-Cookie", cookie)
+				}
+				if stream {
+					w.Header().Set("Content-Type", "text/event-stream")
+					io.WriteString(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				io.WriteString(w, `{"type":"message","content":[]}`)
+			}))
+			defer upstream.Close()
+
+			handler := newTestGateway(t, upstream, nil)
+			body := fmt.Sprintf(`{"model":"claude-sonnet-4-5","max_tokens":16,"stream":%t,"messages":[{"role":"user","content":"hi"}]}`, stream)
+			resp := sendTestRequest(handler, body, nil)
+
+			if resp.Code != http.StatusOK {
+				t.Fatalf("状态码为%d: %s", resp.Code, resp.Body.String())
+			}
+			if got := resp.Header().Values("Set-Cookie"); !reflect.DeepEqual(got, cookies) {
+				t.Fatalf("Set-Cookie为%q，期望%q", got, cookies)
+			}
+		})
+	}
+}
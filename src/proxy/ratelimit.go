@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// rateLimiter 按下游密钥分别计数的令牌桶限流器
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // 每秒补充的令牌数
+	burst   float64 // 令牌桶容量
+	buckets map[string]*tokenBucket
+}
+
+// tokenBucket 单个密钥的令牌桶状态
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// keyRateLimits 全局的按密钥限流器
+//
+// 与requestSlots一样跨配置重新加载保留，避免每次重新加载都清空各密钥已消耗的令牌
+var keyRateLimits = &rateLimiter{buckets: make(map[string]*tokenBucket)}
+
+// Configure 调整限流参数，已有的令牌桶保留，令牌数在下次请求时按新容量截断
+//
+// 参数:
+//   - perMinute: 每分钟允许的请求数
+//   - burst: 允许的突发请求数
+func (rl *rateLimiter) Configure(perMinute, burst int) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.rate = float64(perMinute) / 60
+	rl.burst = float64(burst)
+}
+
+// Allow 尝试为密钥消耗一个令牌
+//
+// 参数:
+//   - key: 下游密钥
+//
+// 返回值:
+//   - bool: 是否允许本次请求
+//   - time.Duration: 不允许时距离下一个令牌可用的等待时间
+func (rl *rateLimiter) Allow(key string) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: rl.burst, last: now}
+		rl.buckets[key] = bucket
+	}
+
+	// 按经过的时间补充令牌，不超过桶容量
+	bucket.tokens = math.Min(rl.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*rl.rate)
+	bucket.last = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	wait := time.Duration((1 - bucket.tokens) / rl.rate * float64(time.Second))
+	return false, wait
+}
@@ -0,0 +1,291 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"claude-mimic-gateway/utils"
+)
+
+// retryPeekLimit 检查重试触发条件时最多预读的响应体字节数
+const retryPeekLimit = 8192
+
+// retryableStatusCodes 需要重试的上游状态码
+var retryableStatusCodes = map[int]bool{
+	http.StatusTooManyRequests:     true,
+	http.StatusInternalServerError: true,
+	http.StatusBadGateway:          true,
+	http.StatusServiceUnavailable:  true,
+	529:                            true, // Anthropic overloaded
+}
+
+// doUpstreamWithRetry 发起上游请求，遇到可重试的错误时按指数退避重新发起
+//
+// 可重试的情况包括连接建立失败、特定状态码以及响应体匹配配置的触发条件；
+// 只在向下游写出任何数据之前检查并重试，预读的数据会放回响应体中继续转发
+//
+// 参数:
+//   - req: 上游请求，请求体需支持GetBody以便重放
+//   - taskID: 任务ID
+//
+// 返回值:
+//   - *http.Response: 最终的上游响应
+//   - error: 可能的错误
+func (p *ProxyHandler) doUpstreamWithRetry(req *http.Request, taskID string) (*http.Response, error) {
+	maxRetries := p.config.Upstream.MaxRetries
+	var retryAfter time.Duration
+
+	for attempt := 0; ; attempt++ {
+		attemptReq := req
+		if attempt > 0 {
+			// 带随机抖动的指数退避等待，不短于上游要求的Retry-After，受请求总耗时预算约束
+			delay := retryDelay(attempt, p.config.Upstream.RetryBaseDelay, p.config.Upstream.RetryMaxDelay, retryAfter)
+			utils.LogInfo(taskID, fmt.Sprintf("第 %d/%d 次重试上游请求，等待 %v", attempt, maxRetries, delay))
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-req.Context().Done():
+				timer.Stop()
+				return nil, req.Context().Err()
+			}
+
+			// 重放请求体
+			attemptReq = req.Clone(req.Context())
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, fmt.Errorf("重放上游请求体失败: %v", err)
+			}
+			attemptReq.Body = body
+			attemptReq.Header.Set("X-Stainless-Retry-Count", strconv.Itoa(attempt))
+			applyExactCaseHeaders(attemptReq.Header, p.config.Upstream.ExactCaseHeaders)
+		}
+
+		// 单次请求超时覆盖从发起请求到读完响应体的整个过程，响应体关闭时释放
+		cancel := context.CancelFunc(func() {})
+		if timeout := p.config.Upstream.RequestTimeout; timeout > 0 {
+			var attemptCtx context.Context
+			attemptCtx, cancel = context.WithTimeout(req.Context(), timeout)
+			attemptReq = attemptReq.WithContext(attemptCtx)
+		}
+
+		resp, err := p.client.Do(attemptReq)
+		canRetry := attempt < maxRetries
+		if err != nil {
+			cancel()
+			if canRetry && isDialError(err) {
+				utils.LogError(taskID, "连接上游失败，准备重试: "+err.Error())
+				continue
+			}
+			return nil, err
+		}
+
+		if !canRetry {
+			return withCancelOnClose(resp, cancel), nil
+		}
+
+		if retryableStatusCodes[resp.StatusCode] {
+			var ok bool
+			retryAfter, ok = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+			if ok && retryAfter > p.config.Upstream.RetryMaxDelay {
+				// 上游要求的等待时间超过上限，直接返回响应，由下游按Retry-After自行重试
+				utils.LogWarn(taskID, fmt.Sprintf("上游返回 %s，Retry-After %v 超过重试等待上限，不再重试", resp.Status, retryAfter))
+				return withCancelOnClose(resp, cancel), nil
+			}
+			utils.LogError(taskID, "上游返回可重试的状态码: "+resp.Status)
+			resp.Body.Close()
+			cancel()
+			continue
+		}
+		retryAfter = 0
+
+		if len(p.retryBodyPatterns) == 0 {
+			return withCancelOnClose(resp, cancel), nil
+		}
+
+		trigger := p.matchRetryBody(resp)
+		if trigger == "" {
+			return withCancelOnClose(resp, cancel), nil
+		}
+
+		utils.LogInfo(taskID, "上游响应体匹配重试触发条件: "+trigger)
+		resp.Body.Close()
+		cancel()
+	}
+}
+
+// retryDelay 计算第attempt次重试前的等待时间
+//
+// 按base * 2^(attempt-1)指数增长并以maxDelay为上限，实际等待时间在其一半到全部之间随机；
+// 上游通过Retry-After要求了更长的等待时间时以其为准
+//
+// 参数:
+//   - attempt: 重试次数，从1开始
+//   - base: 基础等待时间
+//   - maxDelay: 等待时间上限
+//   - retryAfter: 上一次响应的Retry-After，没有时为0
+//
+// 返回值:
+//   - time.Duration: 等待时间
+func retryDelay(attempt int, base, maxDelay, retryAfter time.Duration) time.Duration {
+	backoff := maxDelay
+	if shift := attempt - 1; shift < 32 && base<<shift > 0 && base<<shift < maxDelay {
+		backoff = base << shift
+	}
+
+	delay := backoff
+	if half := backoff / 2; half > 0 {
+		delay = half + time.Duration(rand.Int63n(int64(half)+1))
+	}
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// parseRetryAfter 解析Retry-After响应头
+//
+// 参数:
+//   - value: 响应头的值，可以是秒数或HTTP日期
+//   - now: 当前时间，用于计算HTTP日期距今的时长
+//
+// 返回值:
+//   - time.Duration: 要求的等待时间，HTTP日期已过去时为0
+//   - bool: 响应头是否存在且有效
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		if seconds > int64(math.MaxInt64/time.Second) {
+			return time.Duration(math.MaxInt64), true
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	if wait := at.Sub(now); wait > 0 {
+		return wait, true
+	}
+	return 0, true
+}
+
+// withRequestBudget 创建受总耗时预算约束的上下文
+//
+// 与context.WithTimeout不同，预算计时可以在得到上游响应后停止，
+// 避免长时间但正常的流式响应在传输中途被预算截断；预算耗尽时上下文的Cause为context.DeadlineExceeded
+//
+// 参数:
+//   - ctx: 父上下文
+//   - budget: 总耗时预算
+//
+// 返回值:
+//   - context.Context: 受预算约束的上下文
+//   - func(): 停止预算计时，之后上下文只随父上下文或cancel结束
+//   - context.CancelFunc: 释放上下文
+func withRequestBudget(ctx context.Context, budget time.Duration) (context.Context, func(), context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	timer := time.AfterFunc(budget, func() {
+		cancel(context.DeadlineExceeded)
+	})
+	return ctx, func() { timer.Stop() }, func() {
+		timer.Stop()
+		cancel(context.Canceled)
+	}
+}
+
+// withCancelOnClose 在响应体关闭时释放单次请求的超时上下文
+//
+// 参数:
+//   - resp: 上游响应
+//   - cancel: 单次请求上下文的取消函数
+//
+// 返回值:
+//   - *http.Response: 响应体已包装的上游响应
+func withCancelOnClose(resp *http.Response, cancel context.CancelFunc) *http.Response {
+	resp.Body = &cancelOnCloseBody{ReadCloser: resp.Body, cancel: cancel}
+	return resp
+}
+
+// cancelOnCloseBody 关闭时同时取消关联上下文的响应体
+type cancelOnCloseBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+// Close 关闭响应体并释放上下文
+//
+// 返回值:
+//   - error: 关闭响应体时的错误
+func (b *cancelOnCloseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
+
+// isDialError 判断错误是否发生在建立连接阶段，此时请求尚未发出，可以安全重试
+//
+// 参数:
+//   - err: 上游请求错误
+//
+// 返回值:
+//   - bool: 是否为连接建立失败
+func isDialError(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// matchRetryBody 预读响应体开头，检查是否匹配配置的重试触发条件
+//
+// 流式响应读到第一个完整事件即停止，避免为检查而长时间阻塞
+//
+// 参数:
+//   - resp: 上游响应，预读的数据会被放回Body
+//
+// 返回值:
+//   - string: 匹配到的触发条件，未匹配时为空字符串
+func (p *ProxyHandler) matchRetryBody(resp *http.Response) string {
+	var prefix bytes.Buffer
+	buffer := make([]byte, 4096)
+	for prefix.Len() < retryPeekLimit {
+		n, err := resp.Body.Read(buffer)
+		prefix.Write(buffer[:n])
+		if err != nil || bytes.Contains(prefix.Bytes(), []byte("\n\n")) {
+			break
+		}
+	}
+
+	// 将预读的数据放回响应体
+	resp.Body = &peekedBody{
+		Reader: io.MultiReader(bytes.NewReader(prefix.Bytes()), resp.Body),
+		Closer: resp.Body,
+	}
+
+	for _, pattern := range p.retryBodyPatterns {
+		if pattern.Match(prefix.Bytes()) {
+			return pattern.String()
+		}
+	}
+	return ""
+}
+
+// peekedBody 由预读数据和剩余响应体组成的响应体
+type peekedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,182 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"claude-mimic-gateway/config"
+)
+
+func TestWithRequestBudget(t *testing.T) {
+	t.Run("预算耗尽", func(t *testing.T) {
+		ctx, _, cancel := withRequestBudget(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("预算耗尽后上下文未结束")
+		}
+		if cause := context.Cause(ctx); !errors.Is(cause, context.DeadlineExceeded) {
+			t.Fatalf("Cause为%v，期望context.DeadlineExceeded", cause)
+		}
+	})
+
+	t.Run("停止计时后不再受预算约束", func(t *testing.T) {
+		ctx, stop, cancel := withRequestBudget(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		stop()
+		select {
+		case <-ctx.Done():
+			t.Fatalf("停止计时后上下文不应结束: %v", context.Cause(ctx))
+		case <-time.After(60 * time.Millisecond):
+		}
+	})
+
+	t.Run("释放上下文", func(t *testing.T) {
+		ctx, _, cancel := withRequestBudget(context.Background(), time.Hour)
+		cancel()
+
+		if cause := context.Cause(ctx); !errors.Is(cause, context.Canceled) {
+			t.Fatalf("Cause为%v，期望context.Canceled", cause)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name       string
+		attempt    int
+		base       time.Duration
+		maxDelay   time.Duration
+		retryAfter time.Duration
+		min, max   time.Duration
+	}{
+		{name: "第一次重试", attempt: 1, base: time.Second, maxDelay: 30 * time.Second, min: 500 * time.Millisecond, max: time.Second},
+		{name: "指数增长", attempt: 4, base: time.Second, maxDelay: 30 * time.Second, min: 4 * time.Second, max: 8 * time.Second},
+		{name: "达到上限", attempt: 10, base: time.Second, maxDelay: 30 * time.Second, min: 15 * time.Second, max: 30 * time.Second},
+		{name: "重试次数极大时不溢出", attempt: 200, base: time.Second, maxDelay: 30 * time.Second, min: 15 * time.Second, max: 30 * time.Second},
+		{name: "Retry-After更长时以其为准", attempt: 1, base: time.Second, maxDelay: 30 * time.Second, retryAfter: 5 * time.Second, min: 5 * time.Second, max: 5 * time.Second},
+		{name: "Retry-After更短时忽略", attempt: 3, base: time.Second, maxDelay: 30 * time.Second, retryAfter: time.Second, min: 2 * time.Second, max: 4 * time.Second},
+		{name: "基础等待等于上限", attempt: 2, base: 200 * time.Millisecond, maxDelay: 200 * time.Millisecond, min: 100 * time.Millisecond, max: 200 * time.Millisecond},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for i := 0; i < 100; i++ {
+				delay := retryDelay(tt.attempt, tt.base, tt.maxDelay, tt.retryAfter)
+				if delay < tt.min || delay > tt.max {
+					t.Fatalf("等待时间为%v，期望在%v到%v之间", delay, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name   string
+		value  string
+		want   time.Duration
+		wantOK bool
+	}{
+		{name: "未携带", value: "", wantOK: false},
+		{name: "秒数", value: "7", want: 7 * time.Second, wantOK: true},
+		{name: "带空白的秒数", value: " 3 ", want: 3 * time.Second, wantOK: true},
+		{name: "负数", value: "-1", wantOK: false},
+		{name: "HTTP日期", value: now.Add(90 * time.Second).Format(http.TimeFormat), want: 90 * time.Second, wantOK: true},
+		{name: "已过去的HTTP日期", value: now.Add(-time.Minute).Format(http.TimeFormat), want: 0, wantOK: true},
+		{name: "无效值", value: "soon", wantOK: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseRetryAfter(tt.value, now)
+			if ok != tt.wantOK || got != tt.want {
+				t.Fatalf("得到%v, %t，期望%v, %t", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestRequestBudgetLimitsRetries(t *testing.T) {
+	tests := []struct {
+		name        string
+		budget      time.Duration
+		maxRetries  int
+		wantStatus  int
+		maxAttempts int64
+		maxElapsed  time.Duration
+	}{
+		// 每次重试至少等待100ms，预算只够发起前几次请求
+		{name: "预算耗尽返回504", budget: 250 * time.Millisecond, maxRetries: 10, wantStatus: http.StatusGatewayTimeout, maxAttempts: 4, maxElapsed: time.Second},
+		// 未配置预算时用完重试次数后返回上游的最后一个响应
+		{name: "未配置预算", budget: 0, maxRetries: 2, wantStatus: http.StatusServiceUnavailable, maxAttempts: 3, maxElapsed: 2 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int64
+			upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt64(&attempts, 1)
+				w.WriteHeader(http.StatusServiceUnavailable)
+				io.WriteString(w, `{"type":"error","error":{"type":"overloaded_error","message":"busy"}}`)
+			}))
+			defer upstream.Close()
+
+			handler := newTestGateway(t, upstream, func(cfg *config.Config) {
+				cfg.Upstream.RequestBudget = tt.budget
+				cfg.Upstream.MaxRetries = tt.maxRetries
+				cfg.Upstream.RetryBaseDelay = 200 * time.Millisecond
+				cfg.Upstream.RetryMaxDelay = 200 * time.Millisecond
+			})
+
+			start := time.Now()
+			resp := sendTestRequest(handler, `{"model":"claude-sonnet-4-5","max_tokens":16,"messages":[{"role":"user","content":"hi"}]}`, nil)
+			elapsed := time.Since(start)
+
+			if resp.Code != tt.wantStatus {
+				t.Fatalf("状态码为%d，期望%d: %s", resp.Code, tt.wantStatus, resp.Body.String())
+			}
+			if got := atomic.LoadInt64(&attempts); got < 1 || got > tt.maxAttempts {
+				t.Fatalf("上游收到%d次请求，期望1到%d次", got, tt.maxAttempts)
+			}
+			if elapsed > tt.maxElapsed {
+				t.Fatalf("请求耗时%v，超过%v", elapsed, tt.maxElapsed)
+			}
+		})
+	}
+}
+
+func TestRequestBudgetDoesNotLimitResponseBody(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		// 收到响应头之后的传输时间超过预算
+		time.Sleep(150 * time.Millisecond)
+		io.WriteString(w, "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n")
+	}))
+	defer upstream.Close()
+
+	handler := newTestGateway(t, upstream, func(cfg *config.Config) {
+		cfg.Upstream.RequestBudget = 50 * time.Millisecond
+	})
+
+	resp := sendTestRequest(handler, `{"model":"claude-sonnet-4-5","max_tokens":16,"stream":true,"messages":[{"role":"user","content":"hi"}]}`, nil)
+	if resp.Code != http.StatusOK {
+		t.Fatalf("状态码为%d: %s", resp.Code, resp.Body.String())
+	}
+	if body := resp.Body.String(); !strings.Contains(body, "message_stop") {
+		t.Fatalf("流式响应被预算截断: %q", body)
+	}
+}
@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// loadShedder 基于近期上游错误率的自适应限流器
+//
+// 错误率超过阈值后按比例随机拒绝请求，错误率越高拒绝比例越大
+type loadShedder struct {
+	mu             sync.Mutex
+	window         time.Duration
+	errorThreshold float64
+	minRequests    int
+	maxShedRate    float64
+	outcomes       []shedOutcome
+}
+
+// shedOutcome 单次上游请求的结果
+type shedOutcome struct {
+	at     time.Time
+	failed bool
+}
+
+// newLoadShedder 创建自适应限流器
+//
+// 参数:
+//   - window: 统计错误率的滑动窗口
+//   - errorThreshold: 开始限流的错误率阈值
+//   - minRequests: 窗口内最少请求数，不足时不限流
+//   - maxShedRate: 最大拒绝比例
+//
+// 返回值:
+//   - *loadShedder: 限流器实例
+func newLoadShedder(window time.Duration, errorThreshold float64, minRequests int, maxShedRate float64) *loadShedder {
+	return &loadShedder{
+		window:         window,
+		errorThreshold: errorThreshold,
+		minRequests:    minRequests,
+		maxShedRate:    maxShedRate,
+	}
+}
+
+// Record 记录一次上游请求结果
+//
+// 参数:
+//   - failed: 是否失败
+func (ls *loadShedder) Record(failed bool) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	now := time.Now()
+	ls.prune(now)
+	ls.outcomes = append(ls.outcomes, shedOutcome{at: now, failed: failed})
+}
+
+// ShouldShed 按当前拒绝比例随机决定是否拒绝请求
+//
+// 返回值:
+//   - bool: 是否拒绝
+func (ls *loadShedder) ShouldShed() bool {
+	rate, _ := ls.Rates()
+	return rate > 0 && rand.Float64() < rate
+}
+
+// Rates 计算当前的拒绝比例和窗口内的错误率
+//
+// 返回值:
+//   - float64: 拒绝比例
+//   - float64: 错误率
+func (ls *loadShedder) Rates() (float64, float64) {
+	ls.mu.Lock()
+	defer ls.mu.Unlock()
+	ls.prune(time.Now())
+
+	total := len(ls.outcomes)
+	if total == 0 {
+		return 0, 0
+	}
+	failed := 0
+	for _, outcome := range ls.outcomes {
+		if outcome.failed {
+			failed++
+		}
+	}
+	errorRate := float64(failed) / float64(total)
+
+	if total < ls.minRequests || errorRate <= ls.errorThreshold {
+		return 0, errorRate
+	}
+
+	// 拒绝比例随错误率从阈值到100%线性增长
+	shedRate := ls.maxShedRate
+	if ls.errorThreshold < 1 {
+		shedRate = (errorRate - ls.errorThreshold) / (1 - ls.errorThreshold) * ls.maxShedRate
+	}
+	return shedRate, errorRate
+}
+
+// prune 移除滑动窗口之外的结果，调用方需持有锁
+func (ls *loadShedder) prune(now time.Time) {
+	cutoff := now.Add(-ls.window)
+	i := 0
+	for i < len(ls.outcomes) && ls.outcomes[i].at.Before(cutoff) {
+		i++
+	}
+	ls.outcomes = ls.outcomes[i:]
+}
@@ -0,0 +1,214 @@
+package proxy
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SOCKS5协议常量（RFC 1928 / RFC 1929）
+const (
+	socks5Version          = 0x05
+	socks5AuthNone         = 0x00
+	socks5AuthPassword     = 0x02
+	socks5AuthNoAcceptable = 0xff
+	socks5CmdConnect       = 0x01
+	socks5AddrIPv4         = 0x01
+	socks5AddrDomain       = 0x03
+	socks5AddrIPv6         = 0x04
+)
+
+// socks5HandshakeTimeout SOCKS5握手的超时时间
+const socks5HandshakeTimeout = 15 * time.Second
+
+// socks5Dialer 通过SOCKS5代理建立TCP连接
+type socks5Dialer struct {
+	proxyAddr     string
+	username      string
+	password      string
+	remoteResolve bool // socks5h: 由代理解析目标域名
+	dial          func(ctx context.Context, network, addr string) (net.Conn, error)
+}
+
+// newSOCKS5Dialer 根据代理地址创建SOCKS5拨号器
+//
+// 参数:
+//   - proxyURL: socks5://或socks5h://开头的代理地址，可包含用户名和密码
+//   - dial: 连接代理服务器使用的底层拨号函数
+//
+// 返回值:
+//   - *socks5Dialer: SOCKS5拨号器
+func newSOCKS5Dialer(proxyURL *url.URL, dial func(ctx context.Context, network, addr string) (net.Conn, error)) *socks5Dialer {
+	d := &socks5Dialer{
+		proxyAddr:     proxyURL.Host,
+		remoteResolve: proxyURL.Scheme == "socks5h",
+		dial:          dial,
+	}
+	if proxyURL.Port() == "" {
+		d.proxyAddr = net.JoinHostPort(proxyURL.Hostname(), "1080")
+	}
+	if proxyURL.User != nil {
+		d.username = proxyURL.User.Username()
+		d.password, _ = proxyURL.User.Password()
+	}
+	return d
+}
+
+// DialContext 连接代理服务器并请求其连接目标地址
+//
+// 参数:
+//   - ctx: 上下文
+//   - network: 网络类型，仅支持tcp
+//   - addr: 目标地址（host:port）
+//
+// 返回值:
+//   - net.Conn: 已建立隧道的连接
+//   - error: 可能的错误
+func (d *socks5Dialer) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, portText, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portText)
+	if err != nil || port < 0 || port > 65535 {
+		return nil, fmt.Errorf("无效的目标端口: %s", portText)
+	}
+
+	// socks5模式在本地解析域名，socks5h模式交给代理解析
+	if net.ParseIP(host) == nil && !d.remoteResolve {
+		ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		host = ips[0].String()
+	}
+
+	conn, err := d.dial(ctx, network, d.proxyAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	// 握手期间受上下文和固定超时共同约束
+	deadline := time.Now().Add(socks5HandshakeTimeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetDeadline(deadline)
+
+	if err := d.handshake(conn, host, port); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SOCKS5代理握手失败: %v", err)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// handshake 完成认证协商并发送CONNECT请求
+//
+// 参数:
+//   - conn: 到代理服务器的连接
+//   - host: 目标主机（IP或域名）
+//   - port: 目标端口
+//
+// 返回值:
+//   - error: 可能的错误
+func (d *socks5Dialer) handshake(conn net.Conn, host string, port int) error {
+	// 协商认证方式
+	method := byte(socks5AuthNone)
+	if d.username != "" {
+		method = socks5AuthPassword
+	}
+	if _, err := conn.Write([]byte{socks5Version, 1, method}); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("代理返回了不支持的协议版本: %d", reply[0])
+	}
+	if reply[1] == socks5AuthNoAcceptable || reply[1] != method {
+		return errors.New("代理不接受可用的认证方式")
+	}
+
+	// 用户名密码认证
+	if method == socks5AuthPassword {
+		if len(d.username) > 255 || len(d.password) > 255 {
+			return errors.New("用户名或密码过长")
+		}
+		auth := []byte{0x01, byte(len(d.username))}
+		auth = append(auth, d.username...)
+		auth = append(auth, byte(len(d.password)))
+		auth = append(auth, d.password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("用户名或密码认证失败")
+		}
+	}
+
+	// 发送CONNECT请求
+	request := []byte{socks5Version, socks5CmdConnect, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			request = append(request, socks5AddrIPv4)
+			request = append(request, ip4...)
+		} else {
+			request = append(request, socks5AddrIPv6)
+			request = append(request, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("目标域名过长")
+		}
+		request = append(request, socks5AddrDomain, byte(len(host)))
+		request = append(request, host...)
+	}
+	request = binary.BigEndian.AppendUint16(request, uint16(port))
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// 读取响应头: VER REP RSV ATYP
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("代理拒绝连接，错误码: %d", header[1])
+	}
+
+	// 丢弃绑定地址和端口
+	var addrLen int
+	switch header[3] {
+	case socks5AddrIPv4:
+		addrLen = net.IPv4len
+	case socks5AddrIPv6:
+		addrLen = net.IPv6len
+	case socks5AddrDomain:
+		lengthByte := make([]byte, 1)
+		if _, err := io.ReadFull(conn, lengthByte); err != nil {
+			return err
+		}
+		addrLen = int(lengthByte[0])
+	default:
+		return fmt.Errorf("代理返回了未知的地址类型: %d", header[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, addrLen+2)); err != nil {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"claude-mimic-gateway/config"
+	"claude-mimic-gateway/utils"
+)
+
+// startupCheckTimeout 启动检查单个上游的超时时间
+const startupCheckTimeout = 15 * time.Second
+
+// CheckUpstreams 启动时检查所有上游的连通性和密钥
+//
+// 向每个上游发送一次带认证的count_tokens请求，只有返回200才视为通过；
+// count_tokens不生成内容，不消耗输出配额
+//
+// 参数:
+//   - ctx: 上下文
+//
+// 返回值:
+//   - error: 任一上游检查失败时返回汇总的错误
+func (p *ProxyHandler) CheckUpstreams(ctx context.Context) error {
+	body, err := json.Marshal(map[string]interface{}{
+		"model": p.config.Gateway.StartupCheckModel,
+		"messages": []map[string]string{
+			{"role": "user", "content": "ping"},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for i, endpoint := range p.config.Upstream.Endpoints {
+		start := time.Now()
+		if err := p.checkUpstream(ctx, endpoint, body); err != nil {
+			utils.LogErrorLegacy(fmt.Sprintf("第 %d 个上游启动检查失败: %v", i+1, err))
+			errs = append(errs, fmt.Errorf("第%d个上游: %w", i+1, err))
+			continue
+		}
+		utils.LogSuccessLegacy(fmt.Sprintf("第 %d 个上游启动检查通过，耗时: %dms", i+1, time.Since(start).Milliseconds()))
+	}
+	return errors.Join(errs...)
+}
+
+// checkUpstream 向单个上游发送count_tokens请求
+//
+// 参数:
+//   - ctx: 上下文
+//   - endpoint: 上游配置
+//   - body: count_tokens请求体
+//
+// 返回值:
+//   - error: 请求失败或上游返回非200时的错误
+func (p *ProxyHandler) checkUpstream(ctx context.Context, endpoint config.UpstreamEndpoint, body []byte) error {
+	checkURL, err := resolveUpstreamURL(endpoint, "/v1/messages/count_tokens")
+	if err != nil {
+		return fmt.Errorf("构造上游地址失败: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, startupCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, checkURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	p.setClaudeCodeHeaders(req, http.Header{}, body, endpoint.Key)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求 %s 失败: %w", checkURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		responseBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s: %s", checkURL, upstreamErrorSummary(resp.StatusCode, responseBody))
+	}
+	return nil
+}
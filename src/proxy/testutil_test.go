@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"claude-mimic-gateway/config"
+)
+
+// testConfigYAML 测试用的最小配置，配置user_id避免生成.user_id文件，并关闭请求日志落盘
+const testConfigYAML = `upstream:
+  url: "https://upstream.example.com/v1/messages?beta=true"
+  key: "sk-ant-test"
+auth:
+  key: "gateway-test-key"
+server:
+  port: 8080
+gateway:
+  user_id: "user_test"
+logging:
+  save_requests: false
+`
+
+// loadTestConfig 从临时文件加载配置作为当前配置，extra追加在默认配置之后
+//
+// extra中不能再次出现默认配置已有的顶层配置项；测试可直接修改返回的实例，测试结束后清除配置实例
+func loadTestConfig(t *testing.T, extra string) *config.Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(testConfigYAML+extra), 0600); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+	cfg, err := config.LoadConfigFresh(path)
+	if err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+	t.Cleanup(config.ResetConfig)
+	return cfg
+}
+
+// newTestGateway 创建转发到upstream的代理处理器，configure用于在创建处理器前修改配置
+func newTestGateway(t *testing.T, upstream *httptest.Server, configure func(cfg *config.Config)) *ProxyHandler {
+	t.Helper()
+
+	cfg := loadTestConfig(t, "")
+	cfg.Upstream.URL = upstream.URL + "/v1/messages"
+	cfg.Upstream.Endpoints[0].URL = cfg.Upstream.URL
+	if configure != nil {
+		configure(cfg)
+	}
+	return NewProxyHandler(cfg)
+}
+
+// sendTestRequest 以下游客户端身份向代理处理器发送Messages请求
+func sendTestRequest(handler *ProxyHandler, body string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-api-key", "gateway-test-key")
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	recorder := httptest.NewRecorder()
+	handler.HandleRequest(recorder, req)
+	return recorder
+}
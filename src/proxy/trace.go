@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// traceContext W3C Trace Context中traceparent头的解析结果
+type traceContext struct {
+	Version string
+	TraceID string
+	SpanID  string
+	Flags   string
+}
+
+// parseTraceparent 解析请求中的traceparent头
+//
+// 参数:
+//   - r: HTTP请求对象
+//
+// 返回值:
+//   - *traceContext: 解析结果，头不存在或格式无效时为nil
+func parseTraceparent(r *http.Request) *traceContext {
+	value := strings.TrimSpace(r.Header.Get("traceparent"))
+	if value == "" {
+		return nil
+	}
+
+	// 格式: version-traceid-parentid-flags
+	parts := strings.Split(value, "-")
+	if len(parts) < 4 {
+		return nil
+	}
+
+	tc := &traceContext{
+		Version: strings.ToLower(parts[0]),
+		TraceID: strings.ToLower(parts[1]),
+		SpanID:  strings.ToLower(parts[2]),
+		Flags:   strings.ToLower(parts[3]),
+	}
+
+	if !isHexOfLength(tc.Version, 2) || tc.Version == "ff" ||
+		!isHexOfLength(tc.TraceID, 32) || isAllZero(tc.TraceID) ||
+		!isHexOfLength(tc.SpanID, 16) || isAllZero(tc.SpanID) ||
+		!isHexOfLength(tc.Flags, 2) {
+		return nil
+	}
+
+	// 版本00只允许4段
+	if tc.Version == "00" && len(parts) != 4 {
+		return nil
+	}
+
+	return tc
+}
+
+// childTraceparent 为上游请求生成新的span ID并返回traceparent头的值
+//
+// 返回值:
+//   - string: 新的traceparent头值
+func (tc *traceContext) childTraceparent() string {
+	spanID := make([]byte, 8)
+	if _, err := rand.Read(spanID); err != nil {
+		// 随机数生成失败时沿用下游的span ID
+		return "00-" + tc.TraceID + "-" + tc.SpanID + "-" + tc.Flags
+	}
+	return "00-" + tc.TraceID + "-" + hex.EncodeToString(spanID) + "-" + tc.Flags
+}
+
+// isHexOfLength 检查字符串是否为指定长度的小写十六进制
+func isHexOfLength(s string, length int) bool {
+	if len(s) != length {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// isAllZero 检查十六进制字符串是否全为0
+func isAllZero(s string) bool {
+	return strings.Trim(s, "0") == ""
+}
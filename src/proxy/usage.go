@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"claude-mimic-gateway/utils"
+)
+
+// usageFields 上游响应中的usage字段
+type usageFields struct {
+	InputTokens              *int `json:"input_tokens"`
+	OutputTokens             *int `json:"output_tokens"`
+	CacheCreationInputTokens *int `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     *int `json:"cache_read_input_tokens"`
+}
+
+// sseUsageEvent 只包含用量相关字段的SSE事件
+type sseUsageEvent struct {
+	Type    string       `json:"type"`
+	Usage   *usageFields `json:"usage"`
+	Message *struct {
+		Usage *usageFields `json:"usage"`
+	} `json:"message"`
+}
+
+// sseUsageParser 从流式响应中逐块解析token用量
+//
+// 输入tokens来自message_start事件，输出tokens来自message_delta事件（累计值），
+// 跨越读取缓冲区边界的行会暂存到下一块数据到达后再解析
+type sseUsageParser struct {
+	pending []byte
+	usage   utils.TokenUsage
+	found   bool
+}
+
+// Write 追加一块流式响应数据并解析其中完整的行
+//
+// 参数:
+//   - chunk: 从上游读取的数据块
+func (u *sseUsageParser) Write(chunk []byte) {
+	u.pending = append(u.pending, chunk...)
+
+	for {
+		index := bytes.IndexByte(u.pending, '\n')
+		if index < 0 {
+			break
+		}
+		u.parseLine(bytes.TrimRight(u.pending[:index], "\r"))
+		u.pending = u.pending[index+1:]
+	}
+
+	// 剩余的不完整行通常很短，复制出来避免持有已处理数据的底层数组
+	u.pending = append([]byte(nil), u.pending...)
+}
+
+// Usage 返回解析到的token用量
+//
+// 返回值:
+//   - *utils.TokenUsage: token用量，流中没有用量信息时为nil
+func (u *sseUsageParser) Usage() *utils.TokenUsage {
+	if !u.found {
+		return nil
+	}
+	usage := u.usage
+	return &usage
+}
+
+// parseLine 解析单行SSE数据，只处理包含用量的事件
+//
+// 参数:
+//   - line: 不含换行符的一行
+func (u *sseUsageParser) parseLine(line []byte) {
+	data, ok := bytes.CutPrefix(line, []byte("data:"))
+	if !ok {
+		return
+	}
+
+	var event sseUsageEvent
+	if err := json.Unmarshal(bytes.TrimSpace(data), &event); err != nil {
+		return
+	}
+
+	switch event.Type {
+	case "message_start":
+		if event.Message != nil && event.Message.Usage != nil {
+			mergeUsage(&u.usage, event.Message.Usage)
+			u.found = true
+		}
+	case "message_delta":
+		if event.Usage != nil {
+			mergeUsage(&u.usage, event.Usage)
+			u.found = true
+		}
+	}
+}
+
+// parseResponseUsage 从非流式响应体中解析token用量
+//
+// 参数:
+//   - body: 上游响应体
+//
+// 返回值:
+//   - *utils.TokenUsage: token用量，响应中没有用量信息时为nil
+func parseResponseUsage(body []byte) *utils.TokenUsage {
+	var response struct {
+		Usage *usageFields `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &response); err != nil || response.Usage == nil {
+		return nil
+	}
+
+	usage := &utils.TokenUsage{}
+	mergeUsage(usage, response.Usage)
+	return usage
+}
+
+// mergeUsage 将事件中出现的用量字段写入汇总结果，未出现的字段保持不变
+//
+// 参数:
+//   - dst: 汇总结果
+//   - src: 事件中的usage字段
+func mergeUsage(dst *utils.TokenUsage, src *usageFields) {
+	if src.InputTokens != nil {
+		dst.InputTokens = *src.InputTokens
+	}
+	if src.OutputTokens != nil {
+		dst.OutputTokens = *src.OutputTokens
+	}
+	if src.CacheCreationInputTokens != nil {
+		dst.CacheCreationInputTokens = *src.CacheCreationInputTokens
+	}
+	if src.CacheReadInputTokens != nil {
+		dst.CacheReadInputTokens = *src.CacheReadInputTokens
+	}
+}
@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// clfTimeLayout Common Log Format中的时间格式
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogEntry 一次HTTP请求的访问日志信息
+type AccessLogEntry struct {
+	Request  *http.Request
+	Status   int
+	Bytes    int64
+	Start    time.Time
+	Duration time.Duration
+}
+
+// accessLogFile 访问日志文件，路径变化时重新打开
+var accessLogFile struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+}
+
+// WriteAccessLog 以NCSA Common/Combined Log Format追加一行访问日志
+//
+// 行尾额外附加请求耗时（毫秒），常见的日志解析器会忽略多余字段
+//
+// 参数:
+//   - path: 访问日志文件路径
+//   - format: common或combined，combined额外记录Referer和User-Agent
+//   - entry: 访问日志信息
+func WriteAccessLog(path, format string, entry AccessLogEntry) {
+	line := formatAccessLog(format, entry)
+
+	accessLogFile.mu.Lock()
+	defer accessLogFile.mu.Unlock()
+
+	if accessLogFile.file == nil || accessLogFile.path != path {
+		if accessLogFile.file != nil {
+			accessLogFile.file.Close()
+			accessLogFile.file = nil
+		}
+		if dir := filepath.Dir(path); dir != "." {
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				LogErrorLegacy("创建访问日志目录失败: " + err.Error())
+				return
+			}
+		}
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			LogErrorLegacy("打开访问日志文件失败: " + err.Error())
+			return
+		}
+		accessLogFile.file, accessLogFile.path = file, path
+	}
+
+	if _, err := accessLogFile.file.WriteString(line); err != nil {
+		LogErrorLegacy("写入访问日志失败: " + err.Error())
+	}
+}
+
+// formatAccessLog 生成一行访问日志
+//
+// 参数:
+//   - format: common或combined
+//   - entry: 访问日志信息
+//
+// 返回值:
+//   - string: 以换行符结尾的日志行
+func formatAccessLog(format string, entry AccessLogEntry) string {
+	r := entry.Request
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if host == "" {
+		host = "-"
+	}
+
+	bytes := "-"
+	if entry.Bytes > 0 {
+		bytes = strconv.FormatInt(entry.Bytes, 10)
+	}
+
+	line := fmt.Sprintf("%s - - [%s] %q %d %s",
+		host, entry.Start.Format(clfTimeLayout), r.Method+" "+r.URL.RequestURI()+" "+r.Proto, entry.Status, bytes)
+	if format == "combined" {
+		line += fmt.Sprintf(" %q %q", clfField(r.Referer()), clfField(r.UserAgent()))
+	}
+	return line + fmt.Sprintf(" %d\n", entry.Duration.Milliseconds())
+}
+
+// clfField 将空字段替换为"-"
+//
+// 参数:
+//   - value: 字段值
+//
+// 返回值:
+//   - string: 字段值，为空时返回"-"
+func clfField(value string) string {
+	if value == "" {
+		return "-"
+	}
+	return value
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"claude-mimic-gateway/config"
+)
+
+// asyncLogWriter 在后台协程中序列化并写入请求日志
+type asyncLogWriter struct {
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *RequestLogData
+	done   chan struct{}
+}
+
+// asyncLogs 当前生效的异步日志写入器，未开启异步日志时为nil
+var asyncLogs atomic.Pointer[asyncLogWriter]
+
+// StartAsyncLogging 按配置启动异步请求日志写入
+//
+// 开启后SaveRequestLog只把日志放入有界队列，由后台协程完成序列化和写文件，
+// 队列已满时丢弃日志并输出警告
+//
+// 参数:
+//   - cfg: 配置实例
+//
+// 返回值:
+//   - func(): 停止异步写入的函数，会等待队列中剩余的日志写完
+func StartAsyncLogging(cfg *config.Config) func() {
+	if !cfg.Logging.Async {
+		return func() {}
+	}
+
+	writer := &asyncLogWriter{
+		queue: make(chan *RequestLogData, cfg.Logging.AsyncBuffer),
+		done:  make(chan struct{}),
+	}
+	go writer.run()
+	asyncLogs.Store(writer)
+
+	LogDebugLegacy(fmt.Sprintf("异步请求日志已启动，队列长度: %d", cfg.Logging.AsyncBuffer))
+	return func() {
+		asyncLogs.CompareAndSwap(writer, nil)
+		writer.close()
+	}
+}
+
+// run 持续写入队列中的日志，队列关闭后退出
+func (a *asyncLogWriter) run() {
+	defer close(a.done)
+	for logData := range a.queue {
+		saveRequestLog(logData)
+	}
+}
+
+// enqueue 将日志放入队列
+//
+// 参数:
+//   - logData: 请求日志数据
+//
+// 返回值:
+//   - bool: 写入器已关闭时返回false，调用方应同步写入
+func (a *asyncLogWriter) enqueue(logData *RequestLogData) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if a.closed {
+		return false
+	}
+
+	select {
+	case a.queue <- logData:
+	default:
+		LogWarn(logData.TaskID, "异步日志队列已满，已丢弃本次请求日志")
+		removeInProgressLog(logData.TaskID)
+	}
+	return true
+}
+
+// close 关闭队列并等待剩余日志写完
+func (a *asyncLogWriter) close() {
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.queue)
+	}
+	a.mu.Unlock()
+
+	<-a.done
+	LogDebugLegacy("异步请求日志已停止，剩余日志已写入")
+}
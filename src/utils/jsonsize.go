@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"encoding/json"
+	"math"
+	"strconv"
+	"unicode/utf8"
+)
+
+// jsonEncodedSize 计算值经json.Marshal序列化后的字节数，不生成序列化结果
+//
+// 按encoding/json的规则计算字符串转义和数字格式，累计值达到limit后停止遍历，
+// 此时返回值不小于limit但不保证准确；无法直接计算的类型回退为单独序列化该值
+//
+// 参数:
+//   - value: 待计算的值
+//   - limit: 提前停止的长度
+//
+// 返回值:
+//   - int: 序列化后的字节数，提前停止时为不小于limit的部分结果
+//   - error: 回退序列化失败时的错误
+func jsonEncodedSize(value interface{}, limit int) (int, error) {
+	switch v := value.(type) {
+	case nil:
+		return 4, nil
+	case bool:
+		if v {
+			return 4, nil
+		}
+		return 5, nil
+	case string:
+		return jsonStringSize(v), nil
+	case float64:
+		return jsonFloatSize(v), nil
+	case int:
+		return len(strconv.Itoa(v)), nil
+	case int64:
+		return len(strconv.FormatInt(v, 10)), nil
+	case []interface{}:
+		size := 2
+		for i, item := range v {
+			if i > 0 {
+				size++
+			}
+			itemSize, err := jsonEncodedSize(item, limit-size)
+			if err != nil {
+				return 0, err
+			}
+			size += itemSize
+			if size >= limit {
+				return size, nil
+			}
+		}
+		return size, nil
+	case map[string]interface{}:
+		size := 2
+		first := true
+		for key, item := range v {
+			if !first {
+				size++
+			}
+			first = false
+			size += jsonStringSize(key) + 1
+			itemSize, err := jsonEncodedSize(item, limit-size)
+			if err != nil {
+				return 0, err
+			}
+			size += itemSize
+			if size >= limit {
+				return size, nil
+			}
+		}
+		return size, nil
+	default:
+		// 配置注入的默认值等少见类型体积很小，直接序列化
+		data, err := json.Marshal(v)
+		if err != nil {
+			return 0, err
+		}
+		return len(data), nil
+	}
+}
+
+// invalidUTF8Size 非法UTF-8字节序列化后的字节数
+//
+// 不同Go版本的encoding/json输出转义形式\ufffd（6字节）或原样的U+FFFD（3字节），按当前版本的实际输出计算
+var invalidUTF8Size = func() int {
+	data, err := json.Marshal("\xff")
+	if err != nil {
+		return 6
+	}
+	return len(data) - 2
+}()
+
+// jsonStringSize 计算字符串序列化为JSON后的字节数（含引号）
+//
+// 与encoding/json一致：HTML特殊字符、控制字符和U+2028/U+2029会被转义，非法UTF-8替换为U+FFFD
+//
+// 参数:
+//   - s: 字符串
+//
+// 返回值:
+//   - int: 字节数
+func jsonStringSize(s string) int {
+	size := 2
+	for i := 0; i < len(s); {
+		if b := s[i]; b < utf8.RuneSelf {
+			switch {
+			case b == '"' || b == '\\' || b == '\b' || b == '\f' || b == '\n' || b == '\r' || b == '\t':
+				size += 2
+			case b < 0x20 || b == '<' || b == '>' || b == '&':
+				size += 6
+			default:
+				size++
+			}
+			i++
+			continue
+		}
+
+		r, width := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && width == 1 {
+			size += invalidUTF8Size
+		} else if r == '\u2028' || r == '\u2029' {
+			size += 6
+		} else {
+			size += width
+		}
+		i += width
+	}
+	return size
+}
+
+// jsonFloatSize 计算float64序列化为JSON后的字节数
+//
+// 与encoding/json一致：绝对值过大或过小时使用指数形式，并去掉指数中多余的0
+//
+// 参数:
+//   - f: 数值
+//
+// 返回值:
+//   - int: 字节数
+func jsonFloatSize(f float64) int {
+	format := byte('f')
+	if abs := math.Abs(f); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+
+	var buf [32]byte
+	b := strconv.AppendFloat(buf[:0], f, format, -1, 64)
+	if format == 'e' {
+		// 1e-07 序列化为 1e-7
+		if n := len(b); n >= 4 && b[n-4] == 'e' && b[n-3] == '-' && b[n-2] == '0' {
+			return n - 1
+		}
+	}
+	return len(b)
+}
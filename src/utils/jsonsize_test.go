@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+func TestJSONEncodedSizeMatchesMarshal(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+	}{
+		{name: "null", value: nil},
+		{name: "true", value: true},
+		{name: "false", value: false},
+		{name: "空字符串", value: ""},
+		{name: "ASCII字符串", value: "hello world"},
+		{name: "中文字符串", value: "你好，世界"},
+		{name: "HTML字符", value: "<script>a && b</script>"},
+		{name: "引号和反斜杠", value: `say "hi" \ bye`},
+		{name: "常用控制字符", value: "line1\nline2\ttab\rret"},
+		{name: "其他控制字符", value: "\x00\x01\x1f\x7f\b\f"},
+		{name: "无效UTF-8", value: "bad\xff\xfebytes\xc3"},
+		{name: "行分隔符和段分隔符", value: "a b c"},
+		{name: "emoji", value: "smile 😀"},
+		{name: "整数浮点数", value: float64(42)},
+		{name: "负零", value: math.Copysign(0, -1)},
+		{name: "小数", value: 0.1},
+		{name: "1e-7", value: 1e-7},
+		{name: "略大于1e-6", value: 0.000001},
+		{name: "1e20", value: 1e20},
+		{name: "1e21", value: 1e21},
+		{name: "极大值", value: math.MaxFloat64},
+		{name: "极小值", value: math.SmallestNonzeroFloat64},
+		{name: "负的极小值", value: -1.5e-300},
+		{name: "int", value: -12345},
+		{name: "int64", value: int64(math.MinInt64)},
+		{name: "数组", value: []interface{}{1.5, "a<b", nil, true}},
+		{name: "空数组", value: []interface{}{}},
+		{name: "空对象", value: map[string]interface{}{}},
+		{name: "嵌套结构", value: map[string]interface{}{
+			"model": "claude",
+			"键<&>": []interface{}{
+				map[string]interface{}{"text": "x y", "n": 1e-7},
+				[]interface{}{[]interface{}{}, map[string]interface{}{"bad\xff": "\x01"}},
+			},
+			"stream": false,
+		}},
+		{name: "回退序列化的类型", value: []string{"a", "<b>"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.value)
+			if err != nil {
+				t.Fatalf("json.Marshal失败: %v", err)
+			}
+			size, err := jsonEncodedSize(tt.value, math.MaxInt32)
+			if err != nil {
+				t.Fatalf("jsonEncodedSize失败: %v", err)
+			}
+			if size != len(data) {
+				t.Fatalf("计算结果为%d，json.Marshal为%d: %s", size, len(data), data)
+			}
+		})
+	}
+}
+
+func TestJSONEncodedSizeStopsAtLimit(t *testing.T) {
+	items := make([]interface{}, 1000)
+	for i := range items {
+		items[i] = "0123456789"
+	}
+	value := map[string]interface{}{"messages": items}
+
+	size, err := jsonEncodedSize(value, 100)
+	if err != nil {
+		t.Fatalf("jsonEncodedSize失败: %v", err)
+	}
+	if size < 100 {
+		t.Fatalf("达到限制后返回%d，期望不小于100", size)
+	}
+	data, _ := json.Marshal(value)
+	if size >= len(data) {
+		t.Fatalf("达到限制后应提前停止，返回%d，完整长度%d", size, len(data))
+	}
+}
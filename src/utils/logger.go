@@ -4,9 +4,16 @@ import (
 	"encoding/json"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"claude-mimic-gateway/config"
 
 	"github.com/sirupsen/logrus"
 )
@@ -46,39 +53,14 @@ type CustomFormatter struct{}
 //   - []byte: 格式化后的字节数组
 //   - error: 可能的错误
 func (f *CustomFormatter) Format(entry *logrus.Entry) ([]byte, error) {
-	var color string
-	var levelText string
-
-	// 检查是否为SUCCESS级别
-	if successLevel, ok := entry.Data["level"]; ok && successLevel == "SUCCESS" {
-		color = Green
-		levelText = "SUCCESS"
-	} else {
-		switch entry.Level {
-		case logrus.InfoLevel:
-			color = Blue
-			levelText = "INFO"
-		case logrus.DebugLevel:
-			color = White
-			levelText = "DEBUG"
-		case logrus.ErrorLevel:
-			color = Red
-			levelText = "ERROR"
-		case logrus.WarnLevel:
-			color = Yellow
-			levelText = "WARN"
-		default:
-			color = White
-			levelText = "UNKNOWN"
-		}
-	}
+	color, levelText := entryLevel(entry)
 
 	// 获取任务ID
-	taskID := "0000"
-	if taskIDValue, ok := entry.Data["taskID"]; ok {
-		if taskIDStr, ok := taskIDValue.(string); ok {
-			taskID = taskIDStr
-		}
+	taskID := entryTaskID(entry)
+
+	// 附加分布式追踪ID，便于跨服务关联
+	if traceID, ok := taskTraceIDs.Load(taskID); ok {
+		taskID += "][trace:" + traceID.(string)
 	}
 
 	// 计算缩进空格，让所有级别对齐（最长为7个字符"SUCCESS"）
@@ -94,6 +76,120 @@ func (f *CustomFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 	return formatted, nil
 }
 
+// JSONFormatter JSON日志格式器，每行输出一个JSON对象，便于日志系统采集
+type JSONFormatter struct{}
+
+// jsonLogEntry JSON格式的日志条目
+type jsonLogEntry struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	TaskID  string `json:"task_id"`
+	TraceID string `json:"trace_id,omitempty"`
+	Message string `json:"message"`
+}
+
+// Format 将日志条目格式化为单行JSON
+//
+// 参数:
+//   - entry: 要格式化的日志条目
+//
+// 返回值:
+//   - []byte: 格式化后的字节数组
+//   - error: 可能的错误
+func (f *JSONFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	_, levelText := entryLevel(entry)
+	taskID := entryTaskID(entry)
+
+	logEntry := jsonLogEntry{
+		Time:    entry.Time.Format(time.RFC3339),
+		Level:   levelText,
+		TaskID:  taskID,
+		Message: entry.Message,
+	}
+	if traceID, ok := taskTraceIDs.Load(taskID); ok {
+		logEntry.TraceID = traceID.(string)
+	}
+
+	formatted, err := json.Marshal(&logEntry)
+	if err != nil {
+		return nil, err
+	}
+	return append(formatted, '\n'), nil
+}
+
+// entryLevel 获取日志条目对应的颜色和级别文本
+//
+// 参数:
+//   - entry: 日志条目
+//
+// 返回值:
+//   - string: ANSI颜色代码
+//   - string: 级别文本
+func entryLevel(entry *logrus.Entry) (string, string) {
+	// 检查是否为SUCCESS级别
+	if successLevel, ok := entry.Data["level"]; ok && successLevel == "SUCCESS" {
+		return Green, "SUCCESS"
+	}
+
+	switch entry.Level {
+	case logrus.InfoLevel:
+		return Blue, "INFO"
+	case logrus.DebugLevel:
+		return White, "DEBUG"
+	case logrus.ErrorLevel:
+		return Red, "ERROR"
+	case logrus.WarnLevel:
+		return Yellow, "WARN"
+	default:
+		return White, "UNKNOWN"
+	}
+}
+
+// entryTaskID 获取日志条目的任务ID，未设置时为systemTaskID
+//
+// 参数:
+//   - entry: 日志条目
+//
+// 返回值:
+//   - string: 任务ID
+func entryTaskID(entry *logrus.Entry) string {
+	if taskIDValue, ok := entry.Data["taskID"]; ok {
+		if taskIDStr, ok := taskIDValue.(string); ok {
+			return taskIDStr
+		}
+	}
+	return systemTaskID
+}
+
+// ConfigureLogger 根据配置重新设置日志器，需在配置加载后调用
+//
+// 参数:
+//   - cfg: 配置实例
+func ConfigureLogger(cfg *config.Config) {
+	if cfg.Logging.Format == "json" {
+		Logger.SetFormatter(&JSONFormatter{})
+	} else {
+		Logger.SetFormatter(&CustomFormatter{})
+	}
+
+	// 配置校验已保证级别合法，解析失败时保持init()中的默认级别
+	if level, err := logrus.ParseLevel(cfg.Logging.Level); err == nil {
+		Logger.SetLevel(level)
+	}
+
+	// 重新构建脱敏列表，重新加载配置时移除的请求头不再脱敏
+	headers := map[string]bool{}
+	for key := range defaultRedactedHeaders {
+		headers[key] = true
+	}
+	for _, key := range cfg.Logging.RedactHeaders {
+		headers[http.CanonicalHeaderKey(key)] = true
+	}
+	redactedHeadersMu.Lock()
+	redactedHeaders = headers
+	redactedHeadersMu.Unlock()
+}
+
 // RequestLogData 请求日志数据结构
 type RequestLogData struct {
 	TaskID              string                 `json:"task_id"`
@@ -103,6 +199,25 @@ type RequestLogData struct {
 	UpstreamResponse    *ResponseDetails       `json:"upstream_response"`
 	Error               string                 `json:"error,omitempty"`
 	Success             bool                   `json:"success"`
+	TraceID             string                 `json:"trace_id,omitempty"`
+	RequestID           string                 `json:"request_id,omitempty"`
+	Model               string                 `json:"model,omitempty"`
+	InProgress          bool                   `json:"in_progress,omitempty"`
+	Usage               *TokenUsage            `json:"usage,omitempty"`
+
+	UpstreamLatencyMS   int64                  `json:"upstream_latency_ms,omitempty"`    // 发起上游请求到收到响应头的耗时（含重试）
+	UpstreamFirstByteMS int64                  `json:"upstream_first_byte_ms,omitempty"` // 流式响应中发起上游请求到收到第一块数据的耗时
+	UpstreamStart       time.Time              `json:"-"`                                // 发起上游请求的时间
+	Upstream            string                 `json:"upstream,omitempty"`               // 最终返回响应的上游
+	Failover            bool                   `json:"failover,omitempty"`               // 是否切换到了备用上游
+}
+
+// TokenUsage 上游响应中的token用量
+type TokenUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
 }
 
 // RequestDetails 请求详细信息
@@ -122,6 +237,49 @@ type ResponseDetails struct {
 	Body       string            `json:"body"`
 }
 
+// redactedValue 脱敏后写入日志的请求头值
+const redactedValue = "***"
+
+// defaultRedactedHeaders 始终脱敏的请求头（规范化键名）
+var defaultRedactedHeaders = map[string]bool{
+	"Authorization": true,
+	"X-Api-Key":     true,
+}
+
+// redactedHeaders 当前需要在请求日志中脱敏的请求头，由ConfigureLogger整体替换
+var (
+	redactedHeaders   = defaultRedactedHeaders
+	redactedHeadersMu sync.RWMutex
+)
+
+// FlattenHeaders 将HTTP头转换为日志记录用的字符串映射
+//
+// 多值头使用", "连接；Set-Cookie的值本身可能包含逗号，改用换行连接以免混淆。
+// 认证相关的请求头会被替换为"***"，避免密钥落盘
+//
+// 参数:
+//   - header: HTTP头
+//   - dst: 写入的目标映射
+func FlattenHeaders(header http.Header, dst map[string]string) {
+	redactedHeadersMu.RLock()
+	redacted := redactedHeaders
+	redactedHeadersMu.RUnlock()
+
+	for key, values := range header {
+		canonicalKey := http.CanonicalHeaderKey(key)
+		if redacted[canonicalKey] {
+			dst[key] = redactedValue
+			continue
+		}
+
+		separator := ", "
+		if canonicalKey == "Set-Cookie" {
+			separator = "\n"
+		}
+		dst[key] = strings.Join(values, separator)
+	}
+}
+
 // init 初始化日志器，设置默认配置
 func init() {
 	Logger = logrus.New()
@@ -129,16 +287,13 @@ func init() {
 	Logger.SetLevel(logrus.DebugLevel)
 	Logger.SetFormatter(&CustomFormatter{})
 
-	// 初始化随机种子
-	rand.Seed(time.Now().UnixNano())
-
 	// 确保日志目录存在
 	ensureLogDirectories()
 }
 
 // ensureLogDirectories 确保日志目录存在
 func ensureLogDirectories() {
-	dirs := []string{"logs", "errors"}
+	dirs := []string{"logs", "errors", inProgressLogDir}
 	for _, dir := range dirs {
 		if err := os.MkdirAll(dir, 0755); err != nil {
 			fmt.Printf("创建日志目录失败: %s, 错误: %v\n", dir, err)
@@ -146,15 +301,109 @@ func ensureLogDirectories() {
 	}
 }
 
+// inProgressLogDir 进行中请求的临时日志目录
+var inProgressLogDir = filepath.Join("logs", "inprogress")
+
+// inProgressLogPath 获取任务对应的进行中日志文件路径
+//
+// 参数:
+//   - taskID: 任务ID
+//
+// 返回值:
+//   - string: 进行中日志文件路径
+func inProgressLogPath(taskID string) string {
+	return filepath.Join(inProgressLogDir, taskID+".log")
+}
+
+// SaveInProgressLog 写入或更新进行中请求的临时日志
+//
+// 仅在配置开启logging.in_progress时生效，请求完成后由SaveRequestLog清理
+//
+// 参数:
+//   - logData: 请求日志数据
+func SaveInProgressLog(logData *RequestLogData) {
+	cfg := config.GetConfig()
+	if cfg == nil || !cfg.Logging.InProgress || !saveRequestsEnabled(cfg) {
+		return
+	}
+
+	logData, ok := applyLogVerbosity(logData)
+	if !ok {
+		return
+	}
+	logData = applyLogBodyLimit(logData)
+
+	inProgressData := *logData
+	inProgressData.InProgress = true
+	jsonData, err := json.MarshalIndent(&inProgressData, "", "  ")
+	if err != nil {
+		LogError(logData.TaskID, "序列化进行中日志失败: " + err.Error())
+		return
+	}
+
+	filePath := inProgressLogPath(logData.TaskID)
+	if err := os.WriteFile(filePath, jsonData, 0644); err != nil {
+		LogError(logData.TaskID, "写入进行中日志失败: " + err.Error())
+		return
+	}
+
+	LogDebug(logData.TaskID, "已更新进行中日志: " + filePath)
+}
+
 // SaveRequestLog 保存详细的请求日志到文件
 //
+// 开启异步日志时只放入队列，由后台协程写入，调用方此后不应再修改logData
+//
 // 参数:
 //   - logData: 请求日志数据
 func SaveRequestLog(logData *RequestLogData) {
-	// 使用UTC时间加8小时（东八区时间）作为文件名
+	if writer := asyncLogs.Load(); writer != nil {
+		// 复制一份，避免调用方后续修改与后台写入并发
+		logCopy := *logData
+		if writer.enqueue(&logCopy) {
+			return
+		}
+	}
+	saveRequestLog(logData)
+}
+
+// saveRequestLog 序列化请求日志并写入文件
+//
+// 参数:
+//   - logData: 请求日志数据
+func saveRequestLog(logData *RequestLogData) {
+	// 请求已结束，清理进行中日志
+	defer removeInProgressLog(logData.TaskID)
+
+	// 按配置跳过不需要保存的请求日志
+	cfg := config.GetConfig()
+	if cfg != nil {
+		if !saveRequestsEnabled(cfg) || (cfg.Logging.SaveOnlyFailures && logData.Success) {
+			return
+		}
+	}
+
+	// 按模型的日志详细程度处理
+	logData, ok := applyLogVerbosity(logData)
+	if !ok {
+		return
+	}
+	logData = applyLogBodyLimit(logData)
+
+	// 配置了JSONL文件时追加一行，不再写入单独文件
+	if cfg != nil && cfg.Logging.JSONLPath != "" {
+		if err := appendJSONLLog(cfg.Logging.JSONLPath, logData); err != nil {
+			LogErrorLegacy("写入JSONL日志失败: " + err.Error())
+			return
+		}
+		LogDebugLegacy("已追加请求日志到: " + cfg.Logging.JSONLPath)
+		return
+	}
+
+	// 使用UTC时间加8小时（东八区时间）、纳秒和任务ID作为文件名，避免并发请求互相覆盖
 	chinaTime := time.Now().UTC().Add(8 * time.Hour)
 	timestamp := chinaTime.Format("20060102150405")
-	filename := fmt.Sprintf("%s.log", timestamp)
+	filename := fmt.Sprintf("%s_%09d_%s", timestamp, chinaTime.Nanosecond(), logData.TaskID)
 
 	// 选择存储目录
 	dir := "logs"
@@ -162,8 +411,6 @@ func SaveRequestLog(logData *RequestLogData) {
 		dir = "errors"
 	}
 
-	filePath := filepath.Join(dir, filename)
-
 	// 转换为JSON格式
 	jsonData, err := json.MarshalIndent(logData, "", "  ")
 	if err != nil {
@@ -172,7 +419,8 @@ func SaveRequestLog(logData *RequestLogData) {
 	}
 
 	// 写入文件
-	if err := os.WriteFile(filePath, jsonData, 0644); err != nil {
+	filePath, err := writeUniqueLogFile(dir, filename, jsonData)
+	if err != nil {
 		LogErrorLegacy("写入日志文件失败: " + err.Error())
 		return
 	}
@@ -180,12 +428,251 @@ func SaveRequestLog(logData *RequestLogData) {
 	LogDebugLegacy("已保存请求日志到: " + filePath)
 }
 
-// GenerateTaskID 生成随机4位数任务ID
+// jsonlLogMu 保护JSONL日志文件的追加写入，保证并发请求的日志行不会交错
+var jsonlLogMu sync.Mutex
+
+// appendJSONLLog 将请求日志序列化为一行JSON追加到文件
+//
+// 每次写入时重新打开文件，配置重新加载修改路径或外部轮转文件后无需额外处理
+//
+// 参数:
+//   - path: JSONL文件路径
+//   - logData: 请求日志数据
 //
 // 返回值:
-//   - string: 4位数字符串格式的任务ID
+//   - error: 可能的错误
+func appendJSONLLog(path string, logData *RequestLogData) error {
+	line, err := json.Marshal(logData)
+	if err != nil {
+		return fmt.Errorf("序列化日志数据失败: %w", err)
+	}
+	line = append(line, '\n')
+
+	jsonlLogMu.Lock()
+	defer jsonlLogMu.Unlock()
+
+	if dir := filepath.Dir(path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// saveRequestsEnabled 判断是否需要将请求日志写入磁盘
+//
+// 参数:
+//   - cfg: 当前配置
+//
+// 返回值:
+//   - bool: 未配置logging.save_requests时默认开启
+func saveRequestsEnabled(cfg *config.Config) bool {
+	return cfg.Logging.SaveRequests == nil || *cfg.Logging.SaveRequests
+}
+
+// writeUniqueLogFile 以独占方式创建日志文件，文件已存在时追加序号重试
+//
+// 参数:
+//   - dir: 日志目录
+//   - baseName: 不含扩展名的文件名
+//   - data: 文件内容
+//
+// 返回值:
+//   - string: 实际写入的文件路径
+//   - error: 可能的错误
+func writeUniqueLogFile(dir, baseName string, data []byte) (string, error) {
+	for i := 0; ; i++ {
+		name := baseName + ".log"
+		if i > 0 {
+			name = fmt.Sprintf("%s_%d.log", baseName, i)
+		}
+		filePath := filepath.Join(dir, name)
+
+		file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if os.IsExist(err) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+
+		_, err = file.Write(data)
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
+		}
+		return filePath, err
+	}
+}
+
+// applyLogVerbosity 根据请求模型的日志详细程度处理日志数据
+//
+// 参数:
+//   - logData: 请求日志数据
+//
+// 返回值:
+//   - *RequestLogData: 需要写入的日志数据，metadata模式下为去掉请求体和响应体的副本
+//   - bool: 是否需要写入日志
+func applyLogVerbosity(logData *RequestLogData) (*RequestLogData, bool) {
+	cfg := config.GetConfig()
+	if cfg == nil {
+		return logData, true
+	}
+
+	verbosity := cfg.Logging.DefaultVerbosity
+	if modelVerbosity, ok := cfg.Logging.ModelVerbosity[logData.Model]; ok {
+		verbosity = modelVerbosity
+	}
+
+	switch verbosity {
+	case "none":
+		return nil, false
+	case "metadata":
+		return stripLogBodies(logData), true
+	default:
+		return logData, true
+	}
+}
+
+// logBodyTruncatedMarker 请求日志中被截断的请求体/响应体末尾追加的标记
+const logBodyTruncatedMarker = "...[truncated]"
+
+// applyLogBodyLimit 按logging.max_body_log_bytes截断日志中的请求体和响应体
+//
+// 参数:
+//   - logData: 请求日志数据
+//
+// 返回值:
+//   - *RequestLogData: 需要写入的日志数据，有内容被截断时为副本
+func applyLogBodyLimit(logData *RequestLogData) *RequestLogData {
+	cfg := config.GetConfig()
+	if cfg == nil || cfg.Logging.MaxBodyLogBytes <= 0 {
+		return logData
+	}
+	limit := cfg.Logging.MaxBodyLogBytes
+
+	limited := *logData
+	if logData.DownstreamRequest != nil {
+		request := *logData.DownstreamRequest
+		request.Body = truncateLogBody(request.Body, limit)
+		limited.DownstreamRequest = &request
+	}
+	if logData.UpstreamRequest != nil {
+		request := *logData.UpstreamRequest
+		request.Body = truncateLogBody(request.Body, limit)
+		request.OriginalBody = truncateLogBody(request.OriginalBody, limit)
+		request.TransformedBody = truncateLogBody(request.TransformedBody, limit)
+		limited.UpstreamRequest = &request
+	}
+	if logData.UpstreamResponse != nil {
+		response := *logData.UpstreamResponse
+		response.Body = truncateLogBody(response.Body, limit)
+		limited.UpstreamResponse = &response
+	}
+	return &limited
+}
+
+// truncateLogBody 将内容截断到指定字节数以内，不拆分UTF-8字符
+//
+// 参数:
+//   - body: 原始内容
+//   - limit: 最大字节数
+//
+// 返回值:
+//   - string: 截断后追加标记的内容，未超出时原样返回
+func truncateLogBody(body string, limit int) string {
+	if len(body) <= limit {
+		return body
+	}
+	for limit > 0 && !utf8.RuneStart(body[limit]) {
+		limit--
+	}
+	return body[:limit] + logBodyTruncatedMarker
+}
+
+// stripLogBodies 复制日志数据并去掉其中的请求体和响应体
+//
+// 参数:
+//   - logData: 请求日志数据
+//
+// 返回值:
+//   - *RequestLogData: 仅包含元数据的日志副本
+func stripLogBodies(logData *RequestLogData) *RequestLogData {
+	stripped := *logData
+	if logData.DownstreamRequest != nil {
+		request := *logData.DownstreamRequest
+		request.Body = ""
+		stripped.DownstreamRequest = &request
+	}
+	if logData.UpstreamRequest != nil {
+		request := *logData.UpstreamRequest
+		request.Body, request.OriginalBody, request.TransformedBody = "", "", ""
+		stripped.UpstreamRequest = &request
+	}
+	if logData.UpstreamResponse != nil {
+		response := *logData.UpstreamResponse
+		response.Body = ""
+		stripped.UpstreamResponse = &response
+	}
+	return &stripped
+}
+
+// removeInProgressLog 删除任务对应的进行中日志
+//
+// 参数:
+//   - taskID: 任务ID
+func removeInProgressLog(taskID string) {
+	cfg := config.GetConfig()
+	if cfg == nil || !cfg.Logging.InProgress {
+		return
+	}
+
+	if err := os.Remove(inProgressLogPath(taskID)); err != nil && !os.IsNotExist(err) {
+		LogError(taskID, "删除进行中日志失败: " + err.Error())
+	}
+}
+
+// taskTraceIDs 任务ID到分布式追踪ID的映射，用于控制台日志关联
+var taskTraceIDs sync.Map
+
+// BindTraceID 将分布式追踪ID关联到任务ID，之后该任务的控制台日志会带上追踪ID
+//
+// 参数:
+//   - taskID: 任务ID
+//   - traceID: W3C Trace Context中的trace ID
+func BindTraceID(taskID, traceID string) {
+	taskTraceIDs.Store(taskID, traceID)
+}
+
+// UnbindTraceID 解除任务ID与分布式追踪ID的关联
+//
+// 参数:
+//   - taskID: 任务ID
+func UnbindTraceID(taskID string) {
+	taskTraceIDs.Delete(taskID)
+}
+
+// systemTaskID 与具体请求无关的日志使用的任务ID，与请求任务ID等宽以保持日志对齐
+const systemTaskID = "00000000"
+
+// taskIDCounter 任务ID计数器，启动时取随机初值，避免重启后与之前日志中的任务ID重复
+var taskIDCounter = rand.Uint32()
+
+// GenerateTaskID 生成8位十六进制任务ID
+//
+// 使用递增计数器保证同一进程内约42亿个请求不会重复，并发安全
+//
+// 返回值:
+//   - string: 8位十六进制字符串格式的任务ID
 func GenerateTaskID() string {
-	return fmt.Sprintf("%04d", rand.Intn(10000))
+	return fmt.Sprintf("%08x", atomic.AddUint32(&taskIDCounter, 1))
 }
 
 // LogInfo 记录INFO级别日志消息
@@ -215,6 +702,15 @@ func LogError(taskID, message string) {
 	Logger.WithField("taskID", taskID).Error(message)
 }
 
+// LogWarn 记录WARN级别日志消息，用于不影响请求继续处理的异常
+//
+// 参数:
+//   - taskID: 任务ID
+//   - message: 要记录的日志消息
+func LogWarn(taskID, message string) {
+	Logger.WithField("taskID", taskID).Warn(message)
+}
+
 // LogSuccess 记录SUCCESS级别日志消息，使用绿色格式
 //
 // 参数:
@@ -231,7 +727,7 @@ func LogSuccess(taskID, message string) {
 // 参数:
 //   - message: 要记录的日志消息
 func LogInfoLegacy(message string) {
-	LogInfo("0000", message)
+	LogInfo(systemTaskID, message)
 }
 
 // LogDebugLegacy 记录DEBUG级别日志消息（兼容旧版本）
@@ -239,7 +735,7 @@ func LogInfoLegacy(message string) {
 // 参数:
 //   - message: 要记录的日志消息
 func LogDebugLegacy(message string) {
-	LogDebug("0000", message)
+	LogDebug(systemTaskID, message)
 }
 
 // LogErrorLegacy 记录ERROR级别日志消息（兼容旧版本）
@@ -247,7 +743,15 @@ func LogDebugLegacy(message string) {
 // 参数:
 //   - message: 要记录的日志消息
 func LogErrorLegacy(message string) {
-	LogError("0000", message)
+	LogError(systemTaskID, message)
+}
+
+// LogWarnLegacy 记录WARN级别日志消息（兼容旧版本）
+//
+// 参数:
+//   - message: 要记录的日志消息
+func LogWarnLegacy(message string) {
+	LogWarn(systemTaskID, message)
 }
 
 // LogSuccessLegacy 记录SUCCESS级别日志消息（兼容旧版本）
@@ -255,5 +759,5 @@ func LogErrorLegacy(message string) {
 // 参数:
 //   - message: 要记录的日志消息
 func LogSuccessLegacy(message string) {
-	LogSuccess("0000", message)
+	LogSuccess(systemTaskID, message)
 }
\ No newline at end of file
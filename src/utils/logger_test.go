@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestFlattenHeaders(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+		want   map[string]string
+	}{
+		{
+			name:   "单值头",
+			header: http.Header{"Content-Type": {"application/json"}},
+			want:   map[string]string{"Content-Type": "application/json"},
+		},
+		{
+			name:   "多值头用逗号连接",
+			header: http.Header{"Vary": {"Origin", "Accept-Encoding"}},
+			want:   map[string]string{"Vary": "Origin, Accept-Encoding"},
+		},
+		{
+			name:   "Set-Cookie用换行连接",
+			header: http.Header{"Set-Cookie": {"a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT", "b=2"}},
+			want:   map[string]string{"Set-Cookie": "a=1; Expires=Wed, 21 Oct 2026 07:28:00 GMT\nb=2"},
+		},
+		{
+			name:   "非规范大小写的Set-Cookie",
+			header: http.Header{"set-cookie": {"a=1", "b=2"}},
+			want:   map[string]string{"set-cookie": "a=1\nb=2"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]string)
+			FlattenHeaders(tt.header, got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("得到%q，期望%q", got, tt.want)
+			}
+		})
+	}
+}
+
+// testLogData 构造包含请求体和响应体的请求日志
+func testLogData(model string) *RequestLogData {
+	return &RequestLogData{
+		TaskID:            "task-1",
+		Model:             model,
+		Success:           true,
+		DownstreamRequest: &RequestDetails{Method: "POST", URL: "/v1/messages", Body: "downstream"},
+		UpstreamRequest: &RequestDetails{
+			Method:          "POST",
+			URL:             "https://upstream.example.com/v1/messages",
+			Body:            "upstream",
+			OriginalBody:    "original",
+			TransformedBody: "transformed",
+		},
+		UpstreamResponse: &ResponseDetails{StatusCode: 200, Body: "response"},
+	}
+}
+
+func TestApplyLogVerbosity(t *testing.T) {
+	tests := []struct {
+		name       string
+		defaultLV  string
+		modelLV    map[string]string
+		model      string
+		wantSave   bool
+		wantBodies bool
+	}{
+		{name: "默认完整记录", defaultLV: "full", model: "claude-sonnet-4-5", wantSave: true, wantBodies: true},
+		{name: "默认只记录元数据", defaultLV: "metadata", model: "claude-sonnet-4-5", wantSave: true},
+		{name: "默认不记录", defaultLV: "none", model: "claude-sonnet-4-5"},
+		{name: "模型覆盖为不记录", defaultLV: "full", modelLV: map[string]string{"claude-opus-4-1": "none"}, model: "claude-opus-4-1"},
+		{name: "模型覆盖为元数据", defaultLV: "none", modelLV: map[string]string{"claude-opus-4-1": "metadata"}, model: "claude-opus-4-1", wantSave: true},
+		{name: "模型覆盖为完整记录", defaultLV: "none", modelLV: map[string]string{"claude-opus-4-1": "full"}, model: "claude-opus-4-1", wantSave: true, wantBodies: true},
+		{name: "其他模型使用默认值", defaultLV: "metadata", modelLV: map[string]string{"claude-opus-4-1": "none"}, model: "claude-haiku-4-5", wantSave: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadTestConfig(t, "")
+			cfg.Logging.DefaultVerbosity = tt.defaultLV
+			cfg.Logging.ModelVerbosity = tt.modelLV
+
+			original := testLogData(tt.model)
+			got, save := applyLogVerbosity(original)
+			if save != tt.wantSave {
+				t.Fatalf("是否写入日志为%t，期望%t", save, tt.wantSave)
+			}
+			if !save {
+				return
+			}
+
+			bodies := []string{
+				got.DownstreamRequest.Body,
+				got.UpstreamRequest.Body,
+				got.UpstreamRequest.OriginalBody,
+				got.UpstreamRequest.TransformedBody,
+				got.UpstreamResponse.Body,
+			}
+			for _, body := range bodies {
+				if (body != "") != tt.wantBodies {
+					t.Fatalf("请求体和响应体为%q，期望保留: %t", bodies, tt.wantBodies)
+				}
+			}
+			// 元数据仍然保留，且不修改调用方的日志数据
+			if got.UpstreamResponse.StatusCode != 200 || got.UpstreamRequest.URL == "" || got.Model != tt.model {
+				t.Fatalf("元数据丢失: %+v", got)
+			}
+			if original.UpstreamResponse.Body != "response" || original.DownstreamRequest.Body != "downstream" {
+				t.Fatalf("不应修改原始日志数据")
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// WatchSystemPrompts 监听系统提示词目录，文件修改时重新加载，删除时移除对应模型的提示词
+//
+// 参数:
+//   - promptDir: 提示词文件目录路径
+//
+// 返回值:
+//   - func(): 停止监听的函数
+//   - error: 可能的错误
+func WatchSystemPrompts(promptDir string) (func(), error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, fmt.Errorf("创建文件监听器失败: %v", err)
+	}
+	if err := watcher.Add(promptDir); err != nil {
+		watcher.Close()
+		return nil, fmt.Errorf("监听系统提示词目录失败: %v", err)
+	}
+
+	go func() {
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				handlePromptFileEvent(event)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				LogErrorLegacy("监听系统提示词目录出错: " + err.Error())
+			}
+		}
+	}()
+
+	LogInfoLegacy("已开启系统提示词目录监听: " + promptDir)
+	return func() { watcher.Close() }, nil
+}
+
+// WatchSystemPromptsFromDefault 监听默认的系统提示词目录
+//
+// 返回值:
+//   - func(): 停止监听的函数
+//   - error: 可能的错误
+func WatchSystemPromptsFromDefault() (func(), error) {
+	return WatchSystemPrompts("system_prompt")
+}
+
+// handlePromptFileEvent 处理单个提示词文件的变更事件
+//
+// 参数:
+//   - event: 文件变更事件
+func handlePromptFileEvent(event fsnotify.Event) {
+	ext := filepath.Ext(event.Name)
+	if !promptFileExtensions[ext] {
+		return
+	}
+
+	// 删除或重命名（移出目录）时移除对应模型
+	if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+		if model := globalSystemPromptCache.removeFile(event.Name); model != "" {
+			LogInfoLegacy("系统提示词文件已删除，已移除模型提示词: " + model)
+		}
+		return
+	}
+
+	if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+		return
+	}
+
+	pf := &promptFile{
+		modelName: strings.TrimSuffix(filepath.Base(event.Name), ext),
+		filePath:  event.Name,
+	}
+	pf.load()
+	if pf.err != nil {
+		// 编辑器保存过程中可能读到不完整的内容，保留原有提示词，等待下一次写入事件
+		LogErrorLegacy(fmt.Sprintf("重新加载系统提示词文件失败 %s: %v", pf.filePath, pf.err))
+		return
+	}
+
+	if len(pf.content) == 0 {
+		// 覆盖写入时文件会先被截断，空内容的事件直接忽略
+		return
+	}
+
+	globalSystemPromptCache.setFromFile(pf)
+	LogInfoLegacy(fmt.Sprintf("已重新加载系统提示词: %s (%d bytes)", pf.modelName, len(pf.content)))
+}
@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"claude-mimic-gateway/config"
+)
+
+// logRetentionInterval 日志清理的执行间隔
+const logRetentionInterval = time.Hour
+
+// logFileTimeLayout 日志文件名中的时间格式（东八区时间）
+const logFileTimeLayout = "20060102150405"
+
+// StartLogRetention 启动后台日志清理协程，按配置删除过期或超出数量的日志文件
+//
+// 参数:
+//   - cfg: 配置实例
+//
+// 返回值:
+//   - func(): 停止清理协程的函数，会等待正在进行的清理结束
+func StartLogRetention(cfg *config.Config) func() {
+	retentionDays := cfg.Logging.RetentionDays
+	maxFiles := cfg.Logging.MaxFiles
+	if retentionDays <= 0 && maxFiles <= 0 {
+		return func() {}
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(logRetentionInterval)
+		defer ticker.Stop()
+
+		for {
+			pruneLogDirectories(retentionDays, maxFiles)
+			select {
+			case <-ticker.C:
+			case <-stop:
+				return
+			}
+		}
+	}()
+
+	LogDebugLegacy(fmt.Sprintf("日志清理已启动，保留天数: %d，最大文件数: %d", retentionDays, maxFiles))
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// pruneLogDirectories 清理logs、errors和进行中日志目录中的日志文件
+//
+// 进程异常退出时进行中日志不会被删除，同样按保留天数和文件数清理；
+// 进行中日志按修改时间排序，仍在更新的日志最新，不会因数量超出而被优先删除
+//
+// 参数:
+//   - retentionDays: 保留天数，0表示不按时间清理
+//   - maxFiles: 每个目录最多保留的文件数，0表示不按数量清理
+func pruneLogDirectories(retentionDays, maxFiles int) {
+	for _, dir := range []string{"logs", "errors", inProgressLogDir} {
+		removed := pruneLogDirectory(dir, retentionDays, maxFiles)
+		if removed > 0 {
+			LogDebugLegacy(fmt.Sprintf("已清理 %s 目录中的 %d 个日志文件", dir, removed))
+		}
+	}
+}
+
+// pruneLogDirectory 清理单个目录中的日志文件
+//
+// 参数:
+//   - dir: 日志目录
+//   - retentionDays: 保留天数，0表示不按时间清理
+//   - maxFiles: 最多保留的文件数，0表示不按数量清理
+//
+// 返回值:
+//   - int: 删除的文件数量
+func pruneLogDirectory(dir string, retentionDays, maxFiles int) int {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		LogErrorLegacy(fmt.Sprintf("读取日志目录失败 %s: %v", dir, err))
+		return 0
+	}
+
+	type logFile struct {
+		path    string
+		written time.Time
+	}
+	var files []logFile
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".log") {
+			continue
+		}
+		written, ok := logFileTime(entry)
+		if !ok {
+			continue
+		}
+		files = append(files, logFile{path: filepath.Join(dir, entry.Name()), written: written})
+	}
+
+	// 按时间从新到旧排序
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].written.After(files[j].written)
+	})
+
+	cutoff := time.Now().AddDate(0, 0, -retentionDays)
+	removed := 0
+	for i, file := range files {
+		expired := retentionDays > 0 && file.written.Before(cutoff)
+		overflow := maxFiles > 0 && i >= maxFiles
+		if !expired && !overflow {
+			continue
+		}
+		if err := os.Remove(file.path); err != nil && !os.IsNotExist(err) {
+			LogErrorLegacy(fmt.Sprintf("删除日志文件失败 %s: %v", file.path, err))
+			continue
+		}
+		removed++
+	}
+	return removed
+}
+
+// logFileTime 获取日志文件的写入时间，优先解析文件名中的时间戳，失败时使用修改时间
+//
+// 参数:
+//   - entry: 目录项
+//
+// 返回值:
+//   - time.Time: 写入时间
+//   - bool: 是否获取成功
+func logFileTime(entry os.DirEntry) (time.Time, bool) {
+	name := entry.Name()
+	if len(name) >= len(logFileTimeLayout) {
+		// 文件名使用东八区时间
+		if written, err := time.ParseInLocation(logFileTimeLayout, name[:len(logFileTimeLayout)], time.UTC); err == nil {
+			return written.Add(-8 * time.Hour), true
+		}
+	}
+
+	info, err := entry.Info()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return info.ModTime(), true
+}
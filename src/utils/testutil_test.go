@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"claude-mimic-gateway/config"
+)
+
+// testConfigYAML 测试用的最小配置，配置user_id避免生成.user_id文件，并关闭请求日志落盘
+const testConfigYAML = `upstream:
+  url: "https://upstream.example.com/v1/messages?beta=true"
+  key: "sk-ant-test"
+auth:
+  key: "gateway-test-key"
+server:
+  port: 8080
+gateway:
+  user_id: "user_test"
+logging:
+  save_requests: false
+`
+
+// loadTestConfig 从临时文件加载配置作为当前配置，extra追加在默认配置之后
+//
+// extra中不能再次出现默认配置已有的顶层配置项；测试可直接修改返回的实例，测试结束后清除配置实例
+func loadTestConfig(t *testing.T, extra string) *config.Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(testConfigYAML+extra), 0600); err != nil {
+		t.Fatalf("写入配置文件失败: %v", err)
+	}
+	cfg, err := config.LoadConfigFresh(path)
+	if err != nil {
+		t.Fatalf("加载配置失败: %v", err)
+	}
+	t.Cleanup(config.ResetConfig)
+	return cfg
+}
+
+// resetPromptCache 清空全局系统提示词缓存，测试结束后再次清空
+func resetPromptCache(t *testing.T) {
+	t.Helper()
+
+	clear := func() {
+		globalSystemPromptCache.mu.Lock()
+		globalSystemPromptCache.cache = make(map[string]string)
+		globalSystemPromptCache.cacheControl = make(map[string]bool)
+		globalSystemPromptCache.files = make(map[string]string)
+		globalSystemPromptCache.mu.Unlock()
+	}
+	clear()
+	t.Cleanup(clear)
+}
@@ -1,11 +1,16 @@
 package utils
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -51,18 +56,31 @@ var claudeCodeSystemMessage = &SystemMessage{
 }
 
 
-// 请求体大小阈值（字节）
-const requestBodySizeThreshold = 20000
+// ErrRequestBodyTooLarge 请求体超过解析前允许的大小
+var ErrRequestBodyTooLarge = errors.New("请求体过大")
+
+// ErrInvalidRequest 请求体格式错误，属于客户端请求问题
+var ErrInvalidRequest = errors.New("请求格式错误")
+
+// ErrBareRequest 请求最终只包含Claude Code伪装消息，没有任何用户或模型系统提示词
+var ErrBareRequest = errors.New("请求缺少系统提示词")
+
+// ErrPromptNotFound 找不到模型对应的系统提示词文件
+var ErrPromptNotFound = errors.New("系统提示词文件不存在")
 
 // SystemPromptCache 系统提示词缓存管理
 type SystemPromptCache struct {
-	mu    sync.RWMutex
-	cache map[string]string
+	mu           sync.RWMutex
+	cache        map[string]string
+	cacheControl map[string]bool // JSON提示词文件中按模型指定的cache_control开关
+	files        map[string]string // 提示词文件路径到模型名称的映射，用于文件删除时移除对应模型
 }
 
 // 全局系统提示词缓存实例
 var globalSystemPromptCache = &SystemPromptCache{
-	cache: make(map[string]string),
+	cache:        make(map[string]string),
+	cacheControl: make(map[string]bool),
+	files:        make(map[string]string),
 }
 
 // Set 设置模型的系统提示词
@@ -74,6 +92,22 @@ func (spc *SystemPromptCache) Set(model, prompt string) {
 	spc.mu.Lock()
 	defer spc.mu.Unlock()
 	spc.cache[model] = prompt
+	delete(spc.cacheControl, model)
+}
+
+// GetCacheControl 获取模型系统提示词单独指定的cache_control开关
+//
+// 参数:
+//   - model: 模型名称
+//
+// 返回值:
+//   - bool: 是否添加cache_control
+//   - bool: 是否单独指定
+func (spc *SystemPromptCache) GetCacheControl(model string) (bool, bool) {
+	spc.mu.RLock()
+	defer spc.mu.RUnlock()
+	enabled, exists := spc.cacheControl[model]
+	return enabled, exists
 }
 
 // Get 获取模型的系统提示词
@@ -105,6 +139,21 @@ func (spc *SystemPromptCache) Has(model string) bool {
 	return exists
 }
 
+// Sizes 获取每个模型系统提示词的字节数
+//
+// 返回值:
+//   - map[string]int: 模型名称到提示词字节数的映射
+func (spc *SystemPromptCache) Sizes() map[string]int {
+	spc.mu.RLock()
+	defer spc.mu.RUnlock()
+
+	sizes := make(map[string]int, len(spc.cache))
+	for model, prompt := range spc.cache {
+		sizes[model] = len(prompt)
+	}
+	return sizes
+}
+
 // SetSystemPrompt 设置模型系统提示词到全局缓存
 //
 // 参数:
@@ -135,34 +184,232 @@ func LoadSystemPrompts(promptDir string) (int, error) {
 		return 0, fmt.Errorf("读取系统提示词目录失败: %v", err)
 	}
 
-	loadedCount := 0
+	// 收集需要加载的提示词文件，ReadDir已按文件名排序
+	var promptFiles []*promptFile
 	for _, file := range files {
-		// 只处理.txt文件
-		if file.IsDir() || !strings.HasSuffix(file.Name(), ".txt") {
+		// 只处理.txt、.md和.json文件
+		ext := filepath.Ext(file.Name())
+		if file.IsDir() || !promptFileExtensions[ext] {
 			continue
 		}
 
-		// 提取模型名称（去掉.txt扩展名）
-		modelName := strings.TrimSuffix(file.Name(), ".txt")
-		filePath := filepath.Join(promptDir, file.Name())
+		// 默认以去掉扩展名的文件名作为模型名称
+		promptFiles = append(promptFiles, &promptFile{
+			modelName: strings.TrimSuffix(file.Name(), ext),
+			filePath:  filepath.Join(promptDir, file.Name()),
+		})
+	}
 
-		// 读取文件内容
-		content, err := ioutil.ReadFile(filePath)
-		if err != nil {
-			LogErrorLegacy(fmt.Sprintf("读取系统提示词文件失败 %s: %v", filePath, err))
+	// 使用有界工作池并行读取文件内容
+	workers := promptLoadWorkers()
+	jobs := make(chan *promptFile)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for pf := range jobs {
+				pf.load()
+			}
+		}()
+	}
+	for _, pf := range promptFiles {
+		jobs <- pf
+	}
+	close(jobs)
+	wg.Wait()
+
+	// 按文件顺序依次写入缓存，保证覆盖行为确定
+	loadedCount := 0
+	for _, pf := range promptFiles {
+		if pf.err != nil {
+			LogErrorLegacy(fmt.Sprintf("读取系统提示词文件失败 %s: %v", pf.filePath, pf.err))
 			continue
 		}
 
 		// 将内容存储到缓存中
-		globalSystemPromptCache.Set(modelName, string(content))
+		globalSystemPromptCache.setFromFile(pf)
 		loadedCount++
-		LogDebugLegacy(fmt.Sprintf("已加载系统提示词: %s (%d bytes)", modelName, len(content)))
+		LogDebugLegacy(fmt.Sprintf("已加载系统提示词: %s (%d bytes)", pf.modelName, len(pf.content)))
 	}
 
 	LogDebugLegacy(fmt.Sprintf("系统提示词加载完成，共加载 %d 个模型的提示词", loadedCount))
 	return loadedCount, nil
 }
 
+// ReloadSystemPrompt 从磁盘重新读取单个模型的系统提示词文件
+//
+// 优先使用该模型已加载的文件（JSON文件可能通过model字段指定模型名称），
+// 否则按.txt、.md、.json的顺序查找以模型名称命名的文件
+//
+// 参数:
+//   - promptDir: 提示词文件目录路径
+//   - model: 模型名称
+//
+// 返回值:
+//   - int: 重新加载后的提示词字节数
+//   - error: 文件不存在时返回ErrPromptNotFound
+func ReloadSystemPrompt(promptDir, model string) (int, error) {
+	// 模型名称用于拼接文件路径，不能包含目录
+	if model == "" || model != filepath.Base(model) || model == ".." {
+		return 0, ErrPromptNotFound
+	}
+
+	filePath := globalSystemPromptCache.fileOf(model)
+	if filePath == "" {
+		for _, ext := range []string{".txt", ".md", ".json"} {
+			candidate := filepath.Join(promptDir, model+ext)
+			if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+				filePath = candidate
+				break
+			}
+		}
+	}
+	if filePath == "" {
+		return 0, ErrPromptNotFound
+	}
+
+	pf := &promptFile{
+		modelName: strings.TrimSuffix(filepath.Base(filePath), filepath.Ext(filePath)),
+		filePath:  filePath,
+	}
+	pf.load()
+	if os.IsNotExist(pf.err) {
+		return 0, ErrPromptNotFound
+	}
+	if pf.err != nil {
+		return 0, fmt.Errorf("读取系统提示词文件失败 %s: %v", filePath, pf.err)
+	}
+
+	globalSystemPromptCache.setFromFile(pf)
+	LogInfoLegacy(fmt.Sprintf("已重新加载系统提示词: %s (%d bytes)", pf.modelName, len(pf.content)))
+	return len(pf.content), nil
+}
+
+// fileOf 获取模型已加载的提示词文件路径
+//
+// 参数:
+//   - model: 模型名称
+//
+// 返回值:
+//   - string: 文件路径，未从文件加载时为空字符串
+func (spc *SystemPromptCache) fileOf(model string) string {
+	spc.mu.RLock()
+	defer spc.mu.RUnlock()
+
+	for filePath, fileModel := range spc.files {
+		if fileModel == model {
+			return filePath
+		}
+	}
+	return ""
+}
+
+// setFromFile 将提示词文件的内容写入缓存并记录文件与模型的对应关系
+//
+// 同一文件改用新的模型名称时（如修改了JSON的model字段），移除原模型的提示词
+//
+// 参数:
+//   - pf: 已读取的提示词文件
+func (spc *SystemPromptCache) setFromFile(pf *promptFile) {
+	spc.mu.Lock()
+	defer spc.mu.Unlock()
+
+	if previous, ok := spc.files[pf.filePath]; ok && previous != pf.modelName {
+		delete(spc.cache, previous)
+		delete(spc.cacheControl, previous)
+	}
+	spc.files[pf.filePath] = pf.modelName
+
+	spc.cache[pf.modelName] = string(pf.content)
+	delete(spc.cacheControl, pf.modelName)
+	if pf.cacheControl != nil {
+		spc.cacheControl[pf.modelName] = *pf.cacheControl
+	}
+}
+
+// removeFile 移除提示词文件对应模型的提示词
+//
+// 参数:
+//   - filePath: 提示词文件路径
+//
+// 返回值:
+//   - string: 被移除的模型名称，文件未加载过时为空字符串
+func (spc *SystemPromptCache) removeFile(filePath string) string {
+	spc.mu.Lock()
+	defer spc.mu.Unlock()
+
+	model, ok := spc.files[filePath]
+	if !ok {
+		return ""
+	}
+	delete(spc.files, filePath)
+	delete(spc.cache, model)
+	delete(spc.cacheControl, model)
+	return model
+}
+
+// promptFileExtensions 支持的系统提示词文件扩展名
+var promptFileExtensions = map[string]bool{
+	".txt":  true,
+	".md":   true,
+	".json": true,
+}
+
+// promptFile 待加载的系统提示词文件
+type promptFile struct {
+	modelName    string
+	filePath     string
+	content      []byte
+	cacheControl *bool
+	err          error
+}
+
+// jsonPromptFile JSON格式的系统提示词文件
+type jsonPromptFile struct {
+	Model        string `json:"model"`         // 模型名称，为空时使用文件名
+	Prompt       string `json:"prompt"`        // 系统提示词内容
+	CacheControl *bool  `json:"cache_control"` // 是否添加cache_control，为空时按gateway.cache_breakpoints处理
+}
+
+// load 读取提示词文件内容
+//
+// .txt和.md文件直接使用文件内容；.json文件读取prompt字段，
+// 并优先使用model字段作为模型名称
+func (pf *promptFile) load() {
+	pf.content, pf.err = ioutil.ReadFile(pf.filePath)
+	if pf.err != nil || filepath.Ext(pf.filePath) != ".json" {
+		return
+	}
+
+	var parsed jsonPromptFile
+	if err := json.Unmarshal(pf.content, &parsed); err != nil {
+		pf.err = fmt.Errorf("解析JSON失败: %v", err)
+		return
+	}
+	if parsed.Prompt == "" {
+		pf.err = fmt.Errorf("prompt字段不能为空")
+		return
+	}
+
+	if parsed.Model != "" {
+		pf.modelName = parsed.Model
+	}
+	pf.content = []byte(parsed.Prompt)
+	pf.cacheControl = parsed.CacheControl
+}
+
+// promptLoadWorkers 获取系统提示词并行加载的工作协程数量
+//
+// 返回值:
+//   - int: 工作协程数量，至少为1
+func promptLoadWorkers() int {
+	if cfg := config.GetConfig(); cfg != nil && cfg.Gateway.PromptLoadWorkers > 0 {
+		return cfg.Gateway.PromptLoadWorkers
+	}
+	return 1
+}
+
 // LoadSystemPromptsFromDefault 从默认目录加载系统提示词
 //
 // 返回值:
@@ -187,57 +434,123 @@ func GetAvailableModels() []string {
 	return models
 }
 
+// GetSystemPromptSizes 获取已加载的每个模型系统提示词的字节数
+//
+// 返回值:
+//   - map[string]int: 模型名称到提示词字节数的映射
+func GetSystemPromptSizes() map[string]int {
+	return globalSystemPromptCache.Sizes()
+}
+
+// TransformResult 请求体转换结果
+type TransformResult struct {
+	Body      []byte   // 转换后的请求体
+	Stream    bool     // 最终确定的流式模式
+	Decisions []string // 转换过程中的决策描述，用于调试
+	Model     string   // 请求的模型名称，经过别名映射时为上游实际的模型名称
+
+	RequestedModel string // 下游请求的模型名称，经过别名映射时与Model不同
+}
+
 // TransformRequestBody 转换请求体以符合Claude Code标准
 //
 // 参数:
 //   - body: 原始请求体字节数组
+//   - acceptEventStream: 下游请求头Accept是否声明接受text/event-stream
+//   - userID: 注入metadata.user_id的值，为空时使用gateway.user_id
 //
 // 返回值:
-//   - []byte: 转换后的请求体字节数组
+//   - *TransformResult: 转换结果，包含转换后的请求体和流式模式
 //   - error: 可能的错误
-func TransformRequestBody(body []byte) ([]byte, error) {
+func TransformRequestBody(body []byte, acceptEventStream bool, userID string) (*TransformResult, error) {
+	return transformMessagesBody(body, acceptEventStream, userID, true)
+}
+
+// transformMessagesBody 执行Messages请求体的转换流程
+//
+// 参数:
+//   - body: 原始请求体字节数组
+//   - acceptEventStream: 下游请求头Accept是否声明接受text/event-stream
+//   - userID: 注入metadata.user_id的值，为空时使用gateway.user_id
+//   - requireMaxTokens: 是否要求请求体包含max_tokens，count_tokens接口不需要
+//
+// 返回值:
+//   - *TransformResult: 转换结果，包含转换后的请求体和流式模式
+//   - error: 可能的错误
+func transformMessagesBody(body []byte, acceptEventStream bool, userID string, requireMaxTokens bool) (*TransformResult, error) {
+	cfg := config.GetConfig()
+	if cfg == nil {
+		return nil, fmt.Errorf("无法获取配置实例")
+	}
+
+	// 解析前检查请求体大小与嵌套深度，避免畸形输入消耗过多资源
+	if err := checkBodyLimits(body, cfg.Gateway.MaxBodyBytes, cfg.Gateway.MaxJSONDepth); err != nil {
+		return nil, err
+	}
+
 	// 解析原始请求体为map，保持灵活性
 	var originalBody map[string]interface{}
-	if err := json.Unmarshal(body, &originalBody); err != nil {
-		return nil, fmt.Errorf("解析原始请求体失败: %v", err)
+	if err := decodeRequestBody(body, &originalBody); err != nil {
+		return nil, err
 	}
+	applyAcceptStream(originalBody, acceptEventStream)
 
 	// 阶段1: 验证请求体格式
 	if err := validateRequestBody(originalBody); err != nil {
 		return nil, err
 	}
 
+	// 阶段1.5: 将模型别名映射为实际的模型名称，后续处理都按实际名称进行
+	requestedModel, _ := originalBody["model"].(string)
+	resolveModelAlias(originalBody, cfg)
+
 	// 阶段2: 修复请求内容
 	if err := repairRequestContent(originalBody); err != nil {
-		LogErrorLegacy("修复请求内容失败: " + err.Error())
+		LogWarnLegacy("修复请求内容失败: " + err.Error())
 		// 修复失败不阻止继续处理
 	}
 
 	// 阶段3: 优化模型参数
 	if err := optimizeModelParameters(originalBody); err != nil {
-		LogErrorLegacy("优化模型参数失败: " + err.Error())
+		LogWarnLegacy("优化模型参数失败: " + err.Error())
 		// 优化失败不阻止继续处理
 	}
 
-	// 阶段4: 添加metadata参数（现有逻辑）
-	cfg := config.GetConfig()
-	if cfg == nil {
-		return nil, fmt.Errorf("无法获取配置实例")
+	// 阶段3.5: 检查顶层字段并注入配置的默认值
+	applyRequestFieldDefaults(originalBody, cfg)
+
+	// 默认值可能补充了max_tokens，注入后再检查
+	if requireMaxTokens {
+		if _, exists := originalBody["max_tokens"]; !exists {
+			LogErrorLegacy("请求体缺少max_tokens字段")
+			return nil, fmt.Errorf("%w: 缺少max_tokens字段", ErrInvalidRequest)
+		}
 	}
 
+	// 阶段4: 添加metadata参数（现有逻辑）
 	originalBody["metadata"] = map[string]interface{}{
-		"user_id": cfg.Gateway.UserID,
+		"user_id": metadataUserID(cfg, userID),
 	}
 
 	// 阶段5: 处理system参数（现有逻辑）
-	if err := processSystemMessages(originalBody); err != nil {
+	systemDecision, err := processSystemMessages(originalBody)
+	if err != nil {
 		return nil, fmt.Errorf("处理系统消息失败: %v", err)
 	}
 
-	// 阶段6: 处理temperature、top_p、max_tokens范围
-	processlimit(originalBody,"temperature",0,1)
-	processlimit(originalBody,"top_p",0,1)
-	processlimit(originalBody,"max_tokens",4096,64000)
+	// 检查是否为裸请求（仅有Claude Code伪装消息）
+	if err := checkBareRequest(originalBody, cfg.Policy.BareRequestAction); err != nil {
+		return nil, err
+	}
+
+	// 阶段6: 处理temperature、top_p、max_tokens范围，优先使用模型特定的范围
+	limits := parameterLimits(originalBody, cfg)
+	for _, key := range []string{"temperature", "top_p", "max_tokens"} {
+		processlimit(originalBody, key, limits[key][0], limits[key][1])
+	}
+
+	// 阶段7: 应用部署策略上限
+	applyTemperaturePolicy(originalBody, cfg.Policy.MaxTemperature)
 
 	// 重新序列化
 	transformedBody, err := json.Marshal(originalBody)
@@ -245,7 +558,300 @@ func TransformRequestBody(body []byte) ([]byte, error) {
 		return nil, fmt.Errorf("序列化转换后的请求体失败: %v", err)
 	}
 
-	return transformedBody, nil
+	// 流式模式由转换后发往上游的请求体决定，与上游实际的响应方式一致
+	stream := parseStreamValue(originalBody["stream"])
+
+	model, _ := originalBody["model"].(string)
+
+	// 记录转换决策，供调试模式输出
+	systemBlocks, _ := originalBody["system"].([]interface{})
+	decisions := []string{
+		"system: " + systemDecision,
+		fmt.Sprintf("system blocks: %d", len(systemBlocks)),
+		fmt.Sprintf("stream=%t", stream),
+	}
+	if requestedModel != model {
+		decisions = append(decisions, fmt.Sprintf("model alias %s -> %s", requestedModel, model))
+	}
+
+	return &TransformResult{
+		Body:           transformedBody,
+		Stream:         stream,
+		Decisions:      decisions,
+		Model:          model,
+		RequestedModel: requestedModel,
+	}, nil
+}
+
+// TransformCountTokensBody 转换count_tokens请求体
+//
+// 与TransformRequestBody使用相同的转换流程，使统计结果包含注入的系统提示词，
+// 但会去掉count_tokens接口不接受的metadata等字段
+//
+// 参数:
+//   - body: 原始请求体字节数组
+//   - acceptEventStream: 下游请求头Accept是否声明接受text/event-stream，count_tokens接口忽略该值
+//   - userID: 注入metadata.user_id的值，转换后metadata会被去掉
+//
+// 返回值:
+//   - *TransformResult: 转换结果，Stream始终为false
+//   - error: 可能的错误
+func TransformCountTokensBody(body []byte, acceptEventStream bool, userID string) (*TransformResult, error) {
+	result, err := transformMessagesBody(body, false, userID, false)
+	if err != nil {
+		return nil, err
+	}
+
+	var transformed map[string]interface{}
+	if err := json.Unmarshal(result.Body, &transformed); err != nil {
+		return nil, fmt.Errorf("解析转换后的请求体失败: %v", err)
+	}
+	for _, field := range []string{"metadata", "stream", "max_tokens", "temperature", "top_p", "top_k"} {
+		delete(transformed, field)
+	}
+
+	result.Body, err = json.Marshal(transformed)
+	if err != nil {
+		return nil, fmt.Errorf("序列化转换后的请求体失败: %v", err)
+	}
+	result.Stream = false
+	return result, nil
+}
+
+// TransformCompleteBody 转换旧版/v1/complete请求体
+//
+// Text Completions请求使用prompt字符串而不是messages，不进行系统提示词注入，
+// 只做格式校验、补充metadata.user_id并确定流式模式
+//
+// 参数:
+//   - body: 原始请求体字节数组
+//   - acceptEventStream: 下游请求头Accept是否声明接受text/event-stream
+//   - userID: 注入metadata.user_id的值，为空时使用gateway.user_id
+//
+// 返回值:
+//   - *TransformResult: 转换结果
+//   - error: 可能的错误
+func TransformCompleteBody(body []byte, acceptEventStream bool, userID string) (*TransformResult, error) {
+	cfg := config.GetConfig()
+	if cfg == nil {
+		return nil, fmt.Errorf("无法获取配置实例")
+	}
+
+	if err := checkBodyLimits(body, cfg.Gateway.MaxBodyBytes, cfg.Gateway.MaxJSONDepth); err != nil {
+		return nil, err
+	}
+
+	var originalBody map[string]interface{}
+	if err := decodeRequestBody(body, &originalBody); err != nil {
+		return nil, err
+	}
+	if _, ok := originalBody["prompt"].(string); !ok {
+		return nil, fmt.Errorf("%w: prompt字段应为字符串", ErrInvalidRequest)
+	}
+	applyAcceptStream(originalBody, acceptEventStream)
+
+	requestedModel, _ := originalBody["model"].(string)
+	resolveModelAlias(originalBody, cfg)
+
+	originalBody["metadata"] = map[string]interface{}{
+		"user_id": metadataUserID(cfg, userID),
+	}
+
+	transformedBody, err := json.Marshal(originalBody)
+	if err != nil {
+		return nil, fmt.Errorf("序列化转换后的请求体失败: %v", err)
+	}
+
+	stream := parseStreamValue(originalBody["stream"])
+	model, _ := originalBody["model"].(string)
+
+	return &TransformResult{
+		Body:           transformedBody,
+		Stream:         stream,
+		Decisions:      []string{"legacy complete request, system injection skipped", fmt.Sprintf("stream=%t", stream)},
+		Model:          model,
+		RequestedModel: requestedModel,
+	}, nil
+}
+
+// metadataUserID 获取注入metadata.user_id的值
+//
+// 参数:
+//   - cfg: 配置实例
+//   - userID: 下游密钥对应的user_id
+//
+// 返回值:
+//   - string: userID不为空时返回userID，否则返回gateway.user_id
+func metadataUserID(cfg *config.Config, userID string) string {
+	if userID != "" {
+		return userID
+	}
+	return cfg.Gateway.UserID
+}
+
+// applyAcceptStream 根据Accept请求头补充stream字段
+//
+// 请求体中的stream字段优先：已显式指定（包括false）时不做修改，
+// 仅在未指定且Accept请求头声明接受text/event-stream时设为true，使上游同样返回流式响应
+//
+// 参数:
+//   - body: 请求体映射
+//   - acceptEventStream: 下游请求头Accept是否声明接受text/event-stream
+func applyAcceptStream(body map[string]interface{}, acceptEventStream bool) {
+	if !acceptEventStream {
+		return
+	}
+	if _, ok := body["stream"]; ok {
+		return
+	}
+	body["stream"] = true
+	LogDebugLegacy("请求体未指定stream，根据Accept: text/event-stream按流式请求处理")
+}
+
+// parseStreamValue 解析stream字段的值
+//
+// 参数:
+//   - value: stream字段值，可能为布尔值或字符串
+//
+// 返回值:
+//   - bool: 是否为流式请求，无法识别时默认为非流式
+func parseStreamValue(value interface{}) bool {
+	switch v := value.(type) {
+	case bool:
+		return v
+	case string:
+		if streamBool, err := strconv.ParseBool(v); err == nil {
+			return streamBool
+		}
+	}
+	return false
+}
+
+// applyRequestFieldDefaults 为缺失的顶层字段注入默认值，并记录未知字段
+//
+// 参数:
+//   - body: 请求体映射
+//   - cfg: 配置实例
+func applyRequestFieldDefaults(body map[string]interface{}, cfg *config.Config) {
+	recognized := make(map[string]bool, len(cfg.RequestFields.Recognized))
+	for _, field := range cfg.RequestFields.Recognized {
+		recognized[field] = true
+	}
+	for field := range body {
+		if !recognized[field] {
+			LogDebugLegacy("请求包含未知的顶层字段，直接透传: " + field)
+		}
+	}
+
+	for field, value := range cfg.RequestFields.Defaults {
+		if _, exists := body[field]; !exists {
+			body[field] = value
+			LogDebugLegacy("已注入顶层字段默认值: " + field)
+		}
+	}
+}
+
+// decodeRequestBody 解析请求体JSON，请求体中只能有一个JSON值
+//
+// 参数:
+//   - body: 原始请求体字节数组
+//   - v: 解析结果
+//
+// 返回值:
+//   - error: 解析失败或JSON值之后还有其他内容时返回ErrInvalidRequest
+func decodeRequestBody(body []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	if err := decoder.Decode(v); err != nil {
+		return fmt.Errorf("%w: 解析原始请求体失败: %v", ErrInvalidRequest, err)
+	}
+	if err := decoder.Decode(&struct{}{}); err != io.EOF {
+		return fmt.Errorf("%w: 解析原始请求体失败: JSON之后存在多余内容", ErrInvalidRequest)
+	}
+	return nil
+}
+
+// checkBodyLimits 在解析JSON前检查请求体大小和嵌套深度
+//
+// 上限来自gateway.max_body_bytes和gateway.max_json_depth，配置为0时加载配置已填充默认值，
+// 因此不存在"不限制"的配置；传入非正数时跳过对应检查
+//
+// 参数:
+//   - body: 原始请求体字节数组
+//   - maxBytes: 允许的最大字节数
+//   - maxDepth: 允许的最大嵌套深度
+//
+// 返回值:
+//   - error: 超过字节数上限时返回ErrRequestBodyTooLarge，超过嵌套深度上限时返回ErrInvalidRequest
+func checkBodyLimits(body []byte, maxBytes int64, maxDepth int) error {
+	if maxBytes > 0 && int64(len(body)) > maxBytes {
+		return fmt.Errorf("%w: %d bytes 超过上限 %d bytes", ErrRequestBodyTooLarge, len(body), maxBytes)
+	}
+
+	if maxDepth <= 0 {
+		return nil
+	}
+
+	// 扫描括号计算嵌套深度，跳过字符串中的内容
+	depth := 0
+	inString := false
+	escaped := false
+	for _, c := range body {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch c {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > maxDepth {
+				return fmt.Errorf("%w: JSON嵌套深度超过上限 %d", ErrInvalidRequest, maxDepth)
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+
+	return nil
+}
+
+// defaultParameterLimits 默认的参数范围
+var defaultParameterLimits = map[string][2]float32{
+	"temperature": {0, 1},
+	"top_p":       {0, 1},
+	"max_tokens":  {4096, 64000},
+}
+
+// parameterLimits 获取请求模型对应的参数范围，未配置的参数使用默认范围
+//
+// 参数:
+//   - body: 请求体映射
+//   - cfg: 配置实例
+//
+// 返回值:
+//   - map[string][2]float32: 参数名到[最小值, 最大值]的映射
+func parameterLimits(body map[string]interface{}, cfg *config.Config) map[string][2]float32 {
+	limits := make(map[string][2]float32, len(defaultParameterLimits))
+	for key, value := range defaultParameterLimits {
+		limits[key] = value
+	}
+
+	model, _ := body["model"].(string)
+	for key, value := range cfg.Limits[model] {
+		if _, known := limits[key]; known && len(value) == 2 {
+			limits[key] = [2]float32{float32(value[0]), float32(value[1])}
+		}
+	}
+	return limits
 }
 
 // processlimit 尝试把参数限制在合理范围
@@ -276,6 +882,27 @@ func processlimit(body map[string]interface{}, key string, min, max float32) {
 	body[key] = float64(max)
 }
 
+// applyTemperaturePolicy 应用temperature策略上限，在API范围修正之后执行
+//
+// 参数:
+//   - body: 请求体映射
+//   - maxTemperature: 策略上限，为nil时不处理
+func applyTemperaturePolicy(body map[string]interface{}, maxTemperature *float64) {
+	if maxTemperature == nil {
+		return
+	}
+
+	v, ok := body["temperature"]
+	if !ok {
+		return
+	}
+
+	if f, ok := toFloat64(v); ok && f > *maxTemperature {
+		LogDebugLegacy(fmt.Sprintf("temperature %.2f 超过策略上限 %.2f 进行修正", f, *maxTemperature))
+		body["temperature"] = *maxTemperature
+	}
+}
+
 // toFloat64 尝试把各种数值类型转为 float64
 //
 // 参数:
@@ -321,8 +948,9 @@ func toFloat64(v interface{}) (float64, bool) {
 //   - body: 请求体映射
 //
 // 返回值:
+//   - string: 本次处理所做决策的简短描述
 //   - error: 可能的错误
-func processSystemMessages(body map[string]interface{}) error {
+func processSystemMessages(body map[string]interface{}) (string, error) {
 	// 检查是否存在system字段
 	systemField, exists := body["system"]
 	if !exists {
@@ -332,64 +960,372 @@ func processSystemMessages(body map[string]interface{}) error {
 	// 将system字段转换为slice
 	systemSlice, ok := systemField.([]interface{})
 	if !ok {
-		return fmt.Errorf("system字段格式不正确，应为数组")
+		return "", fmt.Errorf("system字段格式不正确，应为数组")
 	}
 
 	// 检查第一项是否为Claude Code系统消息
 	if len(systemSlice) > 0 && isClaudeCodeMessage(systemSlice[0]) {
 		LogDebugLegacy("该请求为Claude Code系统消息 > 直接转发")
-		return nil
+		return "claude code request, system passthrough", nil
 	}
 
-	// 计算请求体大小
-	bodyBytes, err := json.Marshal(body)
-	if err != nil {
-		return fmt.Errorf("序列化请求体失败: %v", err)
+	// 配置为跳过注入的模型只插入Claude Code系统消息
+	model, _ := body["model"].(string)
+	if skipInjection(model) {
+		LogDebugLegacy("模型 " + model + " 配置为跳过注入官方提示词")
+		return prependClaudeCodeMessage(body, systemSlice, "model in skip_injection_models, kept system"), nil
+	}
+
+	// 计算请求体大小，大请求体只计算到超过阈值为止
+	threshold := injectThreshold()
+	var contentLength int
+	if sizeBasis() == "text" {
+		contentLength = requestTextLength(body)
+	} else {
+		var err error
+		contentLength, err = requestContentLength(body, threshold)
+		if err != nil {
+			return "", err
+		}
 	}
-	contentLength := len(bodyBytes)
 
 	var newSystemSlice []interface{}
+	var decision string
 
 	// 如果请求体小于阈值，需要注入官方提示词避免风控
-	if contentLength < requestBodySizeThreshold {
+	if contentLength < threshold {
 		LogDebugLegacy(fmt.Sprintf("Content-Length: %d 内容太短 需要注入官方提示词避免风控", contentLength))
+		decision = fmt.Sprintf("content length %d below threshold %d, merged system", contentLength, threshold)
 
 		// 处理现有system消息：合并多个system消息并添加XML标签
 		if len(systemSlice) > 0 {
-			wrappedMessage := mergeAndWrapSystemMessages(systemSlice)
+			mergeSlice, extraSlice := limitMergedSystemMessages(systemSlice)
+			wrappedMessage := mergeAndWrapSystemMessages(mergeSlice)
 			if wrappedMessage != nil {
 				newSystemSlice = append(newSystemSlice, wrappedMessage)
 			}
+			newSystemSlice = append(newSystemSlice, extraSlice...)
 		}
 
 		// 注册官方模型提示词信息
-		if model, ok := body["model"].(string); ok && model != "" {
+		if model != "" {
 			if globalSystemPromptCache.Has(model) {
 				if systemPromptContent, exists := globalSystemPromptCache.Get(model); exists {
 					modelSystemMessage := createModelSystemMessage(systemPromptContent)
+					if enabled, ok := globalSystemPromptCache.GetCacheControl(model); ok {
+						modelSystemMessage.CacheControl = nil
+						if enabled {
+							modelSystemMessage.CacheControl = &CacheControl{Type: "ephemeral"}
+						}
+					}
 					newSystemSlice = append(newSystemSlice, modelSystemMessage)
 					LogDebugLegacy(fmt.Sprintf("已注入模型 %s 的系统提示词", model))
+					decision += ", injected model prompt"
 				}
 			}else{
 				LogDebugLegacy("模型提示词不存在 :" + model)
+				decision += ", no model prompt available"
 			}
 		}
 	} else {
 		// 请求体大小足够，保持原有system消息
 		newSystemSlice = systemSlice
+		decision = fmt.Sprintf("content length %d above threshold %d, kept system", contentLength, threshold)
 	}
 
-	// 设置Claude Code系统消息为首位，伪装成Claude Code请求
-	finalSystemSlice := make([]interface{}, 0, len(newSystemSlice)+1)
+	return prependClaudeCodeMessage(body, newSystemSlice, decision), nil
+}
+
+// prependClaudeCodeMessage 将Claude Code系统消息设置为system数组首位，伪装成Claude Code请求
+//
+// 参数:
+//   - body: 请求体映射，system字段被替换为新数组
+//   - systemSlice: 插入Claude Code系统消息之前的system数组
+//   - decision: 已做出决策的简短描述
+//
+// 返回值:
+//   - string: 追加缓存断点处理结果后的决策描述
+func prependClaudeCodeMessage(body map[string]interface{}, systemSlice []interface{}, decision string) string {
+	finalSystemSlice := make([]interface{}, 0, len(systemSlice)+1)
 	finalSystemSlice = append(finalSystemSlice, claudeCodeSystemMessage)
-	finalSystemSlice = append(finalSystemSlice, newSystemSlice...)
+	finalSystemSlice = append(finalSystemSlice, systemSlice...)
+
+	// 控制缓存断点数量，避免超出上游限制被拒绝；上限由tools、system和messages共用，
+	// 下游在tools和messages中设置的断点保持不变，system只能使用剩余的名额
+	limit := maxCacheBreakpoints() - countCacheBreakpoints(body["tools"]) - countCacheBreakpoints(body["messages"])
+	if limit < 0 {
+		limit = 0
+	}
+	if dropped := limitCacheBreakpoints(finalSystemSlice, limit); dropped > 0 {
+		LogDebugLegacy(fmt.Sprintf("缓存断点超出上限，已移除 %d 个低优先级块的cache_control", dropped))
+		decision += fmt.Sprintf(", dropped %d cache breakpoints", dropped)
+	}
 
 	body["system"] = finalSystemSlice
 	LogDebugLegacy("已将Claude Code系统消息插入到system数组首位")
 
+	return decision
+}
+
+// requestContentLength 计算请求体序列化为JSON后的大小
+//
+// 遍历请求体直接计算序列化长度，不为大小检查单独序列化整个请求体，
+// 每个请求只在转换结束时序列化一次；长度达到阈值即停止遍历，
+// 含大量base64图片的请求体因此只需遍历到超过阈值为止
+//
+// 参数:
+//   - body: 请求体映射
+//   - threshold: 注入官方提示词的阈值
+//
+// 返回值:
+//   - int: 请求体大小，不小于threshold时可能只是部分结果
+//   - error: 计算失败时的错误
+func requestContentLength(body map[string]interface{}, threshold int) (int, error) {
+	size, err := jsonEncodedSize(body, threshold)
+	if err != nil {
+		return 0, fmt.Errorf("计算请求体大小失败: %v", err)
+	}
+	return size, nil
+}
+
+// requestTextLength 计算请求中文本内容的总字节数
+//
+// 累计messages和system中text块的文本（含tool_result中的文本），
+// 不计图片、文档等非文本块以及工具定义
+//
+// 参数:
+//   - body: 请求体映射
+//
+// 返回值:
+//   - int: 文本内容的总字节数
+func requestTextLength(body map[string]interface{}) int {
+	length := contentTextLength(body["system"])
+	if messages, ok := body["messages"].([]interface{}); ok {
+		for _, message := range messages {
+			if messageMap, ok := message.(map[string]interface{}); ok {
+				length += contentTextLength(messageMap["content"])
+			}
+		}
+	}
+	return length
+}
+
+// contentTextLength 计算消息内容中文本的字节数
+//
+// 参数:
+//   - content: 字符串内容或内容块数组
+//
+// 返回值:
+//   - int: 文本的字节数
+func contentTextLength(content interface{}) int {
+	switch c := content.(type) {
+	case string:
+		return len(c)
+	case []interface{}:
+		length := 0
+		for _, block := range c {
+			blockMap, ok := block.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch blockMap["type"] {
+			case "text":
+				text, _ := blockMap["text"].(string)
+				length += len(text)
+			case "tool_result":
+				length += contentTextLength(blockMap["content"])
+			}
+		}
+		return length
+	default:
+		return 0
+	}
+}
+
+// checkBareRequest 检查请求是否仅包含Claude Code伪装消息
+//
+// 这类请求既没有用户系统提示词也没有注入模型提示词，最容易触发上游风控
+//
+// 参数:
+//   - body: 已处理system字段的请求体映射
+//   - action: 处理方式，off不检查，warn记录警告，block拒绝请求
+//
+// 返回值:
+//   - error: action为block且检测到裸请求时返回ErrBareRequest
+func checkBareRequest(body map[string]interface{}, action string) error {
+	if action == "off" {
+		return nil
+	}
+
+	systemSlice, _ := body["system"].([]interface{})
+	if len(systemSlice) > 1 {
+		return nil
+	}
+
+	model, _ := body["model"].(string)
+	if action == "block" {
+		LogErrorLegacy("检测到裸请求（无用户系统提示词且无模型提示词），已拒绝: " + model)
+		return ErrBareRequest
+	}
+
+	LogWarnLegacy("检测到裸请求（无用户系统提示词且无模型提示词），可能触发上游风控: " + model)
 	return nil
 }
 
+// resolveModelAlias 按gateway.model_aliases将请求体的model字段改写为实际的模型名称
+//
+// 参数:
+//   - body: 请求体映射
+//   - cfg: 配置实例
+//
+// 返回值:
+//   - bool: 是否做了改写
+func resolveModelAlias(body map[string]interface{}, cfg *config.Config) bool {
+	model, _ := body["model"].(string)
+	if model == "" {
+		return false
+	}
+	target, ok := cfg.Gateway.ModelAliases[model]
+	if !ok || target == model {
+		return false
+	}
+	body["model"] = target
+	return true
+}
+
+// skipInjection 判断模型是否配置为跳过注入官方提示词
+//
+// 参数:
+//   - model: 请求的模型名称
+//
+// 返回值:
+//   - bool: 模型在gateway.skip_injection_models中时返回true
+func skipInjection(model string) bool {
+	cfg := config.GetConfig()
+	if cfg == nil || model == "" {
+		return false
+	}
+	for _, m := range cfg.Gateway.SkipInjectionModels {
+		if m == model {
+			return true
+		}
+	}
+	return false
+}
+
+// injectThreshold 获取注入官方提示词的请求体大小阈值
+//
+// 返回值:
+//   - int: 阈值（字节），请求体小于该值时注入官方提示词
+func injectThreshold() int {
+	if cfg := config.GetConfig(); cfg != nil && cfg.Gateway.InjectThreshold != nil {
+		return *cfg.Gateway.InjectThreshold
+	}
+	return config.DefaultInjectThreshold
+}
+
+// sizeBasis 获取与注入阈值比较的大小计算方式
+//
+// 返回值:
+//   - string: full或text
+func sizeBasis() string {
+	if cfg := config.GetConfig(); cfg != nil && cfg.Gateway.SizeBasis != "" {
+		return cfg.Gateway.SizeBasis
+	}
+	return "full"
+}
+
+// maxCacheBreakpoints 获取system数组中缓存断点的数量上限
+//
+// 返回值:
+//   - int: 缓存断点数量上限
+func maxCacheBreakpoints() int {
+	if cfg := config.GetConfig(); cfg != nil && cfg.Gateway.MaxCacheBreakpoints > 0 {
+		return cfg.Gateway.MaxCacheBreakpoints
+	}
+	return 4
+}
+
+// countCacheBreakpoints 统计tools或messages中已有的缓存断点数量
+//
+// 递归检查所有对象的cache_control字段，包括消息content数组以及tool_result内嵌的content
+//
+// 参数:
+//   - value: tools或messages字段的值
+//
+// 返回值:
+//   - int: 缓存断点数量
+func countCacheBreakpoints(value interface{}) int {
+	switch v := value.(type) {
+	case []interface{}:
+		count := 0
+		for _, item := range v {
+			count += countCacheBreakpoints(item)
+		}
+		return count
+	case map[string]interface{}:
+		count := 0
+		if _, ok := v["cache_control"]; ok {
+			count++
+		}
+		if content, ok := v["content"].([]interface{}); ok {
+			count += countCacheBreakpoints(content)
+		}
+		return count
+	}
+	return 0
+}
+
+// limitCacheBreakpoints 将system数组中的缓存断点限制在上限以内
+//
+// 按优先级保留断点：Claude Code系统消息、网关注入的块、下游原有的块，
+// 同类中靠后的块优先（靠后的断点能缓存更长的前缀），其余块的cache_control被移除
+//
+// 参数:
+//   - systemSlice: 最终的system数组，超出上限时原地修改
+//   - limit: 缓存断点数量上限
+//
+// 返回值:
+//   - int: 移除的断点数量
+func limitCacheBreakpoints(systemSlice []interface{}, limit int) int {
+	var header, injected, original []int
+	for i := len(systemSlice) - 1; i >= 0; i-- {
+		switch block := systemSlice[i].(type) {
+		case *SystemMessage:
+			if block.CacheControl == nil {
+				continue
+			}
+			if block == claudeCodeSystemMessage {
+				header = append(header, i)
+			} else {
+				injected = append(injected, i)
+			}
+		case map[string]interface{}:
+			if _, ok := block["cache_control"]; ok {
+				original = append(original, i)
+			}
+		}
+	}
+
+	ordered := append(append(header, injected...), original...)
+	if len(ordered) <= limit {
+		return 0
+	}
+
+	for _, i := range ordered[limit:] {
+		switch block := systemSlice[i].(type) {
+		case *SystemMessage:
+			// 复制后修改，避免改动共享的Claude Code系统消息
+			stripped := *block
+			stripped.CacheControl = nil
+			systemSlice[i] = &stripped
+		case map[string]interface{}:
+			delete(block, "cache_control")
+		}
+	}
+	return len(ordered) - limit
+}
+
 // isClaudeCodeMessage 检查消息是否为Claude Code标准系统消息
 //
 // 参数:
@@ -429,6 +1365,49 @@ func isClaudeCodeMessage(message interface{}) bool {
 	return true
 }
 
+// limitMergedSystemMessages 按配置的上限拆分需要合并的系统消息
+//
+// 超出上限的text消息根据配置保留为独立的system块或直接丢弃
+//
+// 参数:
+//   - systemSlice: 系统消息数组
+//
+// 返回值:
+//   - []interface{}: 需要合并的系统消息
+//   - []interface{}: 超出上限、需要独立保留的系统消息
+func limitMergedSystemMessages(systemSlice []interface{}) ([]interface{}, []interface{}) {
+	cfg := config.GetConfig()
+	if cfg == nil || cfg.Gateway.MaxMergedSystemBlocks <= 0 {
+		return systemSlice, nil
+	}
+
+	limit := cfg.Gateway.MaxMergedSystemBlocks
+	var mergeSlice, extraSlice []interface{}
+	textCount := 0
+	droppedCount := 0
+	for _, msg := range systemSlice {
+		if messageMap, ok := msg.(map[string]interface{}); ok && messageMap["type"] == "text" {
+			textCount++
+			if textCount > limit {
+				if cfg.Gateway.SystemMergeOverflow == "truncate" {
+					droppedCount++
+				} else {
+					extraSlice = append(extraSlice, msg)
+				}
+				continue
+			}
+		}
+		mergeSlice = append(mergeSlice, msg)
+	}
+
+	if textCount > limit {
+		LogDebugLegacy(fmt.Sprintf("system消息数量 %d 超过合并上限 %d，独立保留 %d 个，丢弃 %d 个",
+			textCount, limit, len(extraSlice), droppedCount))
+	}
+
+	return mergeSlice, extraSlice
+}
+
 // mergeAndWrapSystemMessages 合并系统消息并用XML标签包装
 //
 // 参数:
@@ -458,14 +1437,31 @@ func mergeAndWrapSystemMessages(systemSlice []interface{}) *SystemMessage {
 
 	// 创建包装了XML标签的system消息
 	return &SystemMessage{
-		Type: "text",
-		Text: fmt.Sprintf("<system_prompt>\n%s\n</system_prompt>", combinedText),
-		CacheControl: &CacheControl{
-			Type: "ephemeral",
-		},
+		Type:         "text",
+		Text:         fmt.Sprintf("<system_prompt>\n%s\n</system_prompt>", combinedText),
+		CacheControl: injectedCacheControl("user_system"),
 	}
 }
 
+// injectedCacheControl 根据配置决定注入的system块是否设置缓存断点
+//
+// 参数:
+//   - block: 注入块的类型，user_system为合并后的用户系统消息，model_prompt为模型提示词
+//
+// 返回值:
+//   - *CacheControl: 缓存控制配置，不设置断点时为nil
+func injectedCacheControl(block string) *CacheControl {
+	placement := "all"
+	if cfg := config.GetConfig(); cfg != nil && cfg.Gateway.CacheBreakpoints != "" {
+		placement = cfg.Gateway.CacheBreakpoints
+	}
+
+	if placement == "all" || placement == block {
+		return &CacheControl{Type: "ephemeral"}
+	}
+	return nil
+}
+
 // createModelSystemMessage 创建模型特定的系统消息
 //
 // 参数:
@@ -475,11 +1471,9 @@ func mergeAndWrapSystemMessages(systemSlice []interface{}) *SystemMessage {
 //   - *SystemMessage: 模型系统消息
 func createModelSystemMessage(content string) *SystemMessage {
 	return &SystemMessage{
-		Type: "text",
-		Text: content,
-		CacheControl: &CacheControl{
-			Type: "ephemeral",
-		},
+		Type:         "text",
+		Text:         content,
+		CacheControl: injectedCacheControl("model_prompt"),
 	}
 }
 
@@ -489,13 +1483,19 @@ func createModelSystemMessage(content string) *SystemMessage {
 //   - body: 请求体映射
 //
 // 返回值:
-//   - error: 验证错误，格式异常时返回特定错误用于401响应
+//   - error: 验证错误，格式异常时返回ErrInvalidRequest
 func validateRequestBody(body map[string]interface{}) error {
-	// 检查system字段格式，如果存在且不为数组则返回401错误
+	// 检查model字段，缺失时上游必然拒绝，提前返回避免无效往返
+	if model, ok := body["model"].(string); !ok || model == "" {
+		LogErrorLegacy("model字段缺失或不是非空字符串")
+		return fmt.Errorf("%w: model字段应为非空字符串", ErrInvalidRequest)
+	}
+
+	// 检查system字段格式，如果存在且不为数组则返回格式错误
 	if systemField, exists := body["system"]; exists {
 		if _, ok := systemField.([]interface{}); !ok {
 			LogErrorLegacy("system字段格式异常，应为数组类型")
-			return fmt.Errorf("格式异常")
+			return fmt.Errorf("%w: system字段应为数组类型", ErrInvalidRequest)
 		}
 	}
 
@@ -542,6 +1542,8 @@ func repairRequestContent(body map[string]interface{}) error {
 
 // repairMessageContent 修复单个消息的content内容
 //
+// 查找content数组中所有空text块，根据紧随其后的块推断文件类型并填充
+//
 // 参数:
 //   - message: 消息映射
 //
@@ -555,44 +1557,90 @@ func repairMessageContent(message map[string]interface{}) bool {
 	}
 
 	contentArray, ok := contentField.([]interface{})
-	if !ok || len(contentArray) != 2 {
-		return false // 不是双元素数组，不符合修复条件
+	if !ok || len(contentArray) < 2 {
+		return false // 少于两个元素时没有可用于推断类型的后续块
 	}
 
-	// 检查第一个元素是否为空text
-	firstElement, ok1 := contentArray[0].(map[string]interface{})
-	secondElement, ok2 := contentArray[1].(map[string]interface{})
+	repaired := false
+	for i := 0; i < len(contentArray)-1; i++ {
+		element, ok := contentArray[i].(map[string]interface{})
+		if !ok {
+			continue
+		}
 
-	if !ok1 || !ok2 {
-		return false
-	}
+		// 检查当前元素是否为空的text类型
+		elementType, hasType := element["type"].(string)
+		text, hasText := element["text"].(string)
+		if !hasType || elementType != "text" || !hasText || text != "" {
+			continue
+		}
 
-	// 检查第一个元素是否为空的text类型
-	firstType, hasFirstType := firstElement["type"].(string)
-	firstText, hasFirstText := firstElement["text"].(string)
+		// 根据下一个元素推断文件类型
+		nextElement, ok := contentArray[i+1].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		fileType, ok := inferBlockFileType(nextElement)
+		if !ok {
+			continue
+		}
 
-	if !hasFirstType || firstType != "text" || !hasFirstText || firstText != "" {
-		return false // 不符合修复条件
-	}
+		// 修复当前元素的text内容
+		element["text"] = fileType + "文件"
+		repaired = true
 
-	// 检查第二个元素是否有text内容，用于推断文件类型
-	secondText, hasSecondText := secondElement["text"].(string)
-	if !hasSecondText {
-		return false
+		LogDebugLegacy("已修复content中的空text内容为: " + fileType + "文件")
 	}
 
-	// 根据第二个元素的内容推断文件类型
-	fileType := detectFileType(secondText)
+	return repaired
+}
+
+// inferBlockFileType 根据content块推断其对应的文件类型
+//
+// 参数:
+//   - block: content块
+//
+// 返回值:
+//   - string: 推断出的文件类型
+//   - bool: 是否能够推断
+func inferBlockFileType(block map[string]interface{}) (string, bool) {
+	// 有text内容时根据其中的文件名推断
+	if text, ok := block["text"].(string); ok {
+		return detectFileType(text), true
+	}
 
-	// 修复第一个元素的text内容
-	firstElement["text"] = fileType + "文件"
+	// 图片和文档块直接使用块类型
+	switch blockType, _ := block["type"].(string); blockType {
+	case "image":
+		return "image", true
+	case "document":
+		return "document", true
+	}
+	return "", false
+}
 
-	LogDebugLegacy("已修复content中的空text内容为: " + fileType + "文件")
-	return true
+// defaultFileTypes 内置的文件扩展名到文件类型的映射，可通过gateway.file_types覆盖或扩展
+var defaultFileTypes = map[string]string{
+	"txt": "text", "log": "text",
+	"jpg": "image", "jpeg": "image", "png": "image", "gif": "image", "webp": "image", "bmp": "image", "svg": "image",
+	"pdf": "pdf",
+	"doc": "document", "docx": "document", "odt": "document", "rtf": "document",
+	"xls": "spreadsheet", "xlsx": "spreadsheet", "ods": "spreadsheet", "csv": "spreadsheet", "tsv": "spreadsheet",
+	"ppt": "presentation", "pptx": "presentation", "odp": "presentation",
+	"md": "markdown", "markdown": "markdown",
+	"json": "json", "yaml": "yaml", "yml": "yaml", "xml": "xml", "html": "html", "htm": "html",
+	"py": "code", "go": "code", "js": "code", "ts": "code", "jsx": "code", "tsx": "code", "java": "code",
+	"c": "code", "h": "code", "cpp": "code", "cs": "code", "rs": "code", "rb": "code", "php": "code",
+	"swift": "code", "kt": "code", "sh": "code", "sql": "code",
 }
 
+// fileNamePattern 匹配内容中的文件名，捕获扩展名；要求扩展名前是文件名字符，扩展名后不再紧跟字母数字
+var fileNamePattern = regexp.MustCompile(`[\w-]\.([a-z0-9]+)\b`)
+
 // detectFileType 根据文件内容检测文件类型
 //
+// 按出现顺序查找内容中的文件名，返回第一个已知扩展名对应的类型
+//
 // 参数:
 //   - content: 文件内容字符串
 //
@@ -604,20 +1652,22 @@ func detectFileType(content string) string {
 		return "text"
 	}
 
-	// 根据内容特征检测文件类型
-	lowerContent := strings.ToLower(content)
-
-	if strings.Contains(lowerContent, ".jpg") || strings.Contains(lowerContent, ".png") ||
-	   strings.Contains(lowerContent, ".gif") || strings.Contains(lowerContent, ".jpeg") {
-		return "image"
-	}
-
-	if strings.Contains(lowerContent, ".pdf") {
-		return "pdf"
+	// 配置的映射优先于内置映射
+	var configured map[string]string
+	if cfg := config.GetConfig(); cfg != nil {
+		configured = cfg.Gateway.FileTypes
 	}
 
-	if strings.Contains(lowerContent, ".doc") || strings.Contains(lowerContent, ".docx") {
-		return "document"
+	for _, match := range fileNamePattern.FindAllStringSubmatch(strings.ToLower(content), -1) {
+		ext := match[1]
+		for configuredExt, fileType := range configured {
+			if strings.EqualFold(strings.TrimPrefix(configuredExt, "."), ext) {
+				return fileType
+			}
+		}
+		if fileType, ok := defaultFileTypes[ext]; ok {
+			return fileType
+		}
 	}
 
 	// 默认返回text类型
@@ -0,0 +1,733 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestApplyTemperaturePolicy(t *testing.T) {
+	ceiling := func(v float64) *float64 { return &v }
+
+	tests := []struct {
+		name        string
+		temperature interface{} // nil表示请求体不含temperature
+		limit       *float64
+		want        interface{}
+	}{
+		{name: "未配置上限", temperature: 0.9, limit: nil, want: 0.9},
+		{name: "低于上限", temperature: 0.3, limit: ceiling(0.7), want: 0.3},
+		{name: "等于上限", temperature: 0.7, limit: ceiling(0.7), want: 0.7},
+		{name: "超过上限", temperature: 0.95, limit: ceiling(0.7), want: 0.7},
+		{name: "整数超过上限", temperature: 1, limit: ceiling(0.5), want: 0.5},
+		{name: "上限为0", temperature: 0.2, limit: ceiling(0), want: 0.0},
+		{name: "非数值保持不变", temperature: "hot", limit: ceiling(0.7), want: "hot"},
+		{name: "未携带temperature", temperature: nil, limit: ceiling(0.7), want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]interface{}{"model": "claude-sonnet-4-5"}
+			if tt.temperature != nil {
+				body["temperature"] = tt.temperature
+			}
+
+			applyTemperaturePolicy(body, tt.limit)
+
+			got, ok := body["temperature"]
+			if tt.want == nil {
+				if ok {
+					t.Fatalf("不应添加temperature，得到%v", got)
+				}
+				return
+			}
+			if got != tt.want {
+				t.Fatalf("temperature为%v(%T)，期望%v(%T)", got, got, tt.want, tt.want)
+			}
+		})
+	}
+}
+
+func TestTemperaturePolicyAfterRangeClamp(t *testing.T) {
+	ceiling := 0.6
+	tests := []struct {
+		name        string
+		temperature float64
+		want        float64
+	}{
+		{name: "超出API范围后再受策略限制", temperature: 1.8, want: 0.6},
+		{name: "API范围内但超过策略上限", temperature: 0.8, want: 0.6},
+		{name: "均未超出", temperature: 0.4, want: 0.4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]interface{}{"temperature": tt.temperature}
+			processlimit(body, "temperature", 0, 1)
+			applyTemperaturePolicy(body, &ceiling)
+
+			got, ok := toFloat64(body["temperature"])
+			if !ok || got != tt.want {
+				t.Fatalf("temperature为%v，期望%v", body["temperature"], tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadSystemPromptsWithWorkers(t *testing.T) {
+	dir := t.TempDir()
+	const promptCount = 200
+	for i := 0; i < promptCount; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("model-%03d.txt", i))
+		if err := ioutil.WriteFile(name, []byte(fmt.Sprintf("prompt %d", i)), 0600); err != nil {
+			t.Fatalf("写入提示词文件失败: %v", err)
+		}
+	}
+	// 同一模型的多个文件按文件名顺序覆盖，不受并行读取的完成顺序影响
+	files := map[string]string{
+		"a-override.json": `{"model": "shared", "prompt": "first"}`,
+		"b-override.json": `{"model": "shared", "prompt": "second"}`,
+		"broken.json":     `{"model": `,
+		"notes.log":       "ignored",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatalf("写入提示词文件失败: %v", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "nested.txt"), 0700); err != nil {
+		t.Fatalf("创建子目录失败: %v", err)
+	}
+
+	for _, workers := range []int{1, 4, 64, promptCount * 2} {
+		t.Run(fmt.Sprintf("%d个工作协程", workers), func(t *testing.T) {
+			cfg := loadTestConfig(t, "")
+			cfg.Gateway.PromptLoadWorkers = workers
+			resetPromptCache(t)
+
+			loaded, err := LoadSystemPrompts(dir)
+			if err != nil {
+				t.Fatalf("LoadSystemPrompts失败: %v", err)
+			}
+			// 200个txt文件加上2个有效的JSON文件，损坏的JSON、其他扩展名和目录不计入
+			if loaded != promptCount+2 {
+				t.Fatalf("加载了%d个文件，期望%d", loaded, promptCount+2)
+			}
+			for i := 0; i < promptCount; i++ {
+				model := fmt.Sprintf("model-%03d", i)
+				if prompt, ok := globalSystemPromptCache.Get(model); !ok || prompt != fmt.Sprintf("prompt %d", i) {
+					t.Fatalf("%s的提示词为%q", model, prompt)
+				}
+			}
+			if prompt, _ := globalSystemPromptCache.Get("shared"); prompt != "second" {
+				t.Fatalf("shared的提示词为%q，期望后加载的second", prompt)
+			}
+			if globalSystemPromptCache.Has("broken") || globalSystemPromptCache.Has("notes") || globalSystemPromptCache.Has("nested") {
+				t.Fatalf("不应加载无效文件、其他扩展名或目录")
+			}
+		})
+	}
+}
+
+func TestLoadSystemPromptsMissingDir(t *testing.T) {
+	resetPromptCache(t)
+
+	loaded, err := LoadSystemPrompts(filepath.Join(t.TempDir(), "missing"))
+	if err != nil || loaded != 0 {
+		t.Fatalf("目录不存在时应返回0和nil，得到%d, %v", loaded, err)
+	}
+}
+
+func TestTransformResultStreamFollowsTransformedBody(t *testing.T) {
+	tests := []struct {
+		name     string
+		stream   string // 请求体中stream字段的JSON值，为空表示不携带
+		accept   bool
+		defaults map[string]interface{}
+		want     bool
+	}{
+		{name: "未指定", want: false},
+		{name: "显式开启", stream: "true", want: true},
+		{name: "字符串true", stream: `"true"`, want: true},
+		{name: "无法识别的值", stream: `"yes"`, want: false},
+		{name: "Accept补充stream", accept: true, want: true},
+		{name: "显式关闭时忽略Accept", stream: "false", accept: true, want: false},
+		{name: "默认值注入stream", defaults: map[string]interface{}{"stream": true}, want: true},
+		{name: "显式关闭时不注入默认值", stream: "false", defaults: map[string]interface{}{"stream": true}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadTestConfig(t, "")
+			cfg.RequestFields.Defaults = tt.defaults
+
+			body := `{"model":"claude-sonnet-4-5","max_tokens":16,"messages":[{"role":"user","content":"hi"}]`
+			if tt.stream != "" {
+				body += `,"stream":` + tt.stream
+			}
+			body += "}"
+
+			result, err := TransformRequestBody([]byte(body), tt.accept, "")
+			if err != nil {
+				t.Fatalf("TransformRequestBody失败: %v", err)
+			}
+			if result.Stream != tt.want {
+				t.Fatalf("Stream为%t，期望%t", result.Stream, tt.want)
+			}
+
+			// 流式模式与实际发往上游的请求体一致
+			var transformed map[string]interface{}
+			if err := json.Unmarshal(result.Body, &transformed); err != nil {
+				t.Fatalf("解析转换后的请求体失败: %v", err)
+			}
+			if got := parseStreamValue(transformed["stream"]); got != result.Stream {
+				t.Fatalf("转换后的请求体stream=%v，与Stream=%t不一致", transformed["stream"], result.Stream)
+			}
+			if !containsString(result.Decisions, fmt.Sprintf("stream=%t", tt.want)) {
+				t.Fatalf("转换决策%q未记录stream=%t", result.Decisions, tt.want)
+			}
+		})
+	}
+}
+
+// containsString 检查字符串切片是否包含指定值
+func containsString(values []string, want string) bool {
+	for _, value := range values {
+		if value == want {
+			return true
+		}
+	}
+	return false
+}
+
+// testSystemBlocks 生成count个text类型的system块，内容为block-000、block-001……
+func testSystemBlocks(count int) []interface{} {
+	blocks := make([]interface{}, count)
+	for i := range blocks {
+		blocks[i] = map[string]interface{}{"type": "text", "text": fmt.Sprintf("block-%03d", i)}
+	}
+	return blocks
+}
+
+func TestLimitMergedSystemMessages(t *testing.T) {
+	tests := []struct {
+		name      string
+		blocks    int
+		limit     int
+		overflow  string
+		wantMerge int
+		wantExtra int
+	}{
+		{name: "不限制", blocks: 100, limit: 0, overflow: "separate", wantMerge: 100},
+		{name: "未超出上限", blocks: 100, limit: 200, overflow: "separate", wantMerge: 100},
+		{name: "恰好等于上限", blocks: 100, limit: 100, overflow: "separate", wantMerge: 100},
+		{name: "超出部分独立保留", blocks: 100, limit: 5, overflow: "separate", wantMerge: 5, wantExtra: 95},
+		{name: "超出部分丢弃", blocks: 100, limit: 5, overflow: "truncate", wantMerge: 5},
+		{name: "上限为1", blocks: 3, limit: 1, overflow: "separate", wantMerge: 1, wantExtra: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadTestConfig(t, "")
+			cfg.Gateway.MaxMergedSystemBlocks = tt.limit
+			cfg.Gateway.SystemMergeOverflow = tt.overflow
+
+			mergeSlice, extraSlice := limitMergedSystemMessages(testSystemBlocks(tt.blocks))
+			if len(mergeSlice) != tt.wantMerge || len(extraSlice) != tt.wantExtra {
+				t.Fatalf("合并%d个、独立保留%d个，期望%d个和%d个", len(mergeSlice), len(extraSlice), tt.wantMerge, tt.wantExtra)
+			}
+			// 合并的是前面的块，独立保留的块保持原有顺序
+			for i, block := range append(mergeSlice, extraSlice...) {
+				if text := block.(map[string]interface{})["text"]; text != fmt.Sprintf("block-%03d", i) {
+					t.Fatalf("第%d个块为%v", i, text)
+				}
+			}
+		})
+	}
+}
+
+func TestLimitMergedSystemMessagesCountsOnlyText(t *testing.T) {
+	cfg := loadTestConfig(t, "")
+	cfg.Gateway.MaxMergedSystemBlocks = 2
+	cfg.Gateway.SystemMergeOverflow = "separate"
+
+	blocks := append([]interface{}{map[string]interface{}{"type": "image"}}, testSystemBlocks(3)...)
+	mergeSlice, extraSlice := limitMergedSystemMessages(blocks)
+	if len(mergeSlice) != 3 || len(extraSlice) != 1 {
+		t.Fatalf("合并%d个、独立保留%d个，期望非text块不计入上限", len(mergeSlice), len(extraSlice))
+	}
+}
+
+func TestProcessSystemMessagesWithManyBlocks(t *testing.T) {
+	tests := []struct {
+		name       string
+		limit      int
+		overflow   string
+		wantBlocks int // Claude Code系统消息 + 合并后的块 + 独立保留的块
+		lastMerged int
+	}{
+		{name: "不限制时全部合并", limit: 0, overflow: "separate", wantBlocks: 2, lastMerged: 99},
+		{name: "超出部分独立保留", limit: 10, overflow: "separate", wantBlocks: 92, lastMerged: 9},
+		{name: "超出部分丢弃", limit: 10, overflow: "truncate", wantBlocks: 2, lastMerged: 9},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadTestConfig(t, "")
+			cfg.Gateway.MaxMergedSystemBlocks = tt.limit
+			cfg.Gateway.SystemMergeOverflow = tt.overflow
+			resetPromptCache(t)
+
+			body := map[string]interface{}{
+				"model":    "claude-sonnet-4-5",
+				"system":   testSystemBlocks(100),
+				"messages": []interface{}{map[string]interface{}{"role": "user", "content": "hi"}},
+			}
+			if _, err := processSystemMessages(body); err != nil {
+				t.Fatalf("processSystemMessages失败: %v", err)
+			}
+
+			system := body["system"].([]interface{})
+			if len(system) != tt.wantBlocks {
+				t.Fatalf("system块数量为%d，期望%d", len(system), tt.wantBlocks)
+			}
+			if system[0] != interface{}(claudeCodeSystemMessage) {
+				t.Fatalf("第一个块应为Claude Code系统消息")
+			}
+
+			merged, err := json.Marshal(system[1])
+			if err != nil {
+				t.Fatalf("序列化合并后的块失败: %v", err)
+			}
+			if !strings.Contains(string(merged), fmt.Sprintf("block-%03d", tt.lastMerged)) {
+				t.Fatalf("合并后的块缺少block-%03d: %s", tt.lastMerged, merged)
+			}
+			if strings.Contains(string(merged), fmt.Sprintf("block-%03d", tt.lastMerged+1)) {
+				t.Fatalf("合并后的块不应包含block-%03d", tt.lastMerged+1)
+			}
+		})
+	}
+}
+
+func TestApplyRequestFieldDefaults(t *testing.T) {
+	defaults := map[string]interface{}{
+		"max_tokens":  4096,
+		"temperature": 0.5,
+		"metadata":    map[string]interface{}{"source": "gateway"},
+	}
+
+	tests := []struct {
+		name string
+		body map[string]interface{}
+		want map[string]interface{}
+	}{
+		{
+			name: "缺失字段注入默认值",
+			body: map[string]interface{}{"model": "claude-sonnet-4-5"},
+			want: map[string]interface{}{
+				"model":       "claude-sonnet-4-5",
+				"max_tokens":  4096,
+				"temperature": 0.5,
+				"metadata":    map[string]interface{}{"source": "gateway"},
+			},
+		},
+		{
+			name: "已有字段保持原值",
+			body: map[string]interface{}{"model": "claude-sonnet-4-5", "max_tokens": 256.0, "temperature": 0.0, "metadata": nil},
+			want: map[string]interface{}{"model": "claude-sonnet-4-5", "max_tokens": 256.0, "temperature": 0.0, "metadata": nil},
+		},
+		{
+			name: "未知字段原样透传",
+			body: map[string]interface{}{"model": "claude-sonnet-4-5", "max_tokens": 256.0, "temperature": 1.0, "metadata": nil, "x_custom": []interface{}{"a"}},
+			want: map[string]interface{}{"model": "claude-sonnet-4-5", "max_tokens": 256.0, "temperature": 1.0, "metadata": nil, "x_custom": []interface{}{"a"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadTestConfig(t, "")
+			cfg.RequestFields.Defaults = defaults
+
+			applyRequestFieldDefaults(tt.body, cfg)
+			if !reflect.DeepEqual(tt.body, tt.want) {
+				t.Fatalf("请求体为%v，期望%v", tt.body, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequestFieldDefaultsInTransformedBody(t *testing.T) {
+	// max_tokens的取值需在默认范围[4096, 64000]内，避免被范围修正改写
+	tests := []struct {
+		name     string
+		body     string
+		defaults map[string]interface{}
+		want     map[string]interface{} // 转换后请求体中应有的字段值
+		wantErr  bool
+	}{
+		{
+			name:     "默认值补充max_tokens",
+			body:     `{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"hi"}]}`,
+			defaults: map[string]interface{}{"max_tokens": 8192},
+			want:     map[string]interface{}{"max_tokens": 8192.0},
+		},
+		{
+			name:    "未配置默认值时缺少max_tokens",
+			body:    `{"model":"claude-sonnet-4-5","messages":[{"role":"user","content":"hi"}]}`,
+			wantErr: true,
+		},
+		{
+			name:     "请求中的值优先",
+			body:     `{"model":"claude-sonnet-4-5","max_tokens":16000,"messages":[{"role":"user","content":"hi"}]}`,
+			defaults: map[string]interface{}{"max_tokens": 8192},
+			want:     map[string]interface{}{"max_tokens": 16000.0},
+		},
+		{
+			name: "未知字段透传到上游",
+			body: `{"model":"claude-sonnet-4-5","max_tokens":64,"x_trace":{"id":"abc"},"messages":[{"role":"user","content":"hi"}]}`,
+			want: map[string]interface{}{"x_trace": map[string]interface{}{"id": "abc"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadTestConfig(t, "")
+			cfg.RequestFields.Defaults = tt.defaults
+
+			result, err := TransformRequestBody([]byte(tt.body), false, "")
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidRequest) {
+					t.Fatalf("错误为%v，期望ErrInvalidRequest", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("TransformRequestBody失败: %v", err)
+			}
+
+			var transformed map[string]interface{}
+			if err := json.Unmarshal(result.Body, &transformed); err != nil {
+				t.Fatalf("解析转换后的请求体失败: %v", err)
+			}
+			for field, want := range tt.want {
+				if got := transformed[field]; !reflect.DeepEqual(got, want) {
+					t.Fatalf("%s为%v，期望%v", field, got, want)
+				}
+			}
+		})
+	}
+}
+
+// systemCacheControls 返回转换后请求体中每个system块是否带有cache_control
+func systemCacheControls(t *testing.T, body []byte) []bool {
+	t.Helper()
+
+	var transformed struct {
+		System []map[string]interface{} `json:"system"`
+	}
+	if err := json.Unmarshal(body, &transformed); err != nil {
+		t.Fatalf("解析转换后的请求体失败: %v", err)
+	}
+	controls := make([]bool, len(transformed.System))
+	for i, block := range transformed.System {
+		_, controls[i] = block["cache_control"]
+	}
+	return controls
+}
+
+func TestCacheBreakpointsPlacement(t *testing.T) {
+	enabled, disabled := true, false
+
+	// system块依次为: Claude Code系统消息、合并后的用户系统消息、模型提示词
+	tests := []struct {
+		name      string
+		placement string
+		override  *bool // JSON提示词文件单独指定的cache_control
+		want      []bool
+	}{
+		{name: "all", placement: "all", want: []bool{true, true, true}},
+		{name: "model_prompt", placement: "model_prompt", want: []bool{true, false, true}},
+		{name: "user_system", placement: "user_system", want: []bool{true, true, false}},
+		{name: "none", placement: "none", want: []bool{true, false, false}},
+		{name: "提示词文件关闭断点", placement: "all", override: &disabled, want: []bool{true, true, false}},
+		{name: "提示词文件开启断点", placement: "none", override: &enabled, want: []bool{true, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadTestConfig(t, "")
+			cfg.Gateway.CacheBreakpoints = tt.placement
+			resetPromptCache(t)
+			globalSystemPromptCache.setFromFile(&promptFile{
+				modelName:    "claude-sonnet-4-5",
+				filePath:     "claude-sonnet-4-5.json",
+				content:      []byte("model prompt"),
+				cacheControl: tt.override,
+			})
+
+			body := `{"model":"claude-sonnet-4-5","max_tokens":8192,"system":[{"type":"text","text":"be brief"}],"messages":[{"role":"user","content":"hi"}]}`
+			result, err := TransformRequestBody([]byte(body), false, "")
+			if err != nil {
+				t.Fatalf("TransformRequestBody失败: %v", err)
+			}
+			if got := systemCacheControls(t, result.Body); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("各system块的cache_control为%v，期望%v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitCacheBreakpoints(t *testing.T) {
+	// 下游原有的块: 第i个是否带有cache_control
+	original := func(withCache ...bool) []interface{} {
+		blocks := make([]interface{}, len(withCache))
+		for i, cached := range withCache {
+			block := map[string]interface{}{"type": "text", "text": fmt.Sprintf("original-%d", i)}
+			if cached {
+				block["cache_control"] = map[string]interface{}{"type": "ephemeral"}
+			}
+			blocks[i] = block
+		}
+		return blocks
+	}
+	injected := func() *SystemMessage {
+		return &SystemMessage{Type: "text", Text: "injected", CacheControl: &CacheControl{Type: "ephemeral"}}
+	}
+
+	tests := []struct {
+		name        string
+		system      []interface{}
+		limit       int
+		wantDropped int
+		want        []bool
+	}{
+		{
+			name:   "未超出上限",
+			system: append([]interface{}{claudeCodeSystemMessage, injected()}, original(true, false)...),
+			limit:  4,
+			want:   []bool{true, true, true, false},
+		},
+		{
+			name:        "优先移除下游原有块中靠前的断点",
+			system:      append([]interface{}{claudeCodeSystemMessage, injected()}, original(true, true, true)...),
+			limit:       4,
+			wantDropped: 1,
+			want:        []bool{true, true, false, true, true},
+		},
+		{
+			name:        "其次移除注入块中靠前的断点",
+			system:      append([]interface{}{claudeCodeSystemMessage, injected(), injected()}, original(true)...),
+			limit:       2,
+			wantDropped: 2,
+			want:        []bool{true, false, true, false},
+		},
+		{
+			name:        "上限为1时只保留Claude Code系统消息",
+			system:      append([]interface{}{claudeCodeSystemMessage, injected()}, original(true)...),
+			limit:       1,
+			wantDropped: 2,
+			want:        []bool{true, false, false},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dropped := limitCacheBreakpoints(tt.system, tt.limit)
+			if dropped != tt.wantDropped {
+				t.Fatalf("移除了%d个断点，期望%d", dropped, tt.wantDropped)
+			}
+
+			data, err := json.Marshal(map[string]interface{}{"system": tt.system})
+			if err != nil {
+				t.Fatalf("序列化system失败: %v", err)
+			}
+			if got := systemCacheControls(t, data); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("各system块的cache_control为%v，期望%v", got, tt.want)
+			}
+			// 共享的Claude Code系统消息不能被修改
+			if claudeCodeSystemMessage.CacheControl == nil {
+				t.Fatalf("共享的Claude Code系统消息被修改")
+			}
+		})
+	}
+}
+
+func TestCheckBodyLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		body     string
+		maxBytes int64
+		maxDepth int
+		wantErr  error
+	}{
+		{name: "未超出限制", body: `{"a":[{"b":1}]}`, maxBytes: 100, maxDepth: 3},
+		{name: "超过字节数上限", body: `{"a":"0123456789"}`, maxBytes: 10, maxDepth: 3, wantErr: ErrRequestBodyTooLarge},
+		{name: "超过嵌套深度上限", body: `{"a":[[[1]]]}`, maxBytes: 100, maxDepth: 3, wantErr: ErrInvalidRequest},
+		{name: "字符串中的括号不计入深度", body: `{"a":"[[[[{{{{"}`, maxBytes: 100, maxDepth: 1},
+		{name: "转义引号后的括号仍在字符串中", body: `{"a":"\"[[[["}`, maxBytes: 100, maxDepth: 1},
+		{name: "非正数时跳过检查", body: `[[[[[[1]]]]]]`, maxBytes: 0, maxDepth: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBodyLimits([]byte(tt.body), tt.maxBytes, tt.maxDepth)
+			if tt.wantErr == nil {
+				if err != nil {
+					t.Fatalf("不应返回错误，得到%v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("错误为%v，期望%v", err, tt.wantErr)
+			}
+			// 嵌套过深不是请求体过大
+			if tt.wantErr == ErrInvalidRequest && errors.Is(err, ErrRequestBodyTooLarge) {
+				t.Fatalf("嵌套深度超限不应返回ErrRequestBodyTooLarge")
+			}
+		})
+	}
+}
+
+func TestTransformRejectsTrailingData(t *testing.T) {
+	const messages = `{"model":"claude-sonnet-4-5","max_tokens":8192,"messages":[{"role":"user","content":"hi"}]}`
+	const complete = `{"model":"claude-2.1","max_tokens_to_sample":16,"prompt":"\n\nHuman: hi\n\nAssistant:"}`
+
+	transforms := map[string]func([]byte, bool, string) (*TransformResult, error){
+		"messages":     TransformRequestBody,
+		"count_tokens": TransformCountTokensBody,
+		"complete":     TransformCompleteBody,
+	}
+	bodies := map[string]string{"messages": messages, "count_tokens": messages, "complete": complete}
+
+	for name, transform := range transforms {
+		for _, suffix := range []string{" garbage", `{"model":"other"}`, "]"} {
+			t.Run(name+suffix, func(t *testing.T) {
+				loadTestConfig(t, "")
+
+				_, err := transform([]byte(bodies[name]+suffix), false, "")
+				if !errors.Is(err, ErrInvalidRequest) {
+					t.Fatalf("错误为%v，期望ErrInvalidRequest", err)
+				}
+			})
+		}
+
+		t.Run(name+"末尾空白", func(t *testing.T) {
+			loadTestConfig(t, "")
+
+			if _, err := transform([]byte(bodies[name]+"\n  \n"), false, ""); err != nil {
+				t.Fatalf("末尾只有空白时不应返回错误: %v", err)
+			}
+		})
+	}
+}
+
+func TestCountCacheBreakpoints(t *testing.T) {
+	cached := func(block map[string]interface{}) map[string]interface{} {
+		block["cache_control"] = map[string]interface{}{"type": "ephemeral"}
+		return block
+	}
+
+	tests := []struct {
+		name  string
+		value interface{}
+		want  int
+	}{
+		{name: "未携带", value: nil, want: 0},
+		{name: "tools", value: []interface{}{
+			map[string]interface{}{"name": "a"},
+			cached(map[string]interface{}{"name": "b"}),
+		}, want: 1},
+		{name: "字符串content的消息", value: []interface{}{
+			map[string]interface{}{"role": "user", "content": "hi"},
+		}, want: 0},
+		{name: "content块和tool_result内嵌的content", value: []interface{}{
+			map[string]interface{}{"role": "user", "content": []interface{}{
+				cached(map[string]interface{}{"type": "text", "text": "a"}),
+				map[string]interface{}{"type": "tool_result", "content": []interface{}{
+					cached(map[string]interface{}{"type": "text", "text": "b"}),
+				}},
+			}},
+			map[string]interface{}{"role": "assistant", "content": []interface{}{
+				cached(map[string]interface{}{"type": "text", "text": "c"}),
+			}},
+		}, want: 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countCacheBreakpoints(tt.value); got != tt.want {
+				t.Fatalf("断点数量为%d，期望%d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCacheBreakpointsShareLimitWithToolsAndMessages(t *testing.T) {
+	const cacheControl = `"cache_control":{"type":"ephemeral"}`
+	message := func(cached bool) string {
+		block := `{"type":"text","text":"hi"`
+		if cached {
+			block += "," + cacheControl
+		}
+		return `{"role":"user","content":[` + block + `}]}`
+	}
+
+	// system块依次为: Claude Code系统消息、合并后的用户系统消息、模型提示词，默认均带有断点
+	tests := []struct {
+		name           string
+		toolBreaks     int
+		messageBreaks  int
+		wantSystem     []bool
+		wantTotalBreak int
+	}{
+		{name: "tools和messages没有断点", wantSystem: []bool{true, true, true}, wantTotalBreak: 3},
+		{name: "messages已有3个断点", messageBreaks: 3, wantSystem: []bool{true, false, false}, wantTotalBreak: 4},
+		{name: "tools和messages各1个断点", toolBreaks: 1, messageBreaks: 1, wantSystem: []bool{true, false, true}, wantTotalBreak: 4},
+		{name: "messages已用满上限", messageBreaks: 4, wantSystem: []bool{false, false, false}, wantTotalBreak: 4},
+		{name: "messages超出上限时不修改", messageBreaks: 5, wantSystem: []bool{false, false, false}, wantTotalBreak: 5},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadTestConfig(t, "")
+			cfg.Gateway.CacheBreakpoints = "all"
+			cfg.Gateway.MaxCacheBreakpoints = 4
+			resetPromptCache(t)
+			SetSystemPrompt("claude-sonnet-4-5", "model prompt")
+
+			var tools, messages []string
+			for i := 0; i < tt.toolBreaks; i++ {
+				tools = append(tools, fmt.Sprintf(`{"name":"tool_%d","input_schema":{"type":"object"},%s}`, i, cacheControl))
+			}
+			for i := 0; i < tt.messageBreaks || i == 0; i++ {
+				messages = append(messages, message(i < tt.messageBreaks))
+			}
+			body := `{"model":"claude-sonnet-4-5","max_tokens":8192,"system":[{"type":"text","text":"be brief"}],` +
+				`"tools":[` + strings.Join(tools, ",") + `],"messages":[` + strings.Join(messages, ",") + `]}`
+
+			result, err := TransformRequestBody([]byte(body), false, "")
+			if err != nil {
+				t.Fatalf("TransformRequestBody失败: %v", err)
+			}
+			got := systemCacheControls(t, result.Body)
+			if !reflect.DeepEqual(got, tt.wantSystem) {
+				t.Fatalf("各system块的cache_control为%v，期望%v", got, tt.wantSystem)
+			}
+
+			var transformed map[string]interface{}
+			if err := json.Unmarshal(result.Body, &transformed); err != nil {
+				t.Fatalf("解析转换后的请求体失败: %v", err)
+			}
+			total := countCacheBreakpoints(transformed["tools"]) + countCacheBreakpoints(transformed["messages"]) + countCacheBreakpoints(transformed["system"])
+			if total != tt.wantTotalBreak {
+				t.Fatalf("请求中共有%d个断点，期望%d", total, tt.wantTotalBreak)
+			}
+		})
+	}
+}